@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+const transferTimeout = 5 * time.Minute
+
+// attachments uploads and downloads files on behalf of the logged in user.
+type attachments struct {
+	url    string
+	tokens *tokens
+	tls    *tls.Config // nil for cleartext
+}
+
+// upload sends the file as multipart form data and returns the attachment the server recorded.
+func (a attachments) upload(ctx context.Context, path string) (*models.Attachment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("chat_client upload os.Open(...): %w", err)
+	}
+	defer file.Close()
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+
+		if err == nil {
+			err = form.Close()
+		}
+
+		writer.CloseWithError(err) //nolint:errcheck,gosec
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, body)
+	if err != nil {
+		return nil, fmt.Errorf("chat_client upload http.NewRequestWithContext(...): %w", err)
+	}
+
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := a.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("chat_client upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Data models.Attachment `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("chat_client upload json.Decode(...): %w", err)
+	}
+
+	return &response.Data, nil
+}
+
+// download saves the attachment into the directory under the name it was uploaded with
+// and returns the path, an existing file is never overwritten.
+func (a attachments) download(ctx context.Context, attachmentID string, dir string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url+"/"+attachmentID, nil)
+	if err != nil {
+		return "", fmt.Errorf("chat_client download http.NewRequestWithContext(...): %w", err)
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return "", fmt.Errorf("chat_client download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	name := attachmentID
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if base := filepath.Base(params["filename"]); base != "." && base != string(filepath.Separator) {
+			name = base
+		}
+	}
+
+	path := filepath.Join(dir, name)
+
+	const filePerm = 0o600
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePerm)
+	if err != nil {
+		return "", fmt.Errorf("chat_client download os.OpenFile(...): %w", err)
+	}
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()    //nolint:errcheck,gosec
+		os.Remove(path) //nolint:errcheck,gosec
+
+		return "", fmt.Errorf("chat_client download io.Copy(...): %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("chat_client download file.Close(): %w", err)
+	}
+
+	return path, nil
+}
+
+// do sends the authenticated request, an error status is turned into an error with the server's message.
+func (a attachments) do(req *http.Request) (*http.Response, error) {
+	token, err := a.tokens.access(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	c := newHTTPClient(a.tls, transferTimeout)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("c.Do(...): %w", err)
+	}
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	var errResp models.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+		errResp.Error = resp.Status
+	}
+
+	return nil, fmt.Errorf("server error: %s, %w", errResp.Error, models.ErrWrongStatusCode)
+}
+
+// sendAttachment sends "/attach <path> [@user] [text]" as a message with the uploaded file
+// to the current room or to the user.
+func sendAttachment(ctx context.Context, sess *session, files attachments, input string, currentRoom string) error {
+	const attachParts = 3
+
+	parts := strings.SplitN(input, " ", attachParts)
+	if len(parts) < 2 || parts[1] == "" {
+		return fmt.Errorf("chat_client sendAttachment: %w, usage: /attach <path> [@user] [text]",
+			models.ErrInvalidCommandArgs)
+	}
+
+	attachment, err := files.upload(ctx, parts[1])
+	if err != nil {
+		return fmt.Errorf("chat_client sendAttachment: %w", err)
+	}
+
+	text := ""
+	if len(parts) == attachParts {
+		text = parts[2]
+	}
+
+	msg, recipient := buildMessage(text, currentRoom)
+	if strings.HasPrefix(text, "@") && msg.Receiver == "" {
+		// "@user" alone addresses the file without a text.
+		msg = models.Message{Receiver: strings.TrimPrefix(text, "@")}
+		recipient = msg.Receiver
+	}
+
+	msg.Attachments = []models.Attachment{{ID: attachment.ID, Name: attachment.Name}}
+
+	return sess.sendMessage(msg, recipient)
+}
+
+// downloadAttachment saves the file of "/download <attachmentID>" into the working directory.
+func downloadAttachment(ctx context.Context, files attachments, input string) (string, error) {
+	fields := strings.Fields(input)
+
+	const downloadFields = 2
+
+	if len(fields) != downloadFields {
+		return "", fmt.Errorf("chat_client downloadAttachment: %w, usage: /download <attachmentID>",
+			models.ErrInvalidCommandArgs)
+	}
+
+	return files.download(ctx, fields[1], ".")
+}
+
+// formatAttachment describes an attachment in one line.
+func formatAttachment(attachment models.Attachment) string {
+	const kilobyte = 1024
+
+	size := fmt.Sprintf("%d B", attachment.Size)
+	if attachment.Size >= kilobyte {
+		size = fmt.Sprintf("%.1f KB", float64(attachment.Size)/kilobyte)
+	}
+
+	return fmt.Sprintf("%s (%s, %s) id %s", attachment.Name, size, attachment.MIMEType, attachment.ID)
+}
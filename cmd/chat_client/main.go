@@ -4,14 +4,13 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -32,31 +31,66 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	conn := connectToTCPServer(cfg.TCPServerAddr, log)
+	stdin := bufio.NewReader(os.Stdin)
+	_, pair := authenticateUser(ctx, stdin, cfg, log)
 
-	defer func() {
-		if err = conn.Close(); err != nil {
-			log.WithError(err).Panic("Error closing connecting to server")
-		}
-	}()
-
-	var wg sync.WaitGroup
+	creds := &tokens{
+		refreshURL: cfg.RefreshURL,
+		logoutURL:  cfg.LogoutURL,
+		tls:        cfg.TLSConfig,
+		log:        log,
+		pair:       *pair,
+	}
 
-	wg.Add(1) // wait for the initial message
+	sess := newSession(cfg.TCPServerAddr, cfg.TLSConfig, creds, log)
+	defer sess.close()
 
-	go receiveMessages(conn, log, &wg)
+	go sess.run(ctx, cancel)
 
-	username, token := authenticateUser(ctx, bufio.NewReader(os.Stdin), cfg, log)
-	authenticateWithTCPServer(token, conn, log)
+	if !sess.waitWelcome() {
+		log.Error("Server closed the session")
 
-	wg.Wait() // wait here until the initial message is received
+		return
+	}
 
 	log.Println("Enter messages to send to the chat server:")
-	log.Println("Type '" + color.GreenString("/logout") + "' to disconnect and log out.")
+	log.Println("Type '" + color.GreenString("/logout") + "' to disconnect and log out, ending the session on the server.")
 	log.Println("Type '" + color.CyanString("@username ") + color.BlueString("your_message") +
 		"' to send a direct message to 'username'.")
-
-	sendMessages(ctx, cancel, conn, bufio.NewReader(os.Stdin), log, username)
+	log.Println("Type '" + color.GreenString("/create room") + "', '" + color.GreenString("/join room") + "', '" +
+		color.GreenString("/leave room") + "' or '" + color.GreenString("/rooms") + "' to manage rooms.")
+	log.Println("Type '" + color.GreenString("/create room private") + "' for a room open by invitation only, '" +
+		color.GreenString("/invite room user") + "' to invite someone, '" + color.GreenString("/accept room") + "', '" +
+		color.GreenString("/decline room") + "' or '" + color.GreenString("/invitations") + "' to answer invitations.")
+	log.Println("Type '" + color.GreenString("/role room user moderator|member") +
+		"' to appoint moderators of a room you own.")
+	log.Println("Moderators type '" + color.GreenString("/kick room user [reason]") + "', '" +
+		color.GreenString("/ban room user [duration] [reason]") + "', '" +
+		color.GreenString("/mute room user [duration] [reason]") + "', '" +
+		color.GreenString("/unban room user") + "' or '" + color.GreenString("/unmute room user") + "'.")
+	log.Println("Type '" + color.GreenString("/history room [beforeID] [limit]") + "' to load older messages.")
+	log.Println("Type '" + color.GreenString("/switch room") + "' to send messages to another joined room.")
+	log.Println("Type '" + color.GreenString("/read room|@user [messageID]") + "' to mark messages read.")
+	log.Println("Type '" + color.GreenString("/edit messageID text") + "' or '" +
+		color.GreenString("/delete messageID") + "' to change a message you sent.")
+	log.Println("Mention people inside a room message with '" + color.CyanString("@username") + "', '" +
+		color.CyanString("@here") + "' or '" + color.CyanString("@room") +
+		"', a message starting with one is a direct message.")
+	log.Println("Type '" + color.GreenString("/reply messageID [@user] text") + "' to reply, '" +
+		color.GreenString("/thread messageID") + "' to read a thread, '" + color.GreenString("/follow messageID") +
+		"' or '" + color.GreenString("/unfollow messageID") + "' to get its replies or not.")
+	log.Println("Type '" + color.GreenString("/silence room") + "' or '" + color.GreenString("/unsilence room") +
+		"' to stop or resume getting messages of a room, followed threads still come through.")
+	log.Println("Type '" + color.GreenString("/react messageID emoji") + "' or '" +
+		color.GreenString("/unreact messageID emoji") + "' to react to a message.")
+	log.Println("Type '" + color.GreenString("/attach path [@user] [text]") + "' to send a file, '" +
+		color.GreenString("/download attachmentID") + "' to save one into the current directory.")
+	log.Println("Type '" + color.GreenString("/who room") + "' to see who is around, '" +
+		color.GreenString("/away") + "' and '" + color.GreenString("/back") + "' to change your status.")
+
+	files := attachments{url: cfg.AttachmentsURL, tokens: creds, tls: cfg.TLSConfig}
+
+	sendMessages(ctx, cancel, sess, files, stdin, log)
 }
 
 func authenticateUser(
@@ -64,7 +98,7 @@ func authenticateUser(
 	reader *bufio.Reader,
 	cfg *config.Config,
 	log *logrus.Logger,
-) (string, string) {
+) (string, *models.TokenPair) {
 	for {
 		fmt.Println("Choose an option:") //nolint:forbidigo
 		fmt.Println("1: Register")       //nolint:forbidigo
@@ -104,7 +138,7 @@ func authenticateUser(
 
 		creds = models.Credentials{Username: username, Password: password}
 
-		token, err := sendRequest(ctx, log, url, creds)
+		pair, err := sendRequest(ctx, log, cfg.TLSConfig, url, creds)
 		if err != nil {
 			log.Error("Error during authentication: ", err)
 
@@ -113,30 +147,34 @@ func authenticateUser(
 
 		log.Info("Authentication successful. Token received.")
 
-		return username, token
+		return username, pair
 	}
 }
 
-func sendRequest(ctx context.Context, log *logrus.Logger, url string, data interface{}) (string, error) {
+func sendRequest(
+	ctx context.Context,
+	log *logrus.Logger,
+	tlsConfig *tls.Config,
+	url string,
+	data interface{},
+) (*models.TokenPair, error) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return "", fmt.Errorf("chat_client sendRequest json.Marshal(data): %w", err)
+		return nil, fmt.Errorf("chat_client sendRequest json.Marshal(data): %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("chat_client sendRequest http.NewRequestWithContext(...): %w", err)
+		return nil, fmt.Errorf("chat_client sendRequest http.NewRequestWithContext(...): %w", err)
 	}
 
 	const timeoutDuration = time.Second * 10
 
-	c := http.Client{
-		Timeout: timeoutDuration,
-	}
+	c := newHTTPClient(tlsConfig, timeoutDuration)
 
 	resp, err := c.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("chat_client sendRequest c.Do(...): %w", err)
+		return nil, fmt.Errorf("chat_client sendRequest c.Do(...): %w", err)
 	}
 
 	defer func() {
@@ -147,167 +185,38 @@ func sendRequest(ctx context.Context, log *logrus.Logger, url string, data inter
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("chat_client sendRequest io.ReadAll(...): %w", err)
+		return nil, fmt.Errorf("chat_client sendRequest io.ReadAll(...): %w", err)
 	}
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		var errResp models.ErrorResponse
 		if err := json.Unmarshal(body, &errResp); err != nil {
-			return "", fmt.Errorf("chat_client sendRequest json.Unmarshal(...): %w", err)
+			return nil, fmt.Errorf("chat_client sendRequest json.Unmarshal(...): %w", err)
 		}
 
-		return "", fmt.Errorf("server error: %s, %w", errResp.Error, models.ErrWrongStatusCode)
+		return nil, fmt.Errorf("server error: %s, %w", errResp.Error, models.ErrWrongStatusCode)
 	}
 
+	// Login, registration and refresh all answer with the token pair among the data.
 	var response struct {
-		Data struct {
-			Token string `json:"token"`
-		} `json:"data"`
+		Data models.TokenPair `json:"data"`
 	}
 
 	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("chat_client sendRequest json.Unmarshal(...): %w", err)
+		return nil, fmt.Errorf("chat_client sendRequest json.Unmarshal(...): %w", err)
 	}
 
-	return response.Data.Token, nil
+	return &response.Data, nil
 }
 
-func connectToTCPServer(serverAddr string, log *logrus.Logger) net.Conn {
-	conn, err := net.Dial("tcp", serverAddr)
-	if err != nil {
-		log.WithError(err).Panic("Error connecting to TCP server")
-	}
-
-	log.Info("Connected to TCP server successfully.")
-
-	return conn
-}
-
-func authenticateWithTCPServer(token string, conn net.Conn, log *logrus.Logger) {
-	_, err := conn.Write([]byte(token + "\n"))
-	if err != nil {
-		log.WithError(err).Panic("Failed to send authentication token to TCP server")
-	}
-
-	log.Info("Authentication token sent.")
-}
-
-func receiveMessages(conn net.Conn, log *logrus.Logger, wg *sync.WaitGroup) {
-	scanner := bufio.NewScanner(conn)
-	isFirstMessage := true
-
-	for scanner.Scan() {
-		jsonInput := scanner.Text()
-
-		var msg models.Message
-
-		err := json.Unmarshal([]byte(jsonInput), &msg)
-		if err != nil {
-			log.WithError(err).Error("Failed to parse message")
-
-			continue
-		}
-
-		formattedTime := msg.CreatedAt.Format("2006-01-02 15:04:05")
-
-		var recipient string
-		if msg.Receiver == "" {
-			recipient = "everyone"
-		} else {
-			recipient = msg.Receiver
-		}
-
-		messagePrefix := color.GreenString("MESSAGE")
-		formattedMessage := fmt.Sprintf(
-			"%s[%s] %s to %s: %s",
-			messagePrefix,
-			formattedTime,
-			msg.Sender,
-			recipient,
-			msg.Content)
-
-		if isFirstMessage {
-			fmt.Println(formattedMessage) //nolint:forbidigo
-
-			isFirstMessage = false
-
-			wg.Done() // signal that first message received
-		} else {
-			fmt.Println(formattedMessage) //nolint:forbidigo
-		}
+// newHTTPClient returns a client trusting only the pinned CA of tlsConfig, or a cleartext one when it is nil.
+func newHTTPClient(tlsConfig *tls.Config, timeout time.Duration) *http.Client {
+	if tlsConfig == nil {
+		return &http.Client{Timeout: timeout}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.WithError(err).Error("Error receiving messages from server")
-	}
-}
-
-func sendMessages(ctx context.Context, cancel context.CancelFunc, //nolint:funlen
-	conn net.Conn, reader *bufio.Reader, log *logrus.Logger, username string,
-) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			log.Print("Enter command or message:\n")
-
-			input, err := reader.ReadString('\n')
-			if err != nil {
-				log.WithError(err).Error("Failed to read input")
-
-				continue
-			}
-
-			input = strings.TrimSpace(input)
-
-			if input == "/logout" {
-				cancel()
-
-				return
-			}
-
-			if input == "" {
-				continue
-			}
-
-			currentTime := time.Now()
-			formattedTime := currentTime.Format("2006-01-02 15:04:05")
-			recipient := "everyone"
-
-			const receiverSeparator = 2
-
-			if strings.HasPrefix(input, "@") {
-				parts := strings.SplitN(input, " ", receiverSeparator)
-				if len(parts) > 1 {
-					recipient = parts[0][1:] // removing '@' from username
-					input = parts[1]
-				}
-			}
-
-			messagePrefix := color.GreenString("MESSAGE")
-			ouptutMessage := fmt.Sprintf("%s[%s] You to %s: %s\n",
-				messagePrefix, formattedTime, recipient, input)
-			fmt.Print(ouptutMessage) //nolint:forbidigo
-
-			msg := models.Message{
-				Sender: username, Receiver: recipient,
-				Content: input, CreatedAt: currentTime,
-			}
-
-			jsonData, err := json.Marshal(msg)
-			if err != nil {
-				log.WithError(err).Error("Failed to serialize message")
-
-				continue
-			}
-
-			_, err = conn.Write(append(jsonData, '\n'))
-			if err != nil {
-				log.WithError(err).Error("Failed to send message to server")
-
-				continue
-			}
-		}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
 	}
 }
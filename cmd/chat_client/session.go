@@ -0,0 +1,890 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+const (
+	timeFormat = "2006-01-02 15:04:05"
+
+	// A message not acknowledged within ackTimeout is sent again with the same client ID,
+	// the server stores and delivers it only once.
+	ackTimeout        = 5 * time.Second
+	maxSendAttempts   = 3
+	clientIDByteCount = 16
+
+	// A lost connection is dialed again every reconnectDelay, the session resumes where it stopped.
+	reconnectDelay       = 2 * time.Second
+	maxReconnectAttempts = 30
+)
+
+// pendingMessage is a sent message waiting for the server's ack.
+type pendingMessage struct {
+	msg       models.Message
+	recipient string
+	attempts  int
+	timer     *time.Timer
+}
+
+// session speaks the chat protocol with the server, reconnecting and resuming when the connection drops.
+type session struct {
+	addr   string
+	tls    *tls.Config // nil for cleartext
+	tokens *tokens
+	log    *logrus.Logger
+
+	writeMu sync.Mutex
+	conn    net.Conn // nil while reconnecting
+	nextID  atomic.Uint64
+
+	// Where the session stands, presented when resuming.
+	seqMu   sync.Mutex
+	epoch   string
+	lastSeq uint64
+
+	pendingMu sync.Mutex
+	pending   map[string]*pendingMessage
+
+	// ready is closed once the session is welcomed or the connection ends, welcomed tells which.
+	ready     chan struct{}
+	readyOnce sync.Once
+	welcomed  atomic.Bool
+}
+
+func newSession(addr string, tlsConfig *tls.Config, creds *tokens, log *logrus.Logger) *session {
+	return &session{
+		addr:    addr,
+		tls:     tlsConfig,
+		tokens:  creds,
+		log:     log,
+		pending: make(map[string]*pendingMessage),
+		ready:   make(chan struct{}),
+	}
+}
+
+// send writes a frame with a fresh ID and returns the ID.
+func (s *session) send(frameType string, payload any) (string, error) {
+	id := strconv.FormatUint(s.nextID.Add(1), 10)
+
+	return id, s.write(frameType, id, payload)
+}
+
+func (s *session) write(frameType string, id string, payload any) error {
+	data, err := protocol.Encode(frameType, id, payload)
+	if err != nil {
+		return fmt.Errorf("chat_client session write protocol.Encode(...): %w", err)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.conn == nil {
+		return fmt.Errorf("chat_client session write: %w", models.ErrNotConnected)
+	}
+
+	if _, err := s.conn.Write(data); err != nil {
+		return fmt.Errorf("chat_client session write conn.Write(...): %w", err)
+	}
+
+	return nil
+}
+
+// sendMessage sends a chat message and keeps it until the server acknowledges it.
+func (s *session) sendMessage(msg models.Message, recipient string) error {
+	clientID, err := newClientID()
+	if err != nil {
+		return err
+	}
+
+	msg.ClientID = clientID
+	id := strconv.FormatUint(s.nextID.Add(1), 10)
+	pending := &pendingMessage{msg: msg, recipient: recipient, attempts: 1}
+
+	s.pendingMu.Lock()
+	s.pending[id] = pending
+	pending.timer = time.AfterFunc(ackTimeout, func() { s.retry(id) })
+	s.pendingMu.Unlock()
+
+	return s.write(protocol.TypeMessage, id, msg)
+}
+
+// retry sends an unacknowledged message again or gives up after maxSendAttempts.
+func (s *session) retry(id string) {
+	s.pendingMu.Lock()
+
+	pending, ok := s.pending[id]
+	if !ok {
+		s.pendingMu.Unlock()
+
+		return
+	}
+
+	if pending.attempts >= maxSendAttempts {
+		delete(s.pending, id)
+		s.pendingMu.Unlock()
+		printLine(color.RedString("ERROR"), fmt.Sprintf("Message to %s was not confirmed by the server: %s",
+			pending.recipient, pending.msg.Content))
+
+		return
+	}
+
+	pending.attempts++
+	pending.timer.Reset(ackTimeout)
+	s.pendingMu.Unlock()
+
+	if err := s.write(protocol.TypeMessage, id, pending.msg); err != nil {
+		s.log.WithError(err).Error("Failed to resend message to server")
+	}
+}
+
+// settle forgets the pending message answered by the frame with the given ID, if there is one.
+func (s *session) settle(id string) (*pendingMessage, bool) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	pending, ok := s.pending[id]
+	if ok {
+		pending.timer.Stop()
+		delete(s.pending, id)
+	}
+
+	return pending, ok
+}
+
+func newClientID() (string, error) {
+	buf := make([]byte, clientIDByteCount)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("chat_client newClientID rand.Read(...): %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// run keeps the session connected until ctx is done. It gives up, cancelling ctx,
+// when the first connection fails or reconnecting keeps failing.
+func (s *session) run(ctx context.Context, cancel context.CancelFunc) {
+	defer s.markReady(false)
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectDelay):
+			}
+		}
+
+		if err := s.connect(ctx); err != nil {
+			s.log.WithError(err).Error("Failed to connect to TCP server")
+
+			if !s.welcomed.Load() || attempt >= maxReconnectAttempts {
+				cancel()
+
+				return
+			}
+
+			continue
+		}
+
+		attempt = 0
+
+		s.receive()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !s.welcomed.Load() {
+			cancel() // the server refused the session
+
+			return
+		}
+
+		s.log.Warn("Connection to TCP server lost, reconnecting...")
+	}
+}
+
+// connect dials the server and says hello, resuming the previous connection's session if there was one.
+func (s *session) connect(ctx context.Context) error {
+	token, err := s.tokens.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	var conn net.Conn
+
+	if s.tls != nil {
+		dialer := tls.Dialer{Config: s.tls}
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("chat_client session connect dialer.DialContext(...): %w", err)
+	}
+
+	s.writeMu.Lock()
+	s.conn = conn
+	s.writeMu.Unlock()
+
+	hello := protocol.HelloPayload{Versions: protocol.SupportedVersions, Token: token}
+
+	s.seqMu.Lock()
+	if s.epoch != "" {
+		hello.Resume = &protocol.ResumePayload{Epoch: s.epoch, LastSeq: s.lastSeq}
+	}
+	s.seqMu.Unlock()
+
+	if _, err := s.send(protocol.TypeHello, hello); err != nil {
+		s.close()
+
+		return err
+	}
+
+	return nil
+}
+
+// close drops the connection, ending the running receive.
+func (s *session) close() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.conn == nil {
+		return
+	}
+
+	if err := s.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.log.WithError(err).Warn("Error closing connection to server")
+	}
+
+	s.conn = nil
+}
+
+// welcome records where the session stands and resends messages the server has not acknowledged yet.
+func (s *session) welcome(welcome protocol.WelcomePayload) {
+	s.seqMu.Lock()
+	s.epoch = welcome.Epoch
+	if !welcome.Resumed {
+		s.lastSeq = welcome.Seq
+	}
+	s.seqMu.Unlock()
+
+	s.pendingMu.Lock()
+
+	resend := make(map[string]models.Message, len(s.pending))
+	for id, pending := range s.pending {
+		pending.attempts = 1
+		pending.timer.Reset(ackTimeout)
+		resend[id] = pending.msg
+	}
+
+	s.pendingMu.Unlock()
+
+	for id, msg := range resend {
+		if err := s.write(protocol.TypeMessage, id, msg); err != nil {
+			s.log.WithError(err).Error("Failed to resend message to server")
+		}
+	}
+}
+
+// seen remembers the sequence number of a received event.
+func (s *session) seen(seq uint64) {
+	s.seqMu.Lock()
+	defer s.seqMu.Unlock()
+
+	s.lastSeq = max(s.lastSeq, seq)
+}
+
+// waitWelcome blocks until the server accepts the session and reports whether it did.
+func (s *session) waitWelcome() bool {
+	<-s.ready
+
+	return s.welcomed.Load()
+}
+
+func (s *session) markReady(welcomed bool) {
+	s.readyOnce.Do(func() {
+		s.welcomed.Store(welcomed)
+		close(s.ready)
+	})
+}
+
+func (s *session) receive() {
+	defer s.close()
+
+	s.writeMu.Lock()
+	conn := s.conn
+	s.writeMu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(conn)
+
+	for scanner.Scan() {
+		env, err := protocol.Decode(scanner.Bytes())
+		if err != nil {
+			s.log.WithError(err).Error("Failed to parse frame")
+
+			continue
+		}
+
+		if env.Seq > 0 {
+			s.seen(env.Seq)
+		}
+
+		s.render(env)
+	}
+
+	if err := scanner.Err(); err != nil {
+		s.log.WithError(err).Error("Error receiving messages from server")
+	}
+}
+
+func (s *session) render(env protocol.Envelope) {
+	switch env.Type {
+	case protocol.TypeWelcome:
+		var welcome protocol.WelcomePayload
+		if !s.decode(env, &welcome) {
+			return
+		}
+
+		switch {
+		case welcome.Resumed:
+			printLine(color.GreenString("SERVER"), "Reconnected, catching up on missed messages.")
+		case s.welcomed.Load():
+			printLine(color.GreenString("SERVER"), "Reconnected, the session could not be resumed.")
+		default:
+			printLine(color.GreenString("SERVER"), fmt.Sprintf("Welcome to the chat, %s! Your rooms: #%s",
+				welcome.User, strings.Join(welcome.Rooms, ", #")))
+		}
+
+		printUnread(welcome.Unread)
+
+		for _, invitation := range welcome.Invitations {
+			printInvitation(invitation)
+		}
+
+		s.welcome(welcome)
+		s.markReady(true)
+	case protocol.TypeMessage:
+		var msg models.Message
+		if s.decode(env, &msg) {
+			printMessage(msg)
+		}
+	case protocol.TypeAck:
+		var ack protocol.AckPayload
+		if !s.decode(env, &ack) {
+			return
+		}
+
+		if pending, ok := s.settle(env.ID); ok {
+			printSent(pending, ack)
+		} else if ack.Result != "" {
+			printLine(color.GreenString("SERVER"), ack.Result)
+		}
+	case protocol.TypeError:
+		var payload protocol.ErrorPayload
+		if !s.decode(env, &payload) {
+			return
+		}
+
+		text := fmt.Sprintf("%s (%s)", payload.Message, payload.Code)
+		if pending, ok := s.settle(env.ID); ok {
+			text = fmt.Sprintf("Message to %s was rejected: %s", pending.recipient, text)
+		}
+
+		printLine(color.RedString("ERROR"), text)
+	case protocol.TypePresence:
+		var presence protocol.PresencePayload
+		if s.decode(env, &presence) {
+			printPresence(presence)
+		}
+	case protocol.TypeTyping:
+		var typing protocol.TypingPayload
+		if s.decode(env, &typing) && typing.Active {
+			printTyping(typing)
+		}
+	case protocol.TypeEdit:
+		var msg models.Message
+		if s.decode(env, &msg) {
+			printLine(color.HiBlackString("EDITED"), fmt.Sprintf("%s changed #%d: %s", msg.Sender, msg.ID, msg.Content))
+		}
+	case protocol.TypeDelete:
+		var msg models.Message
+		if s.decode(env, &msg) {
+			printLine(color.HiBlackString("DELETED"), fmt.Sprintf("Message #%d from %s was deleted", msg.ID, msg.Sender))
+		}
+	case protocol.TypeMention:
+		var msg models.Message
+		if s.decode(env, &msg) {
+			printLine(color.MagentaString("MENTION"), fmt.Sprintf("%s mentioned you in #%s: %s %s",
+				msg.Sender, msg.Room, msg.Content, color.HiBlackString("(#%d)", msg.ID)))
+		}
+	case protocol.TypeReaction:
+		var reaction models.Reaction
+		if s.decode(env, &reaction) {
+			verb := "removed"
+			if reaction.Added {
+				verb = "reacted"
+			}
+
+			printLine(color.HiBlackString("REACTION"), fmt.Sprintf("%s %s %s to #%d %s",
+				reaction.User, verb, reaction.Emoji, reaction.MessageID, formatReactions(reaction.Reactions)))
+		}
+	case protocol.TypeInvitation:
+		var invitation models.RoomInvitation
+		if s.decode(env, &invitation) {
+			printInvitation(invitation)
+		}
+	case protocol.TypeReceipt:
+		var receipt models.ReadReceipt
+		if s.decode(env, &receipt) {
+			printLine(color.HiBlackString("READ"), fmt.Sprintf("%s read your messages %s",
+				receipt.Reader, formatMessageIDs(receipt.MessageIDs)))
+		}
+	case protocol.TypeSystem:
+		var system protocol.SystemPayload
+		if s.decode(env, &system) {
+			printLine(color.GreenString("SERVER"), system.Text)
+		}
+	case protocol.TypeReauth:
+		var reauth protocol.ReauthPayload
+		if s.decode(env, &reauth) {
+			go s.reauthenticate(reauth)
+		}
+	default:
+		s.log.Debugf("Ignoring %q frame", env.Type)
+	}
+}
+
+// reauthenticate answers the server asking for a fresh token with one outliving the current token.
+func (s *session) reauthenticate(reauth protocol.ReauthPayload) {
+	const timeoutDuration = time.Second * 10
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	defer cancel()
+
+	deadline := time.Now()
+	if reauth.ExpiresAt != nil {
+		deadline = *reauth.ExpiresAt
+	}
+
+	token, err := s.tokens.after(ctx, deadline)
+	if err != nil {
+		s.log.WithError(err).Error("Failed to refresh the token, the server will end the session once it expires")
+
+		return
+	}
+
+	if _, err := s.send(protocol.TypeReauth, protocol.ReauthPayload{Token: token}); err != nil {
+		s.log.WithError(err).Error("Failed to send the fresh token to server")
+	}
+}
+
+func (s *session) decode(env protocol.Envelope, payload any) bool {
+	if err := env.DecodePayload(payload); err != nil {
+		s.log.WithError(err).Errorf("Failed to parse %s frame", env.Type)
+
+		return false
+	}
+
+	return true
+}
+
+func printLine(prefix string, text string) {
+	fmt.Printf("%s[%s] %s\n", prefix, time.Now().Format(timeFormat), text) //nolint:forbidigo
+}
+
+func printMessage(msg models.Message) {
+	var recipient string
+
+	switch {
+	case msg.Receiver != "":
+		recipient = msg.Receiver
+	case msg.Room != "":
+		recipient = "#" + msg.Room
+	default:
+		recipient = "everyone"
+	}
+
+	messagePrefix := color.GreenString("MESSAGE")
+	if msg.History {
+		messagePrefix = color.YellowString("HISTORY")
+	}
+
+	if msg.Parent != nil {
+		printQuote(msg.ReplyTo, *msg.Parent)
+	}
+
+	formattedMessage := fmt.Sprintf(
+		"%s[%s] %s to %s: %s",
+		messagePrefix,
+		msg.CreatedAt.Format(timeFormat),
+		msg.Sender,
+		recipient,
+		msg.Content)
+
+	if msg.Deleted {
+		formattedMessage += color.HiBlackString("message deleted")
+	}
+
+	if msg.ID != 0 {
+		formattedMessage += color.HiBlackString(" (#%d)", msg.ID)
+	}
+
+	if msg.EditedAt != nil {
+		formattedMessage += color.HiBlackString(" edited")
+	}
+
+	if len(msg.Reactions) > 0 {
+		formattedMessage += " " + formatReactions(msg.Reactions)
+	}
+
+	fmt.Println(formattedMessage) //nolint:forbidigo
+
+	for _, attachment := range msg.Attachments {
+		fmt.Println(color.HiBlackString("  + %s", formatAttachment(attachment))) //nolint:forbidigo
+	}
+}
+
+// printQuote shows a snippet of the message a reply answers above the reply.
+func printQuote(parentID int, parent models.MessagePreview) {
+	snippet := parent.Content
+	if parent.Deleted {
+		snippet = "message deleted"
+	}
+
+	fmt.Println(color.HiBlackString("  > %s (#%d): %s", parent.Sender, parentID, snippet)) //nolint:forbidigo
+}
+
+// printSent echoes the user's own message once the server confirmed it.
+func printSent(pending *pendingMessage, ack protocol.AckPayload) {
+	sentAt := time.Now()
+	if ack.CreatedAt != nil {
+		sentAt = *ack.CreatedAt
+	}
+
+	status := color.HiBlackString(" (#%d)", ack.MessageID)
+	if pending.msg.ReplyTo != 0 {
+		status += color.HiBlackString(" in reply to #%d", pending.msg.ReplyTo)
+	}
+
+	for _, attachment := range pending.msg.Attachments {
+		status += color.HiBlackString(" + %s", attachment.Name)
+	}
+
+	if ack.Queued {
+		status += color.YellowString(" queued, %s is offline", pending.recipient)
+	}
+
+	fmt.Printf("%s[%s] You to %s: %s%s\n", //nolint:forbidigo
+		color.GreenString("MESSAGE"),
+		sentAt.Format(timeFormat),
+		pending.recipient,
+		pending.msg.Content,
+		status)
+}
+
+func printUnread(counts []models.UnreadCount) {
+	unread := make([]string, 0, len(counts))
+
+	for _, count := range counts {
+		switch {
+		case count.Unread == 0:
+			continue
+		case count.Peer != "":
+			unread = append(unread, fmt.Sprintf("@%s %d", count.Peer, count.Unread))
+		default:
+			unread = append(unread, fmt.Sprintf("#%s %d", count.Room, count.Unread))
+		}
+	}
+
+	if len(unread) > 0 {
+		printLine(color.GreenString("SERVER"), "Unread: "+strings.Join(unread, ", "))
+	}
+}
+
+func printInvitation(invitation models.RoomInvitation) {
+	kind := "room"
+	if invitation.Private {
+		kind = "private room"
+	}
+
+	printLine(color.MagentaString("INVITATION"), fmt.Sprintf("%s invited you to the %s #%s, /accept %s or /decline %s",
+		invitation.Inviter, kind, invitation.Room, invitation.Room, invitation.Room))
+}
+
+func formatReactions(reactions []models.ReactionCount) string {
+	formatted := make([]string, 0, len(reactions))
+	for _, reaction := range reactions {
+		formatted = append(formatted, fmt.Sprintf("%s %d", reaction.Emoji, reaction.Count))
+	}
+
+	return "[" + strings.Join(formatted, ", ") + "]"
+}
+
+func formatMessageIDs(ids []int) string {
+	formatted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		formatted = append(formatted, "#"+strconv.Itoa(id))
+	}
+
+	return strings.Join(formatted, ", ")
+}
+
+func printTyping(typing protocol.TypingPayload) {
+	text := typing.User + " is typing..."
+	if typing.Room != "" {
+		text = fmt.Sprintf("%s is typing in #%s...", typing.User, typing.Room)
+	}
+
+	printLine(color.HiBlackString("TYPING"), text)
+}
+
+func printPresence(presence protocol.PresencePayload) {
+	var text string
+
+	switch presence.Event {
+	case protocol.PresenceJoined:
+		text = presence.User + " joined #" + presence.Room
+	case protocol.PresenceLeft:
+		text = presence.User + " left #" + presence.Room
+	case protocol.PresenceOffline:
+		text = presence.User + " went offline"
+	case protocol.PresenceAway:
+		text = presence.User + " is away"
+	default:
+		text = presence.User + " is " + presence.Event
+	}
+
+	printLine(color.CyanString("PRESENCE"), text)
+}
+
+func sendMessages(ctx context.Context, cancel context.CancelFunc, //nolint:cyclop
+	sess *session, files attachments, reader *bufio.Reader, log *logrus.Logger,
+) {
+	currentRoom := "general"
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			log.Print("Enter command or message:\n")
+
+			input, err := reader.ReadString('\n')
+			if err != nil {
+				log.WithError(err).Error("Failed to read input")
+
+				return
+			}
+
+			input = strings.TrimSpace(input)
+
+			switch {
+			case input == "":
+				continue
+			case input == "/logout":
+				if err := sess.tokens.logout(ctx); err != nil {
+					log.WithError(err).Warn("Failed to log out on the server")
+				}
+
+				cancel()
+
+				return
+			case strings.HasPrefix(input, "/switch "):
+				currentRoom = strings.TrimSpace(strings.TrimPrefix(input, "/switch "))
+				printLine(color.GreenString("CLIENT"), "Messages now go to #"+currentRoom)
+
+				continue
+			case strings.HasPrefix(input, "/edit "), strings.HasPrefix(input, "/delete "):
+				if err := sendChange(sess, input); err != nil {
+					printLine(color.RedString("ERROR"), err.Error())
+				}
+
+				continue
+			case strings.HasPrefix(input, "/reply "):
+				if err := sendReply(sess, input, currentRoom); err != nil {
+					printLine(color.RedString("ERROR"), err.Error())
+				}
+
+				continue
+			case strings.HasPrefix(input, "/attach "):
+				if err := sendAttachment(ctx, sess, files, input, currentRoom); err != nil {
+					printLine(color.RedString("ERROR"), err.Error())
+				}
+
+				continue
+			case strings.HasPrefix(input, "/download "):
+				path, err := downloadAttachment(ctx, files, input)
+				if err != nil {
+					printLine(color.RedString("ERROR"), err.Error())
+
+					continue
+				}
+
+				printLine(color.GreenString("CLIENT"), "Saved "+path)
+
+				continue
+			case strings.HasPrefix(input, "/react "), strings.HasPrefix(input, "/unreact "):
+				if err := sendReaction(sess, input); err != nil {
+					printLine(color.RedString("ERROR"), err.Error())
+				}
+
+				continue
+			case strings.HasPrefix(input, "/"):
+				fields := strings.Fields(strings.TrimPrefix(input, "/"))
+				if len(fields) == 0 {
+					printLine(color.RedString("ERROR"), models.ErrInvalidCommandArgs.Error()+", usage: /<command> [args...]")
+
+					continue
+				}
+
+				currentRoom = switchRoom(input, currentRoom)
+
+				cmd := protocol.CommandPayload{Name: fields[0], Args: fields[1:]}
+
+				if _, err := sess.send(protocol.TypeCommand, cmd); err != nil {
+					log.WithError(err).Error("Failed to send command to server")
+				}
+
+				continue
+			}
+
+			// Echoed once the server acknowledges it.
+			if err := sess.sendMessage(buildMessage(input, currentRoom)); err != nil {
+				log.WithError(err).Error("Failed to send message to server")
+			}
+		}
+	}
+}
+
+// sendChange sends "/edit <messageID> <text>" and "/delete <messageID>" as edit and delete frames.
+func sendChange(sess *session, input string) error {
+	const editParts = 3
+
+	parts := strings.SplitN(input, " ", editParts)
+	frameType := strings.TrimPrefix(parts[0], "/")
+
+	messageID, err := strconv.Atoi(parts[1])
+	if err != nil || (frameType == protocol.TypeEdit && len(parts) < editParts) {
+		return fmt.Errorf("chat_client sendChange: %w, usage: /edit <messageID> <text> or /delete <messageID>",
+			models.ErrInvalidCommandArgs)
+	}
+
+	msg := models.Message{ID: messageID}
+	if frameType == protocol.TypeEdit {
+		msg.Content = parts[2]
+	}
+
+	if _, err := sess.send(frameType, msg); err != nil {
+		return fmt.Errorf("chat_client sendChange sess.send(...): %w", err)
+	}
+
+	return nil
+}
+
+// sendReply sends "/reply <messageID> [@user] <text>" as a reply to the current room or to the user.
+func sendReply(sess *session, input string, currentRoom string) error {
+	const replyParts = 3
+
+	parts := strings.SplitN(input, " ", replyParts)
+
+	messageID, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts) < replyParts {
+		return fmt.Errorf("chat_client sendReply: %w, usage: /reply <messageID> [@user] <text>",
+			models.ErrInvalidCommandArgs)
+	}
+
+	msg, recipient := buildMessage(parts[2], currentRoom)
+	msg.ReplyTo = messageID
+
+	return sess.sendMessage(msg, recipient)
+}
+
+// sendReaction sends "/react <messageID> <emoji>" and "/unreact <messageID> <emoji>" as reaction frames.
+func sendReaction(sess *session, input string) error {
+	const reactionFields = 3
+
+	usageErr := fmt.Errorf(
+		"chat_client sendReaction: %w, usage: /react <messageID> <emoji> or /unreact <messageID> <emoji>",
+		models.ErrInvalidCommandArgs)
+
+	fields := strings.Fields(input)
+	if len(fields) != reactionFields {
+		return usageErr
+	}
+
+	messageID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return usageErr
+	}
+
+	reaction := models.Reaction{MessageID: messageID, Emoji: fields[2], Added: fields[0] == "/react"}
+
+	if _, err := sess.send(protocol.TypeReaction, reaction); err != nil {
+		return fmt.Errorf("chat_client sendReaction sess.send(...): %w", err)
+	}
+
+	return nil
+}
+
+// buildMessage turns "@username text" into a direct message and anything else into a message
+// to the current room; it also returns how to show the recipient.
+func buildMessage(input string, currentRoom string) (models.Message, string) {
+	const receiverSeparator = 2
+
+	if strings.HasPrefix(input, "@") {
+		parts := strings.SplitN(input, " ", receiverSeparator)
+		if len(parts) > 1 {
+			receiver := parts[0][1:] // removing '@' from username
+
+			return models.Message{Receiver: receiver, Content: parts[1]}, receiver
+		}
+	}
+
+	return models.Message{Room: currentRoom, Content: input}, "#" + currentRoom
+}
+
+// switchRoom returns the room further messages go to after the given command.
+func switchRoom(command string, currentRoom string) string {
+	fields := strings.Fields(command)
+
+	const commandWithRoom = 2
+
+	if len(fields) < commandWithRoom {
+		return currentRoom
+	}
+
+	switch fields[0] {
+	case "/create", "/join", "/accept":
+		return fields[1]
+	case "/leave":
+		if fields[1] == currentRoom {
+			return "general"
+		}
+	}
+
+	return currentRoom
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+// An access token expiring within refreshMargin is exchanged before it is used.
+const refreshMargin = time.Minute
+
+// tokens keeps the access token of the logged in user fresh, rotating the refresh token as it goes.
+type tokens struct {
+	refreshURL string
+	logoutURL  string
+	tls        *tls.Config // nil for cleartext
+	log        *logrus.Logger
+
+	mu   sync.Mutex
+	pair models.TokenPair
+}
+
+// access returns a valid access token, refreshing it when it is about to expire.
+func (t *tokens) access(ctx context.Context) (string, error) {
+	return t.after(ctx, time.Now().Add(refreshMargin))
+}
+
+// after returns an access token still valid at deadline, refreshing the current one when it expires before.
+func (t *tokens) after(ctx context.Context, deadline time.Time) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pair.ExpiresAt.After(deadline) {
+		return t.pair.Token, nil
+	}
+
+	pair, err := sendRequest(ctx, t.log, t.tls, t.refreshURL, models.RefreshRequest{RefreshToken: t.pair.RefreshToken})
+	if err != nil {
+		return "", fmt.Errorf("chat_client tokens after sendRequest(...): %w", err)
+	}
+
+	t.pair = *pair
+
+	return t.pair.Token, nil
+}
+
+// logout revokes the session on the server, its tokens cannot be used afterwards.
+func (t *tokens) logout(ctx context.Context) error {
+	token, err := t.access(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.logoutURL, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("chat_client tokens logout http.NewRequestWithContext(...): %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	const timeoutDuration = time.Second * 10
+
+	resp, err := newHTTPClient(t.tls, timeoutDuration).Do(req)
+	if err != nil {
+		return fmt.Errorf("chat_client tokens logout c.Do(...): %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server error: %s, %w", resp.Status, models.ErrWrongStatusCode)
+	}
+
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 	httpserver "github.com/stsolovey/kvant_chat/internal/server/http-server"
 	tcpserver "github.com/stsolovey/kvant_chat/internal/server/tcp-server"
 	"github.com/stsolovey/kvant_chat/internal/storage"
+	"github.com/stsolovey/kvant_chat/internal/storage/blob"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -47,12 +48,53 @@ func main() {
 
 	authRepo := repository.NewAuthRepository(storageSystem.DB())
 	usersRepo := repository.NewUsersRepository(storageSystem.DB())
+	messagesRepo := repository.NewMessagesRepository(storageSystem.DB())
+	roomsRepo := repository.NewRoomsRepository(storageSystem.DB())
+	attachmentsRepo := repository.NewAttachmentsRepository(storageSystem.DB())
+	moderationRepo := repository.NewModerationRepository(storageSystem.DB())
 
-	authService := service.NewAuthService(authRepo, cfg.SigningKey)
-	usersService := service.NewUsersService(usersRepo, authService)
+	attachmentsStore, err := blob.NewLocalStore(cfg.AttachmentsDir)
+	if err != nil {
+		log.WithError(err).Panic("Failed to initialize attachments storage")
+	}
 
-	httpServer := httpserver.CreateServer(cfg, log, usersService, authService)
-	tcpServer := tcpserver.CreateServer(cfg, log, authService)
+	go cfg.SigningKeys.Run(ctx, cfg.JWTKeysReloadInterval, cfg.JWTKeyRotation)
+
+	authService := service.NewAuthService(authRepo, cfg.SigningKeys, cfg.AccessTokenTTL, cfg.RefreshTokenTTL)
+	usersService := service.NewUsersService(usersRepo, authService)
+	moderationService := service.NewModerationService(moderationRepo, roomsRepo, cfg.AdminUsers)
+	messagesService := service.NewMessagesService(messagesRepo, roomsRepo, moderationService)
+	roomsService := service.NewRoomsService(roomsRepo, moderationRepo)
+	attachmentsService := service.NewAttachmentsService(
+		attachmentsRepo,
+		messagesService,
+		attachmentsStore,
+		cfg.MaxAttachmentSize,
+		cfg.AllowedAttachmentTypes,
+	)
+
+	tcpServer := tcpserver.CreateServer(
+		cfg,
+		log,
+		authService,
+		usersService,
+		messagesService,
+		roomsService,
+		moderationService,
+	)
+	httpServer := httpserver.CreateServer(
+		cfg,
+		log,
+		usersService,
+		authService,
+		messagesService,
+		attachmentsService,
+		roomsService,
+		moderationService,
+		tcpServer,
+		tcpServer,
+		tcpServer,
+	)
 
 	eg, ctx := errgroup.WithContext(ctx)
 
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/middleware"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/utils"
+)
+
+// attachmentFormField is the multipart field carrying the uploaded file.
+const attachmentFormField = "file"
+
+var errMissingAttachmentFile = errors.New("multipart field \"file\" is required")
+
+type AttachmentsHandler struct {
+	service service.AttachmentsServiceInterface
+	logger  *logrus.Logger
+}
+
+func NewAttachmentsHandler(s service.AttachmentsServiceInterface, logger *logrus.Logger) *AttachmentsHandler {
+	return &AttachmentsHandler{
+		service: s,
+		logger:  logger,
+	}
+}
+
+// Upload serves POST /attachments with a multipart/form-data body, the file in the "file" field.
+// The returned attachment ID can be referenced by a message of the authenticated user.
+func (h *AttachmentsHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error(), h.logger)
+
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, errMissingAttachmentFile.Error(), h.logger)
+
+			return
+		}
+
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error(), h.logger)
+
+			return
+		}
+
+		if part.FormName() != attachmentFormField {
+			continue
+		}
+
+		attachment, err := h.service.Upload(r.Context(), username, part.FileName(), part)
+		if err != nil {
+			handleAttachmentServiceError(w, err, h.logger)
+
+			return
+		}
+
+		utils.WriteOkResponse(w, http.StatusCreated, attachment, h.logger)
+
+		return
+	}
+}
+
+// Download serves GET /attachments/{id} with the file content to a user who may see it.
+func (h *AttachmentsHandler) Download(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	attachment, content, err := h.service.Download(r.Context(), username, chi.URLParam(r, "id"))
+	if err != nil {
+		handleAttachmentServiceError(w, err, h.logger)
+
+		return
+	}
+
+	defer func() {
+		if err := content.Close(); err != nil {
+			h.logger.WithError(err).Warnf("Failed to close attachment %s", attachment.ID)
+		}
+	}()
+
+	w.Header().Set("Content-Type", attachment.MIMEType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, content); err != nil {
+		h.logger.WithError(err).Warnf("Failed to send attachment %s", attachment.ID)
+	}
+}
+
+func handleAttachmentServiceError(w http.ResponseWriter, err error, log *logrus.Logger) {
+	var statusCode int
+
+	var errMsg string
+
+	switch {
+	case errors.Is(err, models.ErrAttachmentTooLarge):
+		statusCode = http.StatusRequestEntityTooLarge
+		errMsg = models.ErrAttachmentTooLarge.Error()
+	case errors.Is(err, models.ErrAttachmentTypeNotAllowed):
+		statusCode = http.StatusUnsupportedMediaType
+		errMsg = models.ErrAttachmentTypeNotAllowed.Error()
+	case errors.Is(err, models.ErrEmptyAttachment):
+		statusCode = http.StatusBadRequest
+		errMsg = models.ErrEmptyAttachment.Error()
+	case errors.Is(err, models.ErrAttachmentNotFound), errors.Is(err, models.ErrMessageNotFound):
+		statusCode = http.StatusNotFound
+		errMsg = models.ErrAttachmentNotFound.Error()
+	case errors.Is(err, models.ErrNotRoomMember):
+		statusCode = http.StatusForbidden
+		errMsg = models.ErrNotRoomMember.Error()
+	default:
+		statusCode = http.StatusInternalServerError
+		errMsg = "Internal server error"
+	}
+
+	utils.WriteErrorResponse(w, statusCode, errMsg, log)
+}
@@ -3,10 +3,13 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/middleware"
 	"github.com/stsolovey/kvant_chat/internal/models"
 	"github.com/stsolovey/kvant_chat/internal/utils"
 )
@@ -37,7 +40,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.service.LoginUser(r.Context(), loginRequest)
+	tokens, err := h.service.LoginUser(r.Context(), loginRequest)
 	if err != nil {
 		handleLoginServiceError(w, err, h.logger)
 
@@ -46,8 +49,86 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	responseData := map[string]interface{}{"token": token}
-	utils.WriteOkResponse(w, http.StatusOK, responseData, h.logger)
+	utils.WriteOkResponse(w, http.StatusOK, tokens, h.logger)
+}
+
+// Refresh serves POST /user/refresh with {"refreshToken": "..."}, answering with a new token pair.
+// The refresh token cannot be used again.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid JSON data", h.logger)
+
+		return
+	}
+
+	tokens, err := h.service.RefreshToken(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidRefreshToken) {
+			utils.WriteErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidRefreshToken.Error(), h.logger)
+
+			return
+		}
+
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Internal server error", h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, tokens, h.logger)
+}
+
+// Logout serves POST /user/logout, revoking the access token of the request and its refresh token.
+// With {"allSessions": true} every session of the user ends.
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	jti, ok := middleware.TokenIDFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	var req models.LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid JSON data", h.logger)
+
+			return
+		}
+	}
+
+	if err := h.service.Logout(r.Context(), username, jti, req.AllSessions); err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Internal server error", h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, map[string]interface{}{
+		"username":    username,
+		"allSessions": req.AllSessions,
+	}, h.logger)
+}
+
+// JWKS serves GET /.well-known/jwks.json, the public keys other services verify access tokens with.
+// The key set is written bare, as JWKS consumers expect, not in the usual response envelope.
+func (h *AuthHandler) JWKS(w http.ResponseWriter, _ *http.Request) {
+	// Shorter than the default JWT_KEY_ACTIVATION_DELAY, caches learn a new key before it signs.
+	const maxAge = 5 * time.Minute
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(h.service.JWKS()); err != nil {
+		h.logger.WithError(err).Warn("Failed to encode response")
+	}
 }
 
 func handleLoginServiceError(w http.ResponseWriter, err error, log *logrus.Logger) {
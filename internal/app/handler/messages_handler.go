@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/middleware"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/utils"
+)
+
+type MessagesHandler struct {
+	service service.MessagesServiceInterface
+	logger  *logrus.Logger
+}
+
+func NewMessagesHandler(s service.MessagesServiceInterface, logger *logrus.Logger) *MessagesHandler {
+	return &MessagesHandler{
+		service: s,
+		logger:  logger,
+	}
+}
+
+// RoomMessages serves GET /rooms/{room}/messages?before=&limit=&q= to the members of the room.
+func (h *MessagesHandler) RoomMessages(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	req, err := parseHistoryRequest(r)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error(), h.logger)
+
+		return
+	}
+
+	page, err := h.service.RoomHistory(r.Context(), username, chi.URLParam(r, "room"), req)
+	if err != nil {
+		handleHistoryServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, page, h.logger)
+}
+
+// DirectMessages serves GET /dm/{username}/messages?before=&limit=&q= for the authenticated user.
+func (h *MessagesHandler) DirectMessages(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	req, err := parseHistoryRequest(r)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error(), h.logger)
+
+		return
+	}
+
+	page, err := h.service.DirectHistory(r.Context(), username, chi.URLParam(r, "username"), req)
+	if err != nil {
+		handleHistoryServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, page, h.logger)
+}
+
+// Mentions serves GET /mentions?before=&limit= with the messages mentioning the authenticated user.
+func (h *MessagesHandler) Mentions(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	req, err := parseHistoryRequest(r)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error(), h.logger)
+
+		return
+	}
+
+	page, err := h.service.Mentions(r.Context(), username, req)
+	if err != nil {
+		handleHistoryServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, page, h.logger)
+}
+
+// UnreadCounts serves GET /unread with the unread messages of the authenticated user
+// per room and direct message peer.
+func (h *MessagesHandler) UnreadCounts(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	counts, err := h.service.UnreadCounts(r.Context(), username)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Internal server error", h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, counts, h.logger)
+}
+
+// MarkRoomRead serves POST /rooms/{room}/read with an optional {"messageId": 42}, the latest message by default.
+func (h *MessagesHandler) MarkRoomRead(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	var req models.ReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid JSON data", h.logger)
+
+		return
+	}
+
+	lastReadID, err := h.service.MarkRoomRead(r.Context(), chi.URLParam(r, "room"), username, req.MessageID)
+	if err != nil {
+		var statusCode int
+
+		var errMsg string
+
+		switch {
+		case errors.Is(err, models.ErrInvalidMessageID):
+			statusCode = http.StatusBadRequest
+			errMsg = err.Error()
+		case errors.Is(err, models.ErrNotRoomMember):
+			statusCode = http.StatusForbidden
+			errMsg = models.ErrNotRoomMember.Error()
+		default:
+			statusCode = http.StatusInternalServerError
+			errMsg = "Internal server error"
+		}
+
+		utils.WriteErrorResponse(w, statusCode, errMsg, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, map[string]int{"lastReadId": lastReadID}, h.logger)
+}
+
+// Thread serves GET /messages/{id}/thread?before=&limit= with the thread the message belongs to.
+func (h *MessagesHandler) Thread(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	messageID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, models.ErrInvalidMessageID.Error(), h.logger)
+
+		return
+	}
+
+	req, err := parseHistoryRequest(r)
+	if err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, err.Error(), h.logger)
+
+		return
+	}
+
+	thread, err := h.service.Thread(r.Context(), username, messageID, req)
+	if err != nil {
+		var statusCode int
+
+		var errMsg string
+
+		switch {
+		case errors.Is(err, models.ErrInvalidMessageID):
+			statusCode = http.StatusBadRequest
+			errMsg = models.ErrInvalidMessageID.Error()
+		case errors.Is(err, models.ErrMessageNotFound):
+			statusCode = http.StatusNotFound
+			errMsg = models.ErrMessageNotFound.Error()
+		case errors.Is(err, models.ErrNotRoomMember):
+			statusCode = http.StatusForbidden
+			errMsg = models.ErrNotRoomMember.Error()
+		default:
+			handleHistoryServiceError(w, err, h.logger)
+
+			return
+		}
+
+		utils.WriteErrorResponse(w, statusCode, errMsg, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, thread, h.logger)
+}
+
+func parseHistoryRequest(r *http.Request) (models.HistoryRequest, error) {
+	query := r.URL.Query()
+	req := models.HistoryRequest{Query: query.Get("q")}
+
+	if before := query.Get("before"); before != "" {
+		beforeID, err := strconv.Atoi(before)
+		if err != nil {
+			return req, models.ErrInvalidCursor
+		}
+
+		req.BeforeID = beforeID
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return req, models.ErrInvalidHistoryLimit
+		}
+
+		req.Limit = n
+	}
+
+	return req, nil
+}
+
+func handleHistoryServiceError(w http.ResponseWriter, err error, log *logrus.Logger) {
+	var statusCode int
+
+	var errMsg string
+
+	switch {
+	case errors.Is(err, models.ErrInvalidCursor), errors.Is(err, models.ErrHistoryLimitTooLarge):
+		statusCode = http.StatusBadRequest
+		errMsg = err.Error()
+	case errors.Is(err, models.ErrNotRoomMember):
+		statusCode = http.StatusForbidden
+		errMsg = models.ErrNotRoomMember.Error()
+	default:
+		statusCode = http.StatusInternalServerError
+		errMsg = "Internal server error"
+	}
+
+	utils.WriteErrorResponse(w, statusCode, errMsg, log)
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/middleware"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/utils"
+)
+
+// ModerationHandler serves the admin API of a room to its owner, its moderators and the server admins.
+type ModerationHandler struct {
+	service  service.ModerationServiceInterface
+	notifier RoomEventsNotifier
+	logger   *logrus.Logger
+}
+
+// NewModerationHandler creates the handler, the notifier may be nil when no chat server runs.
+func NewModerationHandler(
+	s service.ModerationServiceInterface,
+	notifier RoomEventsNotifier,
+	logger *logrus.Logger,
+) *ModerationHandler {
+	return &ModerationHandler{
+		service:  s,
+		notifier: notifier,
+		logger:   logger,
+	}
+}
+
+// Bans serves GET /admin/rooms/{room}/bans with the bans in force.
+func (h *ModerationHandler) Bans(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	bans, err := h.service.ListBans(r.Context(), chi.URLParam(r, "room"), username)
+	if err != nil {
+		handleModerationServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, bans, h.logger)
+}
+
+// Ban serves POST /admin/rooms/{room}/bans with {"username": "bob", "duration": "24h", "reason": "spam"},
+// without a duration the ban is permanent.
+func (h *ModerationHandler) Ban(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	var req models.BanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid JSON data", h.logger)
+
+		return
+	}
+
+	var duration time.Duration
+
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, models.ErrInvalidDuration.Error(), h.logger)
+
+			return
+		}
+
+		duration = d
+	}
+
+	action, err := h.service.Ban(r.Context(), chi.URLParam(r, "room"), username, req.Username, duration, req.Reason)
+	if err != nil {
+		handleModerationServiceError(w, err, h.logger)
+
+		return
+	}
+
+	if h.notifier != nil {
+		h.notifier.ModerationApplied(*action)
+	}
+
+	utils.WriteOkResponse(w, http.StatusCreated, action, h.logger)
+}
+
+// Unban serves DELETE /admin/rooms/{room}/bans/{username}.
+func (h *ModerationHandler) Unban(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	action, err := h.service.Unban(r.Context(), chi.URLParam(r, "room"), username, chi.URLParam(r, "username"))
+	if err != nil {
+		handleModerationServiceError(w, err, h.logger)
+
+		return
+	}
+
+	if h.notifier != nil {
+		h.notifier.ModerationApplied(*action)
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, action, h.logger)
+}
+
+// AuditLog serves GET /admin/rooms/{room}/audit?limit= with the latest moderation actions in the room.
+func (h *ModerationHandler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	var limit int
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			utils.WriteErrorResponse(w, http.StatusBadRequest, models.ErrInvalidHistoryLimit.Error(), h.logger)
+
+			return
+		}
+
+		limit = n
+	}
+
+	actions, err := h.service.AuditLog(r.Context(), chi.URLParam(r, "room"), username, limit)
+	if err != nil {
+		handleModerationServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, actions, h.logger)
+}
+
+// moderationServiceErrors maps the failures of the moderation requests to status codes, their text is safe to show.
+var moderationServiceErrors = []struct {
+	err        error
+	statusCode int
+}{
+	{models.ErrInvalidDuration, http.StatusBadRequest},
+	{models.ErrHistoryLimitTooLarge, http.StatusBadRequest},
+	{models.ErrNotRoomModerator, http.StatusForbidden},
+	{models.ErrCannotModerate, http.StatusForbidden},
+	{models.ErrRoomNotExists, http.StatusNotFound},
+	{models.ErrUserNotFound, http.StatusNotFound},
+	{models.ErrNotBanned, http.StatusNotFound},
+}
+
+func handleModerationServiceError(w http.ResponseWriter, err error, log *logrus.Logger) {
+	for _, serviceErr := range moderationServiceErrors {
+		if errors.Is(err, serviceErr.err) {
+			utils.WriteErrorResponse(w, serviceErr.statusCode, serviceErr.err.Error(), log)
+
+			return
+		}
+	}
+
+	utils.WriteErrorResponse(w, http.StatusInternalServerError, "Internal server error", log)
+}
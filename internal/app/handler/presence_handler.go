@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/middleware"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/utils"
+)
+
+// PresenceProvider reports who is around, it is implemented by the chat server tracking the sessions.
+type PresenceProvider interface {
+	RoomPresence(ctx context.Context, roomName string, requester string) ([]models.Presence, error)
+}
+
+type PresenceHandler struct {
+	presence PresenceProvider
+	logger   *logrus.Logger
+}
+
+func NewPresenceHandler(presence PresenceProvider, logger *logrus.Logger) *PresenceHandler {
+	return &PresenceHandler{
+		presence: presence,
+		logger:   logger,
+	}
+}
+
+// RoomPresence serves GET /rooms/{room}/presence to the members of the room.
+func (h *PresenceHandler) RoomPresence(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	presences, err := h.presence.RoomPresence(r.Context(), chi.URLParam(r, "room"), username)
+	if err != nil {
+		if errors.Is(err, models.ErrNotRoomMember) {
+			utils.WriteErrorResponse(w, http.StatusForbidden, models.ErrNotRoomMember.Error(), h.logger)
+
+			return
+		}
+
+		utils.WriteErrorResponse(w, http.StatusInternalServerError, "Internal server error", h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, presences, h.logger)
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/middleware"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/utils"
+)
+
+// RoomEventsNotifier pushes membership changes made over REST to the connected users,
+// it is implemented by the chat server tracking the sessions.
+type RoomEventsNotifier interface {
+	InvitationCreated(invitation models.RoomInvitation)
+	InvitationAccepted(roomName string, username string)
+	ModerationApplied(action models.ModerationAction)
+}
+
+type RoomsHandler struct {
+	service  service.RoomsServiceInterface
+	notifier RoomEventsNotifier
+	logger   *logrus.Logger
+}
+
+// NewRoomsHandler creates the handler, the notifier may be nil when no chat server runs.
+func NewRoomsHandler(
+	s service.RoomsServiceInterface,
+	notifier RoomEventsNotifier,
+	logger *logrus.Logger,
+) *RoomsHandler {
+	return &RoomsHandler{
+		service:  s,
+		notifier: notifier,
+		logger:   logger,
+	}
+}
+
+// Invite serves POST /rooms/{room}/invitations with {"username": "bob"}.
+func (h *RoomsHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	var req models.InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid JSON data", h.logger)
+
+		return
+	}
+
+	invitation, err := h.service.Invite(r.Context(), chi.URLParam(r, "room"), username, req.Username)
+	if err != nil {
+		handleRoomsServiceError(w, err, h.logger)
+
+		return
+	}
+
+	if h.notifier != nil {
+		h.notifier.InvitationCreated(*invitation)
+	}
+
+	utils.WriteOkResponse(w, http.StatusCreated, invitation, h.logger)
+}
+
+// Invitations serves GET /invitations with the invitations waiting for the authenticated user.
+func (h *RoomsHandler) Invitations(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	invitations, err := h.service.ListInvitations(r.Context(), username)
+	if err != nil {
+		handleRoomsServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, invitations, h.logger)
+}
+
+// AcceptInvitation serves POST /invitations/{room}/accept, the authenticated user joins the room.
+func (h *RoomsHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	roomName := chi.URLParam(r, "room")
+
+	if err := h.service.AcceptInvitation(r.Context(), roomName, username); err != nil {
+		handleRoomsServiceError(w, err, h.logger)
+
+		return
+	}
+
+	if h.notifier != nil {
+		h.notifier.InvitationAccepted(roomName, username)
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, map[string]string{"room": roomName}, h.logger)
+}
+
+// DeclineInvitation serves POST /invitations/{room}/decline.
+func (h *RoomsHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	roomName := chi.URLParam(r, "room")
+
+	if err := h.service.DeclineInvitation(r.Context(), roomName, username); err != nil {
+		handleRoomsServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, map[string]string{"room": roomName}, h.logger)
+}
+
+// SetMemberRole serves PUT /rooms/{room}/members/{username}/role with {"role": "moderator"} to the owner.
+func (h *RoomsHandler) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	username, ok := middleware.UsernameFromContext(r.Context())
+	if !ok {
+		utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+		return
+	}
+
+	var req models.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteErrorResponse(w, http.StatusBadRequest, "Invalid JSON data", h.logger)
+
+		return
+	}
+
+	roomName, member := chi.URLParam(r, "room"), chi.URLParam(r, "username")
+
+	if err := h.service.SetMemberRole(r.Context(), roomName, username, member, req.Role); err != nil {
+		handleRoomsServiceError(w, err, h.logger)
+
+		return
+	}
+
+	utils.WriteOkResponse(w, http.StatusOK, map[string]string{
+		"room":     roomName,
+		"username": member,
+		"role":     req.Role,
+	}, h.logger)
+}
+
+// roomsServiceErrors maps the failures of the membership requests to status codes, their text is safe to show.
+var roomsServiceErrors = []struct {
+	err        error
+	statusCode int
+}{
+	{models.ErrInvalidRole, http.StatusBadRequest},
+	{models.ErrNotRoomMember, http.StatusForbidden},
+	{models.ErrNotRoomModerator, http.StatusForbidden},
+	{models.ErrNotRoomOwner, http.StatusForbidden},
+	{models.ErrRoomPrivate, http.StatusForbidden},
+	{models.ErrRoomNotExists, http.StatusNotFound},
+	{models.ErrUserNotFound, http.StatusNotFound},
+	{models.ErrInvitationNotFound, http.StatusNotFound},
+	{models.ErrAlreadyRoomMember, http.StatusConflict},
+}
+
+func handleRoomsServiceError(w http.ResponseWriter, err error, log *logrus.Logger) {
+	for _, serviceErr := range roomsServiceErrors {
+		if errors.Is(err, serviceErr.err) {
+			utils.WriteErrorResponse(w, serviceErr.statusCode, serviceErr.err.Error(), log)
+
+			return
+		}
+	}
+
+	utils.WriteErrorResponse(w, http.StatusInternalServerError, "Internal server error", log)
+}
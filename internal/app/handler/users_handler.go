@@ -37,14 +37,19 @@ func (h *UsersHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userResponse, token, err := h.service.RegisterUser(r.Context(), input)
+	userResponse, tokens, err := h.service.RegisterUser(r.Context(), input)
 	if err != nil {
 		handleRegisterServiceError(w, err, h.logger)
 
 		return
 	}
 
-	responseData := map[string]interface{}{"user": userResponse, "token": token}
+	responseData := map[string]interface{}{
+		"user":         userResponse,
+		"token":        tokens.Token,
+		"refreshToken": tokens.RefreshToken,
+		"expiresAt":    tokens.ExpiresAt,
+	}
 	utils.WriteOkResponse(w, http.StatusCreated, responseData, h.logger)
 }
 
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/utils"
+	"github.com/stsolovey/kvant_chat/internal/wsconn"
+)
+
+// ChatSessionServer runs chat sessions on already accepted connections.
+type ChatSessionServer interface {
+	// ServeConn serves a user authenticated with token, or when user is nil the client authenticating
+	// with the token of its hello frame, like TCP clients.
+	ServeConn(ctx context.Context, conn net.Conn, user *models.User, token *jwt.Token) error
+}
+
+// maxFrameSize bounds a WebSocket message, ample for a chat frame carrying the longest message.
+const maxFrameSize = 64 << 10
+
+type WSHandler struct {
+	authService service.AuthServiceInterface
+	sessions    ChatSessionServer
+	upgrader    websocket.Upgrader
+	logger      *logrus.Logger
+}
+
+func NewWSHandler(
+	authService service.AuthServiceInterface,
+	sessions ChatSessionServer,
+	logger *logrus.Logger,
+) *WSHandler {
+	return &WSHandler{
+		authService: authService,
+		sessions:    sessions,
+		logger:      logger,
+	}
+}
+
+// Connect upgrades a request to a WebSocket speaking the TCP chat protocol. Clients able to set headers
+// authenticate with the Authorization header before the upgrade, browsers with the token of their hello frame.
+// Tokens are never taken from the URL, request URIs end up in the logs.
+func (h *WSHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	var (
+		user  *models.User
+		token *jwt.Token
+	)
+
+	if tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); tokenString != "" {
+		var err error
+
+		user, token, err = h.userFromToken(r.Context(), tokenString)
+		if err != nil {
+			h.logger.WithError(err).Warn("WebSocket authentication failed")
+			utils.WriteErrorResponse(w, http.StatusUnauthorized, "Unauthorized", h.logger)
+
+			return
+		}
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		h.logger.WithError(err).Warn("WebSocket upgrade failed")
+
+		return
+	}
+
+	ws.SetReadLimit(maxFrameSize)
+
+	conn := wsconn.New(ws)
+
+	// The HTTP server's read and write timeouts stay set on the hijacked connection.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		h.logger.WithError(err).Warn("Failed to reset WebSocket deadlines")
+	}
+
+	// The request context is derived from the server's, so sessions end on shutdown too.
+	if err := h.sessions.ServeConn(r.Context(), conn, user, token); err != nil {
+		h.logger.WithError(err).Info("WebSocket session ended")
+	}
+}
+
+func (h *WSHandler) userFromToken(ctx context.Context, tokenString string) (*models.User, *jwt.Token, error) {
+	token, err := h.authService.ValidateToken(ctx, tokenString)
+	if err != nil {
+		return nil, nil, err //nolint:wrapcheck // already wrapped by the service.
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, nil, models.ErrParseJWTClaimsAsMapClaims
+	}
+
+	username, ok := claims["username"].(string)
+	if !ok {
+		return nil, nil, models.ErrUsernameClaimIsNotString
+	}
+
+	user, err := h.authService.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, nil, err //nolint:wrapcheck // already wrapped by the service.
+	}
+
+	return user, token, nil
+}
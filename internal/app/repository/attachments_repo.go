@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+type AttachmentsRepositoryInterface interface {
+	Create(ctx context.Context, attachment models.Attachment) (*models.Attachment, error)
+	GetByID(ctx context.Context, attachmentID string) (*models.Attachment, error)
+}
+
+// attachmentColumns selects what scanAttachment scans from the attachments aliased a joined with their uploader u.
+const attachmentColumns = `a.attachment_id, a.name, a.size, a.mime_type, u.username,
+	COALESCE(a.message_id, 0), a.created_at`
+
+type AttachmentsRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAttachmentsRepository(db *pgxpool.Pool) AttachmentsRepositoryInterface {
+	return &AttachmentsRepository{db: db}
+}
+
+// Create records an uploaded file of attachment.Uploader, not linked to any message yet.
+func (r *AttachmentsRepository) Create(
+	ctx context.Context,
+	attachment models.Attachment,
+) (*models.Attachment, error) {
+	sql := `WITH a AS (
+		INSERT INTO attachments (attachment_id, uploader_id, name, size, mime_type)
+		SELECT $1, user_id, $3, $4, $5 FROM users WHERE username = $2
+		RETURNING *
+	)
+	SELECT ` + attachmentColumns + `
+	FROM a
+	JOIN users u ON u.user_id = a.uploader_id`
+
+	rows, err := r.db.Query(
+		ctx,
+		sql,
+		attachment.ID,
+		attachment.Uploader,
+		attachment.Name,
+		attachment.Size,
+		attachment.MIMEType,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("attachments repository Create: %w", err)
+	}
+
+	created, err := pgx.CollectExactlyOneRow(rows, scanAttachment)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("attachments repository Create: %w", models.ErrUserNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("attachments repository Create pgx.CollectExactlyOneRow(...): %w", err)
+	}
+
+	return &created, nil
+}
+
+// GetByID returns the attachment, it fails with ErrAttachmentNotFound when there is none.
+func (r *AttachmentsRepository) GetByID(ctx context.Context, attachmentID string) (*models.Attachment, error) {
+	sql := `SELECT ` + attachmentColumns + `
+	FROM attachments a
+	JOIN users u ON u.user_id = a.uploader_id
+	WHERE a.attachment_id = $1`
+
+	rows, err := r.db.Query(ctx, sql, attachmentID)
+	if err != nil {
+		return nil, fmt.Errorf("attachments repository GetByID: %w", err)
+	}
+
+	attachment, err := pgx.CollectExactlyOneRow(rows, scanAttachment)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("attachments repository GetByID: %w", models.ErrAttachmentNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("attachments repository GetByID pgx.CollectExactlyOneRow(...): %w", err)
+	}
+
+	return &attachment, nil
+}
+
+func scanAttachment(row pgx.CollectableRow) (models.Attachment, error) {
+	var attachment models.Attachment
+
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.Name,
+		&attachment.Size,
+		&attachment.MIMEType,
+		&attachment.Uploader,
+		&attachment.MessageID,
+		&attachment.CreatedAt,
+	)
+
+	return attachment, err //nolint:wrapcheck
+}
@@ -2,14 +2,22 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stsolovey/kvant_chat/internal/models"
 )
 
 type AuthRepositoryInterface interface {
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	CreateRefreshToken(ctx context.Context, username string, token models.RefreshToken) error
+	RotateRefreshToken(ctx context.Context, hash string, next models.RefreshToken) (string, error)
+	RevokeSession(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserSessions(ctx context.Context, username string) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
 }
 
 type AuthRepository struct {
@@ -39,8 +47,229 @@ func (r *AuthRepository) GetUserByUsername(
 		&user.Deleted,
 	)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, models.ErrUserNotFound
+		}
+
 		return nil, fmt.Errorf("auth repository GetUserByUsername error: %w", err)
 	}
 
 	return &user, nil
 }
+
+// CreateRefreshToken stores a refresh token of the user, dropping the user's expired ones.
+func (r *AuthRepository) CreateRefreshToken(ctx context.Context, username string, token models.RefreshToken) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("auth repository CreateRefreshToken r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	var userID int
+
+	err = tx.QueryRow(ctx, `SELECT user_id FROM users WHERE username = $1 AND NOT deleted`, username).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrUserNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("auth repository CreateRefreshToken: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < now()`, userID); err != nil {
+		return fmt.Errorf("auth repository CreateRefreshToken delete expired: %w", err)
+	}
+
+	if err := insertRefreshToken(ctx, tx, userID, token); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("auth repository CreateRefreshToken tx.Commit(...): %w", err)
+	}
+
+	return nil
+}
+
+// RotateRefreshToken exchanges the refresh token with the given hash for the next one and returns
+// the username of its owner. Unknown, expired and revoked tokens fail with ErrInvalidRefreshToken;
+// one already exchanged may have been stolen, so every session of its owner is revoked as well.
+// A token revoked by a logout is merely refused.
+func (r *AuthRepository) RotateRefreshToken(
+	ctx context.Context,
+	hash string,
+	next models.RefreshToken,
+) (string, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("auth repository RotateRefreshToken r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	var (
+		userID   int
+		username string
+		expired  bool
+		revoked  bool
+		rotated  bool
+	)
+
+	sql := `SELECT t.user_id, u.username, t.expires_at < now(), t.revoked_at IS NOT NULL, t.rotated_at IS NOT NULL
+	FROM refresh_tokens t
+	JOIN users u ON u.user_id = t.user_id
+	WHERE t.token_hash = $1 AND NOT u.deleted
+	FOR UPDATE OF t`
+
+	err = tx.QueryRow(ctx, sql, hash).Scan(&userID, &username, &expired, &revoked, &rotated)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", models.ErrInvalidRefreshToken
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("auth repository RotateRefreshToken: %w", err)
+	}
+
+	switch {
+	case rotated:
+		if err := revokeUserSessions(ctx, tx, userID); err != nil {
+			return "", err
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return "", fmt.Errorf("auth repository RotateRefreshToken tx.Commit(...): %w", err)
+		}
+
+		return "", models.ErrInvalidRefreshToken
+	case revoked, expired:
+		return "", models.ErrInvalidRefreshToken
+	}
+
+	sql = `UPDATE refresh_tokens SET revoked_at = now(), rotated_at = now() WHERE token_hash = $1`
+
+	if _, err := tx.Exec(ctx, sql, hash); err != nil {
+		return "", fmt.Errorf("auth repository RotateRefreshToken revoke: %w", err)
+	}
+
+	if err := insertRefreshToken(ctx, tx, userID, next); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("auth repository RotateRefreshToken tx.Commit(...): %w", err)
+	}
+
+	return username, nil
+}
+
+// RevokeSession refuses the access token with the given ID until it expires
+// and revokes the refresh token issued with it.
+func (r *AuthRepository) RevokeSession(ctx context.Context, jti string, expiresAt time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("auth repository RevokeSession r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	sql := `INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`
+
+	if _, err := tx.Exec(ctx, sql, jti, expiresAt); err != nil {
+		return fmt.Errorf("auth repository RevokeSession insert: %w", err)
+	}
+
+	sql = `UPDATE refresh_tokens SET revoked_at = now() WHERE access_jti = $1 AND revoked_at IS NULL`
+
+	if _, err := tx.Exec(ctx, sql, jti); err != nil {
+		return fmt.Errorf("auth repository RevokeSession revoke refresh token: %w", err)
+	}
+
+	// Revoked tokens are only kept until they would have expired anyway.
+	if _, err := tx.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`); err != nil {
+		return fmt.Errorf("auth repository RevokeSession delete expired: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("auth repository RevokeSession tx.Commit(...): %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes every refresh token of the user and the access tokens issued with them.
+func (r *AuthRepository) RevokeUserSessions(ctx context.Context, username string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("auth repository RevokeUserSessions r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	var userID int
+
+	err = tx.QueryRow(ctx, `SELECT user_id FROM users WHERE username = $1`, username).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrUserNotFound
+	}
+
+	if err != nil {
+		return fmt.Errorf("auth repository RevokeUserSessions: %w", err)
+	}
+
+	if err := revokeUserSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("auth repository RevokeUserSessions tx.Commit(...): %w", err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports whether the access token with the given ID has been revoked.
+func (r *AuthRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+
+	sql := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	if err := r.db.QueryRow(ctx, sql, jti).Scan(&revoked); err != nil {
+		return false, fmt.Errorf("auth repository IsTokenRevoked: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func insertRefreshToken(ctx context.Context, tx pgx.Tx, userID int, token models.RefreshToken) error {
+	sql := `INSERT INTO refresh_tokens (token_hash, user_id, access_jti, access_expires_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := tx.Exec(ctx, sql, token.Hash, userID, token.AccessJTI, token.AccessExpiresAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("auth repository insertRefreshToken: %w", err)
+	}
+
+	return nil
+}
+
+// revokeUserSessions revokes the refresh tokens of the user and the access tokens issued with them
+// that have not expired yet.
+func revokeUserSessions(ctx context.Context, tx pgx.Tx, userID int) error {
+	sql := `INSERT INTO revoked_tokens (jti, expires_at)
+	SELECT access_jti, access_expires_at FROM refresh_tokens
+	WHERE user_id = $1 AND access_expires_at > now()
+	ON CONFLICT (jti) DO NOTHING`
+
+	if _, err := tx.Exec(ctx, sql, userID); err != nil {
+		return fmt.Errorf("auth repository revokeUserSessions revoke access tokens: %w", err)
+	}
+
+	sql = `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	if _, err := tx.Exec(ctx, sql, userID); err != nil {
+		return fmt.Errorf("auth repository revokeUserSessions revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,745 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+type MessagesRepositoryInterface interface {
+	Create(ctx context.Context, msg models.Message) (*models.Message, error)
+	GetByClientID(ctx context.Context, sender string, clientID string) (*models.Message, error)
+	ListRoomMessages(ctx context.Context, roomName string, req models.HistoryRequest) ([]models.Message, error)
+	ListDirectMessages(
+		ctx context.Context,
+		username string,
+		peer string,
+		req models.HistoryRequest,
+	) ([]models.Message, error)
+	ListUndeliveredDirectMessages(ctx context.Context, username string) ([]models.Message, error)
+	MarkDelivered(ctx context.Context, messageIDs []int) error
+	MarkRoomRead(ctx context.Context, roomName string, username string, messageID int) (int, error)
+	MarkDirectRead(ctx context.Context, username string, peer string, messageID int) (*models.ReadReceipt, error)
+	ListUnreadCounts(ctx context.Context, username string) ([]models.UnreadCount, error)
+	GetByID(ctx context.Context, messageID int) (*models.Message, error)
+	UpdateContent(ctx context.Context, messageID int, content string) (*models.Message, error)
+	MarkDeleted(ctx context.Context, messageID int) (*models.Message, error)
+	AddReaction(ctx context.Context, messageID int, username string, emoji string) (bool, error)
+	RemoveReaction(ctx context.Context, messageID int, username string, emoji string) (bool, error)
+	ListReactions(ctx context.Context, messageIDs []int) (map[int][]models.ReactionCount, error)
+	ListThreadMessages(ctx context.Context, threadID int, req models.HistoryRequest) ([]models.Message, error)
+	SubscribeThread(ctx context.Context, threadID int, username string) error
+	UnsubscribeThread(ctx context.Context, threadID int, username string) (bool, error)
+	ListThreadSubscribers(ctx context.Context, threadID int) ([]string, error)
+	CreateMentions(ctx context.Context, messageID int, usernames []string) error
+	ListMentions(ctx context.Context, username string, req models.HistoryRequest) ([]models.Message, error)
+	ListAttachments(ctx context.Context, messageIDs []int) (map[int][]models.Attachment, error)
+}
+
+// messageColumns and messageJoins select what queryMessages scans from the messages aliased m.
+const (
+	messageColumns = `m.message_id, COALESCE(r.name, ''), s.username, COALESCE(rcv.username, ''),
+		m.content, m.created_at, m.edited_at, m.deleted_at IS NOT NULL,
+		COALESCE(m.reply_to, 0), COALESCE(m.thread_id, 0),
+		COALESCE(ps.username, ''), COALESCE(LEFT(p.content, 80), ''), p.deleted_at IS NOT NULL`
+	messageJoins = `JOIN users s ON s.user_id = m.sender_id
+	LEFT JOIN rooms r ON r.room_id = m.room_id
+	LEFT JOIN users rcv ON rcv.user_id = m.receiver_id
+	LEFT JOIN messages p ON p.message_id = m.reply_to
+	LEFT JOIN users ps ON ps.user_id = p.sender_id`
+)
+
+type MessagesRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewMessagesRepository(db *pgxpool.Pool) MessagesRepositoryInterface {
+	return &MessagesRepository{db: db}
+}
+
+// Create stores a room message (msg.Room set) or a direct message (msg.Receiver set).
+// ID and CreatedAt of the returned message are assigned by the database.
+// A message repeating the ClientID of one already stored for the sender fails with ErrDuplicateMessage.
+// The attachments referenced by ID are linked to the message, they must be uploaded by the sender
+// and not linked to another message, otherwise nothing is stored and it fails with ErrAttachmentNotFound.
+func (r *MessagesRepository) Create(
+	ctx context.Context,
+	msg models.Message,
+) (*models.Message, error) {
+	created := msg
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository Create r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	sql := `INSERT INTO messages (room_id, sender_id, receiver_id, content, client_id, reply_to, thread_id)
+	VALUES (
+		(SELECT room_id FROM rooms WHERE name = $1),
+		(SELECT user_id FROM users WHERE username = $2),
+		(SELECT user_id FROM users WHERE username = $3),
+		$4,
+		$5,
+		$6,
+		$7
+	)
+	ON CONFLICT (sender_id, client_id) WHERE client_id IS NOT NULL DO NOTHING
+	RETURNING message_id, created_at`
+
+	err = tx.QueryRow(
+		ctx,
+		sql,
+		nullIfEmpty(msg.Room),
+		msg.Sender,
+		nullIfEmpty(msg.Receiver),
+		msg.Content,
+		nullIfEmpty(msg.ClientID),
+		nullIfZero(msg.ReplyTo),
+		nullIfZero(msg.ThreadID),
+	).Scan(
+		&created.ID,
+		&created.CreatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("messages repository Create: %w", models.ErrDuplicateMessage)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("messages repository Create: %w", err)
+	}
+
+	if len(msg.Attachments) > 0 {
+		created.Attachments, err = linkAttachments(ctx, tx, created.ID, msg.Sender, msg.Attachments)
+		if err != nil {
+			return nil, fmt.Errorf("messages repository Create: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("messages repository Create tx.Commit(...): %w", err)
+	}
+
+	return &created, nil
+}
+
+func linkAttachments(
+	ctx context.Context,
+	tx pgx.Tx,
+	messageID int,
+	sender string,
+	refs []models.Attachment,
+) ([]models.Attachment, error) {
+	ids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		ids = append(ids, ref.ID)
+	}
+
+	sql := `UPDATE attachments a SET message_id = $1
+	FROM users u
+	WHERE u.user_id = a.uploader_id AND u.username = $2
+	AND a.attachment_id = ANY($3) AND a.message_id IS NULL
+	RETURNING ` + attachmentColumns
+
+	rows, err := tx.Query(ctx, sql, messageID, sender, ids)
+	if err != nil {
+		return nil, fmt.Errorf("linkAttachments tx.Query(...): %w", err)
+	}
+
+	linked, err := pgx.CollectRows(rows, scanAttachment)
+	if err != nil {
+		return nil, fmt.Errorf("linkAttachments pgx.CollectRows(...): %w", err)
+	}
+
+	if len(linked) != len(ids) {
+		return nil, models.ErrAttachmentNotFound
+	}
+
+	// Keep the order the sender listed them in.
+	slices.SortFunc(linked, func(a, b models.Attachment) int {
+		return slices.Index(ids, a.ID) - slices.Index(ids, b.ID)
+	})
+
+	return linked, nil
+}
+
+// GetByClientID returns the message the sender stored with the client-generated ID.
+func (r *MessagesRepository) GetByClientID(
+	ctx context.Context,
+	sender string,
+	clientID string,
+) (*models.Message, error) {
+	sql := `SELECT ` + messageColumns + `
+	FROM messages m
+	` + messageJoins + `
+	WHERE s.username = $1 AND m.client_id = $2`
+
+	messages, err := r.queryMessages(ctx, sql, sender, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository GetByClientID: %w", err)
+	}
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("messages repository GetByClientID: %w", models.ErrMessageNotFound)
+	}
+
+	messages[0].ClientID = clientID
+
+	return &messages[0], nil
+}
+
+// ListRoomMessages returns a page of room messages, ordered from the oldest to the newest.
+func (r *MessagesRepository) ListRoomMessages(
+	ctx context.Context,
+	roomName string,
+	req models.HistoryRequest,
+) ([]models.Message, error) {
+	sql := `SELECT ` + messageColumns + `
+	FROM messages m
+	` + messageJoins + `
+	WHERE r.name = $1
+	AND ($2 = 0 OR m.message_id < $2)
+	AND ($3 = '' OR m.content_tsv @@ plainto_tsquery('simple', $3))
+	ORDER BY m.message_id DESC
+	LIMIT $4`
+
+	messages, err := r.queryMessages(ctx, sql, roomName, req.BeforeID, req.Query, req.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListRoomMessages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// ListDirectMessages returns a page of direct messages between two users in both directions,
+// ordered from the oldest to the newest.
+func (r *MessagesRepository) ListDirectMessages(
+	ctx context.Context,
+	username string,
+	peer string,
+	req models.HistoryRequest,
+) ([]models.Message, error) {
+	sql := `SELECT ` + messageColumns + `
+	FROM messages m
+	` + messageJoins + `
+	WHERE ((s.username = $1 AND rcv.username = $2) OR (s.username = $2 AND rcv.username = $1))
+	AND ($3 = 0 OR m.message_id < $3)
+	AND ($4 = '' OR m.content_tsv @@ plainto_tsquery('simple', $4))
+	ORDER BY m.message_id DESC
+	LIMIT $5`
+
+	messages, err := r.queryMessages(ctx, sql, username, peer, req.BeforeID, req.Query, req.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListDirectMessages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// ListUndeliveredDirectMessages returns the direct messages to the user that were never delivered,
+// ordered from the oldest to the newest.
+func (r *MessagesRepository) ListUndeliveredDirectMessages(
+	ctx context.Context,
+	username string,
+) ([]models.Message, error) {
+	sql := `SELECT ` + messageColumns + `
+	FROM messages m
+	` + messageJoins + `
+	WHERE rcv.username = $1 AND m.delivered_at IS NULL AND m.deleted_at IS NULL
+	ORDER BY m.message_id DESC`
+
+	messages, err := r.queryMessages(ctx, sql, username)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListUndeliveredDirectMessages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// MarkDelivered records that the direct messages reached their receiver.
+func (r *MessagesRepository) MarkDelivered(ctx context.Context, messageIDs []int) error {
+	sql := `UPDATE messages SET delivered_at = now()
+	WHERE message_id = ANY($1) AND delivered_at IS NULL`
+
+	if _, err := r.db.Exec(ctx, sql, messageIDs); err != nil {
+		return fmt.Errorf("messages repository MarkDelivered: %w", err)
+	}
+
+	return nil
+}
+
+// MarkRoomRead moves the user's read marker of the room forward to the latest message of the room
+// up to messageID, or to the latest message when it is 0, and returns the marker. An ID past the
+// room's messages cannot mark later ones read. It fails with ErrNotRoomMember for rooms the user is not in.
+func (r *MessagesRepository) MarkRoomRead(
+	ctx context.Context,
+	roomName string,
+	username string,
+	messageID int,
+) (int, error) {
+	sql := `UPDATE room_members rm
+	SET last_read_message_id = GREATEST(rm.last_read_message_id, (SELECT COALESCE(MAX(m.message_id), 0)
+		FROM messages m WHERE m.room_id = rm.room_id AND ($3 = 0 OR m.message_id <= $3)))
+	FROM rooms r, users u
+	WHERE r.room_id = rm.room_id AND u.user_id = rm.user_id
+	AND r.name = $1 AND u.username = $2
+	RETURNING rm.last_read_message_id`
+
+	var lastReadID int
+
+	err := r.db.QueryRow(ctx, sql, roomName, username, messageID).Scan(&lastReadID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, models.ErrNotRoomMember
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("messages repository MarkRoomRead: %w", err)
+	}
+
+	return lastReadID, nil
+}
+
+// MarkDirectRead marks the unread direct messages from the peer to the user read, up to messageID
+// or all of them when it is 0. The receipt lists the messages read now, it is nil when there were none.
+func (r *MessagesRepository) MarkDirectRead(
+	ctx context.Context,
+	username string,
+	peer string,
+	messageID int,
+) (*models.ReadReceipt, error) {
+	sql := `UPDATE messages m SET read_at = now()
+	FROM users s, users rcv
+	WHERE s.user_id = m.sender_id AND rcv.user_id = m.receiver_id
+	AND rcv.username = $1 AND s.username = $2
+	AND m.read_at IS NULL
+	AND ($3 = 0 OR m.message_id <= $3)
+	RETURNING m.message_id, m.read_at`
+
+	rows, err := r.db.Query(ctx, sql, username, peer, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository MarkDirectRead: %w", err)
+	}
+	defer rows.Close()
+
+	receipt := &models.ReadReceipt{Reader: username}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id, &receipt.ReadAt); err != nil {
+			return nil, fmt.Errorf("messages repository MarkDirectRead rows.Scan(...): %w", err)
+		}
+
+		receipt.MessageIDs = append(receipt.MessageIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("messages repository MarkDirectRead rows.Err(): %w", err)
+	}
+
+	if len(receipt.MessageIDs) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+
+	slices.Sort(receipt.MessageIDs)
+
+	return receipt, nil
+}
+
+// ListUnreadCounts returns the unread messages of every room the user is in, then of every peer
+// who sent the user unread direct messages.
+func (r *MessagesRepository) ListUnreadCounts(ctx context.Context, username string) ([]models.UnreadCount, error) {
+	sql := `SELECT r.name, '', rm.last_read_message_id, COUNT(m.message_id)
+	FROM room_members rm
+	JOIN users u ON u.user_id = rm.user_id
+	JOIN rooms r ON r.room_id = rm.room_id
+	LEFT JOIN messages m ON m.room_id = rm.room_id
+		AND m.message_id > rm.last_read_message_id
+		AND m.sender_id <> rm.user_id
+		AND m.deleted_at IS NULL
+	WHERE u.username = $1
+	GROUP BY r.name, rm.last_read_message_id
+	UNION ALL
+	SELECT '', s.username, 0, COUNT(*)
+	FROM messages m
+	JOIN users s ON s.user_id = m.sender_id
+	JOIN users rcv ON rcv.user_id = m.receiver_id
+	WHERE rcv.username = $1 AND m.read_at IS NULL AND m.deleted_at IS NULL
+	GROUP BY s.username
+	ORDER BY 1, 2`
+
+	rows, err := r.db.Query(ctx, sql, username)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListUnreadCounts: %w", err)
+	}
+
+	counts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.UnreadCount, error) {
+		var count models.UnreadCount
+		err := row.Scan(&count.Room, &count.Peer, &count.LastReadID, &count.Unread)
+
+		return count, err //nolint:wrapcheck
+	})
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListUnreadCounts pgx.CollectRows(...): %w", err)
+	}
+
+	return counts, nil
+}
+
+// GetByID returns the message with the given ID, it fails with ErrMessageNotFound when there is none.
+func (r *MessagesRepository) GetByID(ctx context.Context, messageID int) (*models.Message, error) {
+	sql := `SELECT ` + messageColumns + `
+	FROM messages m
+	` + messageJoins + `
+	WHERE m.message_id = $1`
+
+	messages, err := r.queryMessages(ctx, sql, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository GetByID: %w", err)
+	}
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("messages repository GetByID: %w", models.ErrMessageNotFound)
+	}
+
+	return &messages[0], nil
+}
+
+// UpdateContent replaces the content of a message not deleted yet and stamps the edit time.
+func (r *MessagesRepository) UpdateContent(
+	ctx context.Context,
+	messageID int,
+	content string,
+) (*models.Message, error) {
+	sql := `WITH m AS (
+		UPDATE messages SET content = $2, edited_at = now()
+		WHERE message_id = $1 AND deleted_at IS NULL
+		RETURNING *
+	)
+	SELECT ` + messageColumns + `
+	FROM m
+	` + messageJoins
+
+	messages, err := r.queryMessages(ctx, sql, messageID, content)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository UpdateContent: %w", err)
+	}
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("messages repository UpdateContent: %w", models.ErrMessageNotFound)
+	}
+
+	return &messages[0], nil
+}
+
+// MarkDeleted drops the content of a message not deleted yet, its row stays in the history as deleted.
+func (r *MessagesRepository) MarkDeleted(ctx context.Context, messageID int) (*models.Message, error) {
+	sql := `WITH m AS (
+		UPDATE messages SET content = '', deleted_at = now()
+		WHERE message_id = $1 AND deleted_at IS NULL
+		RETURNING *
+	)
+	SELECT ` + messageColumns + `
+	FROM m
+	` + messageJoins
+
+	messages, err := r.queryMessages(ctx, sql, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository MarkDeleted: %w", err)
+	}
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("messages repository MarkDeleted: %w", models.ErrMessageNotFound)
+	}
+
+	return &messages[0], nil
+}
+
+// AddReaction records the user's reaction to the message and reports whether it is new.
+func (r *MessagesRepository) AddReaction(
+	ctx context.Context,
+	messageID int,
+	username string,
+	emoji string,
+) (bool, error) {
+	sql := `INSERT INTO message_reactions (message_id, user_id, emoji)
+	SELECT $1, user_id, $3 FROM users WHERE username = $2
+	ON CONFLICT (message_id, emoji, user_id) DO NOTHING`
+
+	tag, err := r.db.Exec(ctx, sql, messageID, username, emoji)
+	if err != nil {
+		return false, fmt.Errorf("messages repository AddReaction: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// RemoveReaction drops the user's reaction to the message and reports whether there was one.
+func (r *MessagesRepository) RemoveReaction(
+	ctx context.Context,
+	messageID int,
+	username string,
+	emoji string,
+) (bool, error) {
+	sql := `DELETE FROM message_reactions
+	WHERE message_id = $1 AND emoji = $3
+	AND user_id = (SELECT user_id FROM users WHERE username = $2)`
+
+	tag, err := r.db.Exec(ctx, sql, messageID, username, emoji)
+	if err != nil {
+		return false, fmt.Errorf("messages repository RemoveReaction: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListReactions sums up the reactions to the messages per emoji, in the order the emojis were first used.
+// Messages without reactions are left out.
+func (r *MessagesRepository) ListReactions(
+	ctx context.Context,
+	messageIDs []int,
+) (map[int][]models.ReactionCount, error) {
+	sql := `SELECT mr.message_id, mr.emoji, COUNT(*), array_agg(u.username ORDER BY mr.created_at, u.username)
+	FROM message_reactions mr
+	JOIN users u ON u.user_id = mr.user_id
+	WHERE mr.message_id = ANY($1)
+	GROUP BY mr.message_id, mr.emoji
+	ORDER BY mr.message_id, MIN(mr.created_at), mr.emoji`
+
+	rows, err := r.db.Query(ctx, sql, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListReactions: %w", err)
+	}
+	defer rows.Close()
+
+	reactions := make(map[int][]models.ReactionCount)
+
+	for rows.Next() {
+		var (
+			messageID int
+			count     models.ReactionCount
+		)
+
+		if err := rows.Scan(&messageID, &count.Emoji, &count.Count, &count.Users); err != nil {
+			return nil, fmt.Errorf("messages repository ListReactions rows.Scan(...): %w", err)
+		}
+
+		reactions[messageID] = append(reactions[messageID], count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("messages repository ListReactions rows.Err(): %w", err)
+	}
+
+	return reactions, nil
+}
+
+// ListThreadMessages returns a page of replies in the thread, ordered from the oldest to the newest.
+func (r *MessagesRepository) ListThreadMessages(
+	ctx context.Context,
+	threadID int,
+	req models.HistoryRequest,
+) ([]models.Message, error) {
+	sql := `SELECT ` + messageColumns + `
+	FROM messages m
+	` + messageJoins + `
+	WHERE m.thread_id = $1
+	AND ($2 = 0 OR m.message_id < $2)
+	ORDER BY m.message_id DESC
+	LIMIT $3`
+
+	messages, err := r.queryMessages(ctx, sql, threadID, req.BeforeID, req.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListThreadMessages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// SubscribeThread notifies the user of new replies in the thread, subscribing twice is not an error.
+func (r *MessagesRepository) SubscribeThread(ctx context.Context, threadID int, username string) error {
+	sql := `INSERT INTO thread_subscriptions (thread_id, user_id)
+	SELECT $1, user_id FROM users WHERE username = $2
+	ON CONFLICT (thread_id, user_id) DO NOTHING`
+
+	if _, err := r.db.Exec(ctx, sql, threadID, username); err != nil {
+		return fmt.Errorf("messages repository SubscribeThread: %w", err)
+	}
+
+	return nil
+}
+
+// UnsubscribeThread stops notifying the user of new replies and reports whether it was subscribed.
+func (r *MessagesRepository) UnsubscribeThread(ctx context.Context, threadID int, username string) (bool, error) {
+	sql := `DELETE FROM thread_subscriptions
+	WHERE thread_id = $1
+	AND user_id = (SELECT user_id FROM users WHERE username = $2)`
+
+	tag, err := r.db.Exec(ctx, sql, threadID, username)
+	if err != nil {
+		return false, fmt.Errorf("messages repository UnsubscribeThread: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListThreadSubscribers returns the usernames subscribed to the thread.
+func (r *MessagesRepository) ListThreadSubscribers(ctx context.Context, threadID int) ([]string, error) {
+	sql := `SELECT u.username FROM thread_subscriptions ts
+	JOIN users u ON u.user_id = ts.user_id
+	WHERE ts.thread_id = $1
+	ORDER BY u.username`
+
+	rows, err := r.db.Query(ctx, sql, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListThreadSubscribers: %w", err)
+	}
+
+	usernames, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListThreadSubscribers pgx.CollectRows(...): %w", err)
+	}
+
+	return usernames, nil
+}
+
+// CreateMentions records that the message mentions the users.
+func (r *MessagesRepository) CreateMentions(ctx context.Context, messageID int, usernames []string) error {
+	sql := `INSERT INTO message_mentions (message_id, user_id)
+	SELECT $1, user_id FROM users WHERE username = ANY($2)
+	ON CONFLICT (user_id, message_id) DO NOTHING`
+
+	if _, err := r.db.Exec(ctx, sql, messageID, usernames); err != nil {
+		return fmt.Errorf("messages repository CreateMentions: %w", err)
+	}
+
+	return nil
+}
+
+// ListMentions returns a page of the messages mentioning the user that were not deleted,
+// ordered from the oldest to the newest. Mentions in rooms the user is no longer a member of are left out.
+func (r *MessagesRepository) ListMentions(
+	ctx context.Context,
+	username string,
+	req models.HistoryRequest,
+) ([]models.Message, error) {
+	sql := `SELECT ` + messageColumns + `
+	FROM message_mentions mm
+	JOIN users mu ON mu.user_id = mm.user_id
+	JOIN messages m ON m.message_id = mm.message_id
+	JOIN room_members mrm ON mrm.room_id = m.room_id AND mrm.user_id = mm.user_id
+	` + messageJoins + `
+	WHERE mu.username = $1 AND m.deleted_at IS NULL
+	AND ($2 = 0 OR m.message_id < $2)
+	ORDER BY m.message_id DESC
+	LIMIT $3`
+
+	messages, err := r.queryMessages(ctx, sql, username, req.BeforeID, req.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListMentions: %w", err)
+	}
+
+	return messages, nil
+}
+
+// ListAttachments returns the attachments of the messages in the order they were uploaded.
+// Messages without attachments are left out.
+func (r *MessagesRepository) ListAttachments(
+	ctx context.Context,
+	messageIDs []int,
+) (map[int][]models.Attachment, error) {
+	sql := `SELECT ` + attachmentColumns + `
+	FROM attachments a
+	JOIN users u ON u.user_id = a.uploader_id
+	WHERE a.message_id = ANY($1)
+	ORDER BY a.message_id, a.created_at, a.attachment_id`
+
+	rows, err := r.db.Query(ctx, sql, messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListAttachments: %w", err)
+	}
+
+	attachments, err := pgx.CollectRows(rows, scanAttachment)
+	if err != nil {
+		return nil, fmt.Errorf("messages repository ListAttachments pgx.CollectRows(...): %w", err)
+	}
+
+	byMessage := make(map[int][]models.Attachment)
+	for _, attachment := range attachments {
+		byMessage[attachment.MessageID] = append(byMessage[attachment.MessageID], attachment)
+	}
+
+	return byMessage, nil
+}
+
+// queryMessages scans rows of messageColumns selected newest first and returns them oldest first.
+func (r *MessagesRepository) queryMessages(ctx context.Context, sql string, args ...any) ([]models.Message, error) {
+	rows, err := r.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("r.db.Query(...): %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+
+	for rows.Next() {
+		var (
+			msg    models.Message
+			parent models.MessagePreview
+		)
+
+		err := rows.Scan(
+			&msg.ID,
+			&msg.Room,
+			&msg.Sender,
+			&msg.Receiver,
+			&msg.Content,
+			&msg.CreatedAt,
+			&msg.EditedAt,
+			&msg.Deleted,
+			&msg.ReplyTo,
+			&msg.ThreadID,
+			&parent.Sender,
+			&parent.Content,
+			&parent.Deleted,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan(...): %w", err)
+		}
+
+		if msg.ReplyTo != 0 {
+			msg.Parent = &parent
+		}
+
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err(): %w", err)
+	}
+
+	slices.Reverse(messages)
+
+	return messages, nil
+}
+
+func nullIfZero(n int) *int {
+	if n == 0 {
+		return nil
+	}
+
+	return &n
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
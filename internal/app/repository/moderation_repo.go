@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+type ModerationRepositoryInterface interface {
+	Record(ctx context.Context, action models.ModerationAction) (*models.ModerationAction, error)
+	ActiveSanction(ctx context.Context, roomName string, username string, kind string) (*models.Sanction, error)
+	ListSanctions(ctx context.Context, roomName string, kind string) ([]models.Sanction, error)
+	ListActions(ctx context.Context, roomName string, limit int) ([]models.ModerationAction, error)
+}
+
+// sanctionColumns selects what scanSanction scans from the sanctions aliased s joined with
+// their room r, user u and moderator m.
+const sanctionColumns = `r.name, u.username, s.kind, m.username, s.reason, s.expires_at, s.created_at`
+
+// activeSanction restricts the sanctions aliased s to those in force.
+const activeSanction = `(s.expires_at IS NULL OR s.expires_at > now())`
+
+type ModerationRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewModerationRepository(db *pgxpool.Pool) ModerationRepositoryInterface {
+	return &ModerationRepository{db: db}
+}
+
+// Record applies the moderation action and appends it to the audit trail of the room in one transaction.
+// A kick fails with ErrNotRoomMember for others, lifting a ban or a mute not in force
+// with ErrNotBanned or ErrNotMuted.
+func (r *ModerationRepository) Record(
+	ctx context.Context,
+	action models.ModerationAction,
+) (*models.ModerationAction, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository Record r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	var roomID, moderatorID, targetID int
+
+	sql := `SELECT r.room_id, m.user_id, t.user_id
+	FROM rooms r, users m, users t
+	WHERE r.name = $1 AND m.username = $2 AND t.username = $3`
+
+	err = tx.QueryRow(ctx, sql, action.Room, action.Moderator, action.Target).Scan(&roomID, &moderatorID, &targetID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, models.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository Record: %w", err)
+	}
+
+	if err := applyAction(ctx, tx, action, roomID, moderatorID, targetID); err != nil {
+		return nil, err
+	}
+
+	sql = `INSERT INTO moderation_actions (room_id, moderator_id, target_id, action, reason, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING action_id, created_at`
+
+	recorded := action
+
+	err = tx.QueryRow(ctx, sql, roomID, moderatorID, targetID, action.Action, action.Reason, action.ExpiresAt).
+		Scan(&recorded.ID, &recorded.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository Record insert action: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("moderation repository Record tx.Commit(...): %w", err)
+	}
+
+	return &recorded, nil
+}
+
+// applyAction changes the membership and the sanctions of the target as the action demands.
+// A banned user loses the membership and the pending invitation to the room.
+func applyAction(
+	ctx context.Context,
+	tx pgx.Tx,
+	action models.ModerationAction,
+	roomID int,
+	moderatorID int,
+	targetID int,
+) error {
+	switch action.Action {
+	case models.ActionKick:
+		tag, err := tx.Exec(ctx, `DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, targetID)
+		if err != nil {
+			return fmt.Errorf("moderation repository applyAction kick: %w", err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			return models.ErrNotRoomMember
+		}
+	case models.ActionBan, models.ActionMute:
+		sql := `INSERT INTO room_sanctions (room_id, user_id, kind, moderator_id, reason, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (room_id, user_id, kind) DO UPDATE
+		SET moderator_id = EXCLUDED.moderator_id, reason = EXCLUDED.reason,
+			expires_at = EXCLUDED.expires_at, created_at = now()`
+
+		kind := sanctionKind(action.Action)
+
+		if _, err := tx.Exec(ctx, sql, roomID, targetID, kind, moderatorID, action.Reason, action.ExpiresAt); err != nil {
+			return fmt.Errorf("moderation repository applyAction %s: %w", action.Action, err)
+		}
+
+		if kind != models.SanctionBan {
+			return nil
+		}
+
+		sql = `WITH member AS (
+			DELETE FROM room_members WHERE room_id = $1 AND user_id = $2
+		)
+		DELETE FROM room_invitations WHERE room_id = $1 AND invitee_id = $2`
+
+		if _, err := tx.Exec(ctx, sql, roomID, targetID); err != nil {
+			return fmt.Errorf("moderation repository applyAction ban: %w", err)
+		}
+	case models.ActionUnban, models.ActionUnmute:
+		sql := `DELETE FROM room_sanctions s
+		WHERE s.room_id = $1 AND s.user_id = $2 AND s.kind = $3
+		RETURNING ` + activeSanction
+
+		kind := sanctionKind(action.Action)
+
+		var active bool
+
+		err := tx.QueryRow(ctx, sql, roomID, targetID, kind).Scan(&active)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("moderation repository applyAction %s: %w", action.Action, err)
+		}
+
+		if !active && kind == models.SanctionBan {
+			return models.ErrNotBanned
+		}
+
+		if !active {
+			return models.ErrNotMuted
+		}
+	}
+
+	return nil
+}
+
+// sanctionKind returns the kind of sanction the action imposes or lifts.
+func sanctionKind(action string) string {
+	if action == models.ActionMute || action == models.ActionUnmute {
+		return models.SanctionMute
+	}
+
+	return models.SanctionBan
+}
+
+// ActiveSanction returns the sanction of the kind in force against the user in the room, nil when there is none.
+func (r *ModerationRepository) ActiveSanction(
+	ctx context.Context,
+	roomName string,
+	username string,
+	kind string,
+) (*models.Sanction, error) {
+	sql := `SELECT ` + sanctionColumns + `
+	FROM room_sanctions s
+	JOIN rooms r ON r.room_id = s.room_id
+	JOIN users u ON u.user_id = s.user_id
+	JOIN users m ON m.user_id = s.moderator_id
+	WHERE r.name = $1 AND u.username = $2 AND s.kind = $3 AND ` + activeSanction
+
+	rows, err := r.db.Query(ctx, sql, roomName, username, kind)
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository ActiveSanction: %w", err)
+	}
+
+	sanction, err := pgx.CollectExactlyOneRow(rows, scanSanction)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil //nolint:nilnil // No sanction is not an error.
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository ActiveSanction: %w", err)
+	}
+
+	return &sanction, nil
+}
+
+// ListSanctions returns the sanctions of the kind in force in the room, the latest first.
+func (r *ModerationRepository) ListSanctions(
+	ctx context.Context,
+	roomName string,
+	kind string,
+) ([]models.Sanction, error) {
+	sql := `SELECT ` + sanctionColumns + `
+	FROM room_sanctions s
+	JOIN rooms r ON r.room_id = s.room_id
+	JOIN users u ON u.user_id = s.user_id
+	JOIN users m ON m.user_id = s.moderator_id
+	WHERE r.name = $1 AND s.kind = $2 AND ` + activeSanction + `
+	ORDER BY s.created_at DESC`
+
+	rows, err := r.db.Query(ctx, sql, roomName, kind)
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository ListSanctions: %w", err)
+	}
+
+	sanctions, err := pgx.CollectRows(rows, scanSanction)
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository ListSanctions: %w", err)
+	}
+
+	return sanctions, nil
+}
+
+// ListActions returns up to limit latest entries of the audit trail of the room, the latest first.
+func (r *ModerationRepository) ListActions(
+	ctx context.Context,
+	roomName string,
+	limit int,
+) ([]models.ModerationAction, error) {
+	sql := `SELECT a.action_id, r.name, a.action, m.username, t.username, a.reason, a.expires_at, a.created_at
+	FROM moderation_actions a
+	JOIN rooms r ON r.room_id = a.room_id
+	JOIN users m ON m.user_id = a.moderator_id
+	JOIN users t ON t.user_id = a.target_id
+	WHERE r.name = $1
+	ORDER BY a.action_id DESC
+	LIMIT $2`
+
+	rows, err := r.db.Query(ctx, sql, roomName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository ListActions: %w", err)
+	}
+
+	actions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ModerationAction, error) {
+		var action models.ModerationAction
+
+		err := row.Scan(
+			&action.ID,
+			&action.Room,
+			&action.Action,
+			&action.Moderator,
+			&action.Target,
+			&action.Reason,
+			&action.ExpiresAt,
+			&action.CreatedAt,
+		)
+
+		return action, err //nolint:wrapcheck
+	})
+	if err != nil {
+		return nil, fmt.Errorf("moderation repository ListActions: %w", err)
+	}
+
+	return actions, nil
+}
+
+func scanSanction(row pgx.CollectableRow) (models.Sanction, error) {
+	var sanction models.Sanction
+
+	err := row.Scan(
+		&sanction.Room,
+		&sanction.Username,
+		&sanction.Kind,
+		&sanction.Moderator,
+		&sanction.Reason,
+		&sanction.ExpiresAt,
+		&sanction.CreatedAt,
+	)
+
+	return sanction, err //nolint:wrapcheck
+}
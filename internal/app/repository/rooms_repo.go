@@ -0,0 +1,473 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+const pgUniqueViolation = "23505"
+
+type RoomsRepositoryInterface interface {
+	Create(ctx context.Context, name string, creatorID int, private bool) (*models.Room, error)
+	Ensure(ctx context.Context, name string) error
+	List(ctx context.Context) ([]models.Room, error)
+	GetByName(ctx context.Context, name string) (*models.Room, error)
+	AddMember(ctx context.Context, roomName string, userID int, role string) error
+	RemoveMember(ctx context.Context, roomName string, userID int) error
+	ListUserRooms(ctx context.Context, userID int) ([]string, error)
+	ListMembers(ctx context.Context, roomName string) ([]models.User, error)
+	IsMember(ctx context.Context, roomName string, username string) (bool, error)
+	MemberRole(ctx context.Context, roomName string, username string) (string, error)
+	SetRole(ctx context.Context, roomName string, username string, role string) error
+	CreateInvitation(ctx context.Context, roomName string, inviter string, invitee string) (*models.RoomInvitation, error)
+	AcceptInvitation(ctx context.Context, roomName string, username string) (bool, error)
+	DeleteInvitation(ctx context.Context, roomName string, username string) (bool, error)
+	ListInvitations(ctx context.Context, username string) ([]models.RoomInvitation, error)
+	SetSilenced(ctx context.Context, roomName string, userID int, silenced bool) error
+	ListSilencedRooms(ctx context.Context, userID int) ([]string, error)
+}
+
+type RoomsRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewRoomsRepository(db *pgxpool.Pool) RoomsRepositoryInterface {
+	return &RoomsRepository{db: db}
+}
+
+// Create creates the room and makes its creator the owner, both or neither.
+func (r *RoomsRepository) Create(
+	ctx context.Context,
+	name string,
+	creatorID int,
+	private bool,
+) (*models.Room, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository Create r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	var room models.Room
+
+	sql := `INSERT INTO rooms (name, created_by, private)
+	VALUES ($1, $2, $3)
+	RETURNING room_id, name, private, created_at`
+
+	err = tx.QueryRow(ctx, sql, name, creatorID, private).Scan(
+		&room.ID,
+		&room.Name,
+		&room.Private,
+		&room.CreatedAt,
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			return nil, models.ErrRoomExists
+		}
+
+		return nil, fmt.Errorf("rooms repository Create: %w", err)
+	}
+
+	sql = `INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)`
+
+	if _, err := tx.Exec(ctx, sql, room.ID, creatorID, models.RoleOwner); err != nil {
+		return nil, fmt.Errorf("rooms repository Create add owner: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("rooms repository Create tx.Commit(...): %w", err)
+	}
+
+	return &room, nil
+}
+
+func (r *RoomsRepository) Ensure(ctx context.Context, name string) error {
+	sql := `INSERT INTO rooms (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`
+
+	if _, err := r.db.Exec(ctx, sql, name); err != nil {
+		return fmt.Errorf("rooms repository Ensure: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RoomsRepository) List(ctx context.Context) ([]models.Room, error) {
+	sql := `SELECT room_id, name, private, created_at FROM rooms ORDER BY name`
+
+	rows, err := r.db.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository List: %w", err)
+	}
+	defer rows.Close()
+
+	var rooms []models.Room
+
+	for rows.Next() {
+		var room models.Room
+		if err := rows.Scan(&room.ID, &room.Name, &room.Private, &room.CreatedAt); err != nil {
+			return nil, fmt.Errorf("rooms repository List rows.Scan(...): %w", err)
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rooms repository List rows.Err(): %w", err)
+	}
+
+	return rooms, nil
+}
+
+// GetByName returns the room, it fails with ErrRoomNotExists when there is none.
+func (r *RoomsRepository) GetByName(ctx context.Context, name string) (*models.Room, error) {
+	var room models.Room
+
+	sql := `SELECT room_id, name, private, created_at FROM rooms WHERE name = $1`
+
+	err := r.db.QueryRow(ctx, sql, name).Scan(&room.ID, &room.Name, &room.Private, &room.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, models.ErrRoomNotExists
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository GetByName: %w", err)
+	}
+
+	return &room, nil
+}
+
+// AddMember makes the user a member of the room with the role, a member keeps the role it has.
+func (r *RoomsRepository) AddMember(ctx context.Context, roomName string, userID int, role string) error {
+	roomID, err := r.roomID(ctx, roomName)
+	if err != nil {
+		return err
+	}
+
+	// New members start with the backlog read.
+	sql := `INSERT INTO room_members (room_id, user_id, last_read_message_id, role)
+	VALUES ($1, $2, (SELECT COALESCE(MAX(message_id), 0) FROM messages WHERE room_id = $1), $3)
+	ON CONFLICT (room_id, user_id) DO NOTHING`
+
+	if _, err := r.db.Exec(ctx, sql, roomID, userID, role); err != nil {
+		return fmt.Errorf("rooms repository AddMember: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveMember removes the user from the room. When the owner leaves, the earliest moderator, or else
+// the earliest member, takes the room over so it keeps someone to invite and moderate.
+func (r *RoomsRepository) RemoveMember(ctx context.Context, roomName string, userID int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("rooms repository RemoveMember r.db.Begin(...): %w", err)
+	}
+
+	defer tx.Rollback(ctx) //nolint:errcheck // A no-op after Commit.
+
+	var (
+		roomID int
+		role   string
+	)
+
+	sql := `DELETE FROM room_members
+	WHERE room_id = (SELECT room_id FROM rooms WHERE name = $1)
+	AND user_id = $2
+	RETURNING room_id, role`
+
+	err = tx.QueryRow(ctx, sql, roomName, userID).Scan(&roomID, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrNotRoomMember
+	}
+
+	if err != nil {
+		return fmt.Errorf("rooms repository RemoveMember: %w", err)
+	}
+
+	if role == models.RoleOwner {
+		sql = `UPDATE room_members SET role = $2
+		WHERE room_id = $1 AND user_id = (
+			SELECT user_id FROM room_members WHERE room_id = $1
+			ORDER BY role = $3 DESC, joined_at, user_id
+			LIMIT 1
+		)`
+
+		if _, err := tx.Exec(ctx, sql, roomID, models.RoleOwner, models.RoleModerator); err != nil {
+			return fmt.Errorf("rooms repository RemoveMember transfer ownership: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("rooms repository RemoveMember tx.Commit(...): %w", err)
+	}
+
+	return nil
+}
+
+func (r *RoomsRepository) ListUserRooms(ctx context.Context, userID int) ([]string, error) {
+	sql := `SELECT r.name FROM room_members rm
+	JOIN rooms r ON r.room_id = rm.room_id
+	WHERE rm.user_id = $1
+	ORDER BY r.name`
+
+	rows, err := r.db.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListUserRooms: %w", err)
+	}
+
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListUserRooms pgx.CollectRows(...): %w", err)
+	}
+
+	return names, nil
+}
+
+// ListMembers returns the usernames and last seen times of the room members, ordered by username.
+func (r *RoomsRepository) ListMembers(ctx context.Context, roomName string) ([]models.User, error) {
+	sql := `SELECT u.user_id, u.username, u.last_seen_at FROM room_members rm
+	JOIN rooms r ON r.room_id = rm.room_id
+	JOIN users u ON u.user_id = rm.user_id
+	WHERE r.name = $1 AND NOT u.deleted
+	ORDER BY u.username`
+
+	rows, err := r.db.Query(ctx, sql, roomName)
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListMembers: %w", err)
+	}
+
+	members, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.User, error) {
+		var user models.User
+		err := row.Scan(&user.ID, &user.UserName, &user.LastSeenAt)
+
+		return user, err //nolint:wrapcheck
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListMembers pgx.CollectRows(...): %w", err)
+	}
+
+	return members, nil
+}
+
+// IsMember reports whether the user is a member of the room.
+func (r *RoomsRepository) IsMember(ctx context.Context, roomName string, username string) (bool, error) {
+	sql := `SELECT EXISTS (
+		SELECT 1 FROM room_members rm
+		JOIN rooms r ON r.room_id = rm.room_id
+		JOIN users u ON u.user_id = rm.user_id
+		WHERE r.name = $1 AND u.username = $2
+	)`
+
+	var member bool
+	if err := r.db.QueryRow(ctx, sql, roomName, username).Scan(&member); err != nil {
+		return false, fmt.Errorf("rooms repository IsMember: %w", err)
+	}
+
+	return member, nil
+}
+
+// MemberRole returns the role of the user in the room, it is empty for users who are not members.
+func (r *RoomsRepository) MemberRole(ctx context.Context, roomName string, username string) (string, error) {
+	sql := `SELECT rm.role FROM room_members rm
+	JOIN rooms r ON r.room_id = rm.room_id
+	JOIN users u ON u.user_id = rm.user_id
+	WHERE r.name = $1 AND u.username = $2`
+
+	var role string
+
+	err := r.db.QueryRow(ctx, sql, roomName, username).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("rooms repository MemberRole: %w", err)
+	}
+
+	return role, nil
+}
+
+// SetRole changes the role of the member, it fails with ErrNotRoomMember for others.
+func (r *RoomsRepository) SetRole(ctx context.Context, roomName string, username string, role string) error {
+	sql := `UPDATE room_members rm SET role = $3
+	FROM rooms r, users u
+	WHERE r.room_id = rm.room_id AND u.user_id = rm.user_id
+	AND r.name = $1 AND u.username = $2`
+
+	tag, err := r.db.Exec(ctx, sql, roomName, username, role)
+	if err != nil {
+		return fmt.Errorf("rooms repository SetRole: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotRoomMember
+	}
+
+	return nil
+}
+
+// CreateInvitation invites the user to the room, inviting again renews the invitation.
+// It fails with ErrUserNotFound when the invitee is not registered.
+func (r *RoomsRepository) CreateInvitation(
+	ctx context.Context,
+	roomName string,
+	inviter string,
+	invitee string,
+) (*models.RoomInvitation, error) {
+	sql := `INSERT INTO room_invitations (room_id, invitee_id, inviter_id)
+	SELECT r.room_id, iu.user_id, ru.user_id
+	FROM rooms r, users iu, users ru
+	WHERE r.name = $1 AND ru.username = $2 AND iu.username = $3 AND NOT iu.deleted
+	ON CONFLICT (room_id, invitee_id) DO UPDATE SET inviter_id = EXCLUDED.inviter_id, created_at = now()
+	RETURNING created_at, (SELECT private FROM rooms WHERE name = $1)`
+
+	invitation := models.RoomInvitation{Room: roomName, Inviter: inviter, Invitee: invitee}
+
+	err := r.db.QueryRow(ctx, sql, roomName, inviter, invitee).Scan(&invitation.CreatedAt, &invitation.Private)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, models.ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository CreateInvitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// AcceptInvitation turns the user's invitation to the room into a membership and reports
+// whether there was an invitation. New members start with the backlog read.
+func (r *RoomsRepository) AcceptInvitation(ctx context.Context, roomName string, username string) (bool, error) {
+	sql := `WITH invitation AS (
+		DELETE FROM room_invitations ri
+		USING rooms r, users u
+		WHERE r.room_id = ri.room_id AND u.user_id = ri.invitee_id
+		AND r.name = $1 AND u.username = $2
+		RETURNING ri.room_id, ri.invitee_id
+	), member AS (
+		INSERT INTO room_members (room_id, user_id, last_read_message_id, role)
+		SELECT i.room_id, i.invitee_id,
+			(SELECT COALESCE(MAX(m.message_id), 0) FROM messages m WHERE m.room_id = i.room_id), 'member'
+		FROM invitation i
+		ON CONFLICT (room_id, user_id) DO NOTHING
+	)
+	SELECT EXISTS (SELECT 1 FROM invitation)`
+
+	var accepted bool
+	if err := r.db.QueryRow(ctx, sql, roomName, username).Scan(&accepted); err != nil {
+		return false, fmt.Errorf("rooms repository AcceptInvitation: %w", err)
+	}
+
+	return accepted, nil
+}
+
+// DeleteInvitation drops the user's invitation to the room and reports whether there was one.
+func (r *RoomsRepository) DeleteInvitation(ctx context.Context, roomName string, username string) (bool, error) {
+	sql := `DELETE FROM room_invitations ri
+	USING rooms r, users u
+	WHERE r.room_id = ri.room_id AND u.user_id = ri.invitee_id
+	AND r.name = $1 AND u.username = $2`
+
+	tag, err := r.db.Exec(ctx, sql, roomName, username)
+	if err != nil {
+		return false, fmt.Errorf("rooms repository DeleteInvitation: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListInvitations returns the pending invitations of the user, the oldest first.
+func (r *RoomsRepository) ListInvitations(ctx context.Context, username string) ([]models.RoomInvitation, error) {
+	sql := `SELECT r.name, r.private, ru.username, iu.username, ri.created_at
+	FROM room_invitations ri
+	JOIN rooms r ON r.room_id = ri.room_id
+	JOIN users iu ON iu.user_id = ri.invitee_id
+	JOIN users ru ON ru.user_id = ri.inviter_id
+	WHERE iu.username = $1
+	ORDER BY ri.created_at, r.name`
+
+	rows, err := r.db.Query(ctx, sql, username)
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListInvitations: %w", err)
+	}
+
+	invitations, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.RoomInvitation, error) {
+		var invitation models.RoomInvitation
+		err := row.Scan(
+			&invitation.Room,
+			&invitation.Private,
+			&invitation.Inviter,
+			&invitation.Invitee,
+			&invitation.CreatedAt,
+		)
+
+		return invitation, err //nolint:wrapcheck
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListInvitations pgx.CollectRows(...): %w", err)
+	}
+
+	return invitations, nil
+}
+
+// SetSilenced silences or unsilences the room for the member, it fails with ErrNotRoomMember for others.
+func (r *RoomsRepository) SetSilenced(ctx context.Context, roomName string, userID int, silenced bool) error {
+	sql := `UPDATE room_members SET silenced = $3
+	WHERE room_id = (SELECT room_id FROM rooms WHERE name = $1)
+	AND user_id = $2`
+
+	tag, err := r.db.Exec(ctx, sql, roomName, userID, silenced)
+	if err != nil {
+		return fmt.Errorf("rooms repository SetSilenced: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotRoomMember
+	}
+
+	return nil
+}
+
+// ListSilencedRooms returns the rooms the user silenced, ordered by name.
+func (r *RoomsRepository) ListSilencedRooms(ctx context.Context, userID int) ([]string, error) {
+	sql := `SELECT r.name FROM room_members rm
+	JOIN rooms r ON r.room_id = rm.room_id
+	WHERE rm.user_id = $1 AND rm.silenced
+	ORDER BY r.name`
+
+	rows, err := r.db.Query(ctx, sql, userID)
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListSilencedRooms: %w", err)
+	}
+
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("rooms repository ListSilencedRooms pgx.CollectRows(...): %w", err)
+	}
+
+	return names, nil
+}
+
+func (r *RoomsRepository) roomID(ctx context.Context, roomName string) (int, error) {
+	var roomID int
+
+	sql := `SELECT room_id FROM rooms WHERE name = $1`
+
+	if err := r.db.QueryRow(ctx, sql, roomName).Scan(&roomID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, models.ErrRoomNotExists
+		}
+
+		return 0, fmt.Errorf("rooms repository roomID: %w", err)
+	}
+
+	return roomID, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,6 +14,7 @@ import (
 type UsersRepositoryInterface interface {
 	Create(ctx context.Context, user models.User) (*models.User, error)
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	UpdateLastSeen(ctx context.Context, username string, lastSeenAt time.Time) error
 }
 
 type UsersRepository struct {
@@ -81,3 +83,13 @@ func (r *UsersRepository) GetUserByUsername(
 
 	return &user, nil
 }
+
+func (r *UsersRepository) UpdateLastSeen(ctx context.Context, username string, lastSeenAt time.Time) error {
+	sql := `UPDATE users SET last_seen_at = $2 WHERE username = $1`
+
+	if _, err := r.db.Exec(ctx, sql, username, lastSeenAt); err != nil {
+		return fmt.Errorf("users repository UpdateLastSeen: %w", err)
+	}
+
+	return nil
+}
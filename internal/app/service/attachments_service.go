@@ -0,0 +1,186 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/stsolovey/kvant_chat/internal/app/repository"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/storage/blob"
+)
+
+const (
+	attachmentIDBytes       = 16
+	maxAttachmentNameLength = 255
+	defaultAttachmentName   = "attachment"
+	sniffLength             = 512
+)
+
+type AttachmentsServiceInterface interface {
+	Upload(ctx context.Context, uploader string, name string, content io.Reader) (*models.Attachment, error)
+	Download(ctx context.Context, username string, attachmentID string) (*models.Attachment, io.ReadCloser, error)
+}
+
+type AttachmentsService struct {
+	repo         repository.AttachmentsRepositoryInterface
+	messages     MessagesServiceInterface
+	store        blob.Store
+	maxSize      int64
+	allowedTypes []string
+}
+
+func NewAttachmentsService(
+	repo repository.AttachmentsRepositoryInterface,
+	messages MessagesServiceInterface,
+	store blob.Store,
+	maxSize int64,
+	allowedTypes []string,
+) AttachmentsServiceInterface {
+	return &AttachmentsService{
+		repo:         repo,
+		messages:     messages,
+		store:        store,
+		maxSize:      maxSize,
+		allowedTypes: allowedTypes,
+	}
+}
+
+// Upload stores the file and records it as an attachment of the uploader, ready to be sent with a message.
+// The MIME type is detected from the content, not taken from the client.
+func (s *AttachmentsService) Upload(
+	ctx context.Context,
+	uploader string,
+	name string,
+	content io.Reader,
+) (*models.Attachment, error) {
+	reader := bufio.NewReaderSize(io.LimitReader(content, s.maxSize+1), sniffLength)
+
+	head, err := reader.Peek(sniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("attachments service Upload(...) reader.Peek(...): %w", err)
+	}
+
+	if len(head) == 0 {
+		return nil, models.ErrEmptyAttachment
+	}
+
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return nil, fmt.Errorf("attachments service Upload(...) mime.ParseMediaType(...): %w", err)
+	}
+
+	if !slices.Contains(s.allowedTypes, mimeType) {
+		return nil, models.ErrAttachmentTypeNotAllowed
+	}
+
+	id, err := newAttachmentID()
+	if err != nil {
+		return nil, fmt.Errorf("attachments service Upload(...): %w", err)
+	}
+
+	size, err := s.store.Put(ctx, id, reader)
+	if err != nil {
+		return nil, fmt.Errorf("attachments service Upload(...) store.Put(...): %w", err)
+	}
+
+	if size > s.maxSize {
+		s.deleteBlob(ctx, id)
+
+		return nil, models.ErrAttachmentTooLarge
+	}
+
+	attachment, err := s.repo.Create(ctx, models.Attachment{
+		ID:       id,
+		Name:     attachmentName(name),
+		Size:     size,
+		MIMEType: mimeType,
+		Uploader: uploader,
+	})
+	if err != nil {
+		s.deleteBlob(ctx, id)
+
+		return nil, fmt.Errorf("attachments service Upload(...) repo.Create(...): %w", err)
+	}
+
+	return attachment, nil
+}
+
+// Download opens an attachment the user may see: its uploader until it is sent,
+// then whoever may read the message it was sent with. The caller closes the content.
+func (s *AttachmentsService) Download(
+	ctx context.Context,
+	username string,
+	attachmentID string,
+) (*models.Attachment, io.ReadCloser, error) {
+	attachment, err := s.repo.GetByID(ctx, attachmentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("attachments service Download(...) repo.GetByID(...): %w", err)
+	}
+
+	switch {
+	case attachment.MessageID != 0:
+		if _, err := s.messages.Message(ctx, username, attachment.MessageID); err != nil {
+			return nil, nil, fmt.Errorf("attachments service Download(...): %w", err)
+		}
+	case attachment.Uploader != username:
+		return nil, nil, models.ErrAttachmentNotFound
+	}
+
+	content, err := s.store.Open(ctx, attachment.ID)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, nil, fmt.Errorf("attachments service Download(...): %w", models.ErrAttachmentNotFound)
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("attachments service Download(...) store.Open(...): %w", err)
+	}
+
+	return attachment, content, nil
+}
+
+// deleteBlob drops the content of an upload that failed, a leftover blob only wastes space.
+func (s *AttachmentsService) deleteBlob(ctx context.Context, id string) {
+	s.store.Delete(ctx, id) //nolint:errcheck,gosec
+}
+
+func newAttachmentID() (string, error) {
+	id := make([]byte, attachmentIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("newAttachmentID rand.Read(...): %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// attachmentName keeps the base name of the uploaded file without control characters.
+func attachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+
+		return r
+	}, name))
+
+	if runes := []rune(name); len(runes) > maxAttachmentNameLength {
+		name = string(runes[:maxAttachmentNameLength])
+	}
+
+	if name == "" || name == "." || name == "/" {
+		return defaultAttachmentName
+	}
+
+	return name
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/storage/blob"
+)
+
+type MockAttachmentsRepo struct {
+	mock.Mock
+}
+
+func (m *MockAttachmentsRepo) Create(ctx context.Context, attachment models.Attachment) (*models.Attachment, error) {
+	args := m.Called(ctx, attachment)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Attachment), args.Error(1)
+}
+
+func (m *MockAttachmentsRepo) GetByID(ctx context.Context, attachmentID string) (*models.Attachment, error) {
+	args := m.Called(ctx, attachmentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Attachment), args.Error(1)
+}
+
+const testMaxAttachmentSize = 1024
+
+func setupAttachmentsService(t *testing.T) (AttachmentsServiceInterface, *MockAttachmentsRepo, *MockMessagesRepo, *MockRoomsRepo, blob.Store) {
+	store, err := blob.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	messagesService, mockMessagesRepo, mockRoomsRepo := setupMessagesServiceWithRooms()
+	mockRepo := new(MockAttachmentsRepo)
+	allowed := []string{"text/plain", "image/png"}
+
+	return NewAttachmentsService(mockRepo, messagesService, store, testMaxAttachmentSize, allowed),
+		mockRepo, mockMessagesRepo, mockRoomsRepo, store
+}
+
+func TestUploadAttachment(t *testing.T) {
+	attachmentsService, mockRepo, _, _, store := setupAttachmentsService(t)
+	ctx := context.Background()
+
+	var recorded models.Attachment
+
+	mockRepo.On("Create", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		recorded = args.Get(1).(models.Attachment)
+	}).Return(&models.Attachment{ID: "f1"}, nil).Once()
+
+	_, err := attachmentsService.Upload(ctx, "testuser", `C:\docs\notes.txt`, strings.NewReader("meeting notes"))
+	require.NoError(t, err, "uploading an allowed file should succeed")
+	assert.Equal(t, "notes.txt", recorded.Name, "only the base name of the file should be kept")
+	assert.Equal(t, "text/plain", recorded.MIMEType, "the type should be detected from the content")
+	assert.Equal(t, int64(len("meeting notes")), recorded.Size, "the stored size should be recorded")
+	assert.Len(t, recorded.ID, 2*attachmentIDBytes, "the ID should be generated")
+
+	content, err := store.Open(ctx, recorded.ID)
+	require.NoError(t, err, "the content should be stored under the ID")
+	require.NoError(t, content.Close())
+
+	_, err = attachmentsService.Upload(ctx, "testuser", "app.exe", bytes.NewReader([]byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00")))
+	assert.ErrorIs(t, err, models.ErrAttachmentTypeNotAllowed, "types not allowed should be rejected")
+
+	_, err = attachmentsService.Upload(ctx, "testuser", "big.txt", strings.NewReader(strings.Repeat("a", testMaxAttachmentSize+1)))
+	assert.ErrorIs(t, err, models.ErrAttachmentTooLarge, "files over the limit should be rejected")
+
+	_, err = attachmentsService.Upload(ctx, "testuser", "empty.txt", strings.NewReader(""))
+	assert.ErrorIs(t, err, models.ErrEmptyAttachment, "empty files should be rejected")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDownloadAttachment(t *testing.T) {
+	attachmentsService, mockRepo, mockMessagesRepo, mockRoomsRepo, store := setupAttachmentsService(t)
+	ctx := context.Background()
+
+	_, err := store.Put(ctx, "f1", strings.NewReader("meeting notes"))
+	require.NoError(t, err)
+
+	pending := &models.Attachment{ID: "f1", Uploader: "testuser"}
+	mockRepo.On("GetByID", ctx, "f1").Return(pending, nil).Twice()
+
+	_, content, err := attachmentsService.Download(ctx, "testuser", "f1")
+	require.NoError(t, err, "the uploader should download an attachment not sent yet")
+
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	require.NoError(t, content.Close())
+	assert.Equal(t, "meeting notes", string(data), "the stored content should be returned")
+
+	_, _, err = attachmentsService.Download(ctx, "stranger", "f1")
+	assert.ErrorIs(t, err, models.ErrAttachmentNotFound, "an attachment not sent yet should be hidden from others")
+
+	sent := &models.Attachment{ID: "f1", Uploader: "testuser", MessageID: 7}
+	mockRepo.On("GetByID", ctx, "f2").Return(sent, nil).Twice()
+	mockMessagesRepo.On("GetByID", ctx, 7).Return(&models.Message{ID: 7, Room: "general", Sender: "testuser"}, nil).Twice()
+	mockMessagesRepo.On("ListReactions", ctx, []int{7}).Return(map[int][]models.ReactionCount{}, nil).Once()
+	mockMessagesRepo.On("ListAttachments", ctx, []int{7}).Return(map[int][]models.Attachment{}, nil).Once()
+	mockRoomsRepo.On("IsMember", ctx, "general", "member").Return(true, nil).Once()
+	mockRoomsRepo.On("IsMember", ctx, "general", "outsider").Return(false, nil).Once()
+
+	_, content, err = attachmentsService.Download(ctx, "member", "f2")
+	require.NoError(t, err, "room members should download attachments sent to the room")
+	require.NoError(t, content.Close())
+
+	_, _, err = attachmentsService.Download(ctx, "outsider", "f2")
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "others should not download attachments sent to the room")
+
+	mockRepo.AssertExpectations(t)
+	mockMessagesRepo.AssertExpectations(t)
+	mockRoomsRepo.AssertExpectations(t)
+}
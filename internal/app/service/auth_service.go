@@ -2,92 +2,252 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/stsolovey/kvant_chat/internal/app/repository"
+	"github.com/stsolovey/kvant_chat/internal/jwtkeys"
 	"github.com/stsolovey/kvant_chat/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthServiceInterface interface {
-	LoginUser(ctx context.Context, input models.UserLoginInput) (string, error)
-	GenerateToken(username string) (string, error)
-	ValidateToken(tokenString string) (*jwt.Token, error)
+	LoginUser(ctx context.Context, input models.UserLoginInput) (*models.TokenPair, error)
+	GenerateToken(ctx context.Context, username string) (*models.TokenPair, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*models.TokenPair, error)
+	Logout(ctx context.Context, username string, jti string, allSessions bool) error
+	ValidateToken(ctx context.Context, tokenString string) (*jwt.Token, error)
+	CheckSession(ctx context.Context, username string, jti string) error
 	VerifyPassword(storedHash, providedPassword string) bool
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	JWKS() models.JWKS
 }
 
+const (
+	tokenIDByteCount      = 16
+	refreshTokenByteCount = 32
+)
+
 type AuthService struct {
-	repo       repository.AuthRepositoryInterface
-	signingKey []byte
+	repo            repository.AuthRepositoryInterface
+	keys            *jwtkeys.Keyring
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
 }
 
-func NewAuthService(repo repository.AuthRepositoryInterface, signingKey []byte) *AuthService {
+func NewAuthService(
+	repo repository.AuthRepositoryInterface,
+	keys *jwtkeys.Keyring,
+	accessTokenTTL time.Duration,
+	refreshTokenTTL time.Duration,
+) *AuthService {
 	return &AuthService{
-		repo:       repo,
-		signingKey: signingKey,
+		repo:            repo,
+		keys:            keys,
+		accessTokenTTL:  accessTokenTTL,
+		refreshTokenTTL: refreshTokenTTL,
 	}
 }
 
-func (s *AuthService) LoginUser(ctx context.Context, input models.UserLoginInput) (string, error) {
+func (s *AuthService) LoginUser(ctx context.Context, input models.UserLoginInput) (*models.TokenPair, error) {
 	if input.UserName == "" || input.Password == "" {
-		return "", models.ErrCredentialsRequired
+		return nil, models.ErrCredentialsRequired
 	}
 
 	user, err := s.repo.GetUserByUsername(ctx, input.UserName)
 	if err != nil {
-		return "", fmt.Errorf("invalid credentials: %w, %w", err, models.ErrInvalidCredentials)
+		return nil, fmt.Errorf("invalid credentials: %w, %w", err, models.ErrInvalidCredentials)
 	}
 
 	if !s.VerifyPassword(user.HashPassword, input.Password) {
-		return "", models.ErrInvalidCredentials
+		return nil, models.ErrInvalidCredentials
 	}
 
-	token, err := s.GenerateToken(input.UserName)
+	tokens, err := s.GenerateToken(ctx, input.UserName)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate token: %w", err)
+		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	return token, nil
+	return tokens, nil
 }
 
-func (s *AuthService) GenerateToken(username string) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// GenerateToken starts a session of the user: a short-lived access token and the refresh token renewing it.
+func (s *AuthService) GenerateToken(ctx context.Context, username string) (*models.TokenPair, error) {
+	refreshToken, refresh, err := s.newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", models.ErrTokenGenerationError
+	if err := s.repo.CreateRefreshToken(ctx, username, *refresh); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
 	}
 
-	const tokenDuration = 24 * time.Hour
+	return s.tokenPair(username, refreshToken, refresh)
+}
 
-	claims["username"] = username
-	claims["exp"] = time.Now().Add(tokenDuration).Unix()
+// RefreshToken exchanges a refresh token for a new pair, the refresh token cannot be used again.
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	if refreshToken == "" {
+		return nil, models.ErrInvalidRefreshToken
+	}
 
-	tokenString, err := token.SignedString(s.signingKey)
+	nextToken, next, err := s.newRefreshToken()
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		return nil, err
 	}
 
-	return tokenString, nil
+	username, err := s.repo.RotateRefreshToken(ctx, hashRefreshToken(refreshToken), *next)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return s.tokenPair(username, nextToken, next)
 }
 
-func (s *AuthService) ValidateToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, models.ErrTokenValidationError
+// Logout revokes the access token with the given ID and its refresh token,
+// or every session of the user with allSessions.
+func (s *AuthService) Logout(ctx context.Context, username string, jti string, allSessions bool) error {
+	if allSessions {
+		if err := s.repo.RevokeUserSessions(ctx, username); err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
 		}
+	}
+
+	// The token expires at the latest accessTokenTTL from now, it is refused until then.
+	if err := s.repo.RevokeSession(ctx, jti, time.Now().Add(s.accessTokenTTL)); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return nil
+}
+
+// ValidateToken parses an access token, refusing expired and revoked ones.
+func (s *AuthService) ValidateToken(ctx context.Context, tokenString string) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenString, s.keys.Keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("validation error: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("validation error: invalid token: %w", models.ErrTokenValidationError)
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return nil, fmt.Errorf("validation error: no token ID: %w", models.ErrTokenValidationError)
+	}
+
+	revoked, err := s.repo.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return nil, fmt.Errorf("validation error: %w", err)
+	}
+
+	if revoked {
+		return nil, models.ErrTokenRevoked
+	}
+
+	return token, nil
+}
 
-		return s.signingKey, nil
+// CheckSession tells whether a long-lived session authenticated with the access token jti may go on.
+// It fails with ErrTokenRevoked once the token is revoked and with ErrUserNotFound once the user is deleted.
+func (s *AuthService) CheckSession(ctx context.Context, username string, jti string) error {
+	revoked, err := s.repo.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return fmt.Errorf("check session: %w", err)
+	}
+
+	if revoked {
+		return models.ErrTokenRevoked
+	}
+
+	if _, err := s.repo.GetUserByUsername(ctx, username); err != nil {
+		return fmt.Errorf("check session: %w", err)
+	}
+
+	return nil
+}
+
+// newRefreshToken draws a refresh token and the ID and expiry of the access token issued with it.
+func (s *AuthService) newRefreshToken() (string, *models.RefreshToken, error) {
+	jti, err := randomToken(tokenIDByteCount)
+	if err != nil {
+		return "", nil, err
+	}
+
+	refreshToken, err := randomToken(refreshTokenByteCount)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+
+	return refreshToken, &models.RefreshToken{
+		Hash:            hashRefreshToken(refreshToken),
+		AccessJTI:       jti,
+		AccessExpiresAt: now.Add(s.accessTokenTTL),
+		ExpiresAt:       now.Add(s.refreshTokenTTL),
+	}, nil
+}
+
+// tokenPair signs the access token of the stored refresh token.
+func (s *AuthService) tokenPair(
+	username string,
+	refreshToken string,
+	refresh *models.RefreshToken,
+) (*models.TokenPair, error) {
+	accessToken, err := s.signAccessToken(username, refresh.AccessJTI, refresh.AccessExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    refresh.AccessExpiresAt,
+	}, nil
+}
+
+func (s *AuthService) signAccessToken(username string, jti string, expiresAt time.Time) (string, error) {
+	tokenString, err := s.keys.Sign(jwt.MapClaims{
+		"username": username,
+		"jti":      jti,
+		"iat":      time.Now().Unix(),
+		"exp":      expiresAt.Unix(),
 	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
 
-	if _, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return token, nil
+	return tokenString, nil
+}
+
+// JWKS returns the public keys other services verify access tokens with.
+func (s *AuthService) JWKS() models.JWKS {
+	return s.keys.JWKS()
+}
+
+// randomToken returns n random bytes encoded for use in URLs and JSON.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("randomToken rand.Read(...): %w", err)
 	}
 
-	return nil, fmt.Errorf("validation error: %w", err)
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken is how refresh tokens are stored, a leaked table does not reveal usable tokens.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
 }
 
 func (s *AuthService) VerifyPassword(storedHash, providedPassword string) bool {
@@ -8,6 +8,8 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/jwtkeys"
 	"github.com/stsolovey/kvant_chat/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -21,21 +23,53 @@ func (m *MockAuthRepo) GetUserByUsername(ctx context.Context, username string) (
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
+func (m *MockAuthRepo) CreateRefreshToken(ctx context.Context, username string, token models.RefreshToken) error {
+	return m.Called(ctx, username, token).Error(0)
+}
+
+func (m *MockAuthRepo) RotateRefreshToken(ctx context.Context, hash string, next models.RefreshToken) (string, error) {
+	args := m.Called(ctx, hash, next)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockAuthRepo) RevokeSession(ctx context.Context, jti string, expiresAt time.Time) error {
+	return m.Called(ctx, jti, expiresAt).Error(0)
+}
+
+func (m *MockAuthRepo) RevokeUserSessions(ctx context.Context, username string) error {
+	return m.Called(ctx, username).Error(0)
+}
+
+func (m *MockAuthRepo) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	args := m.Called(ctx, jti)
+	return args.Bool(0), args.Error(1)
+}
+
 func setupAuthService() (*AuthService, *MockAuthRepo) {
 	mockRepo := new(MockAuthRepo)
 	signingKey := []byte("your-256-bit-secret")
-	authService := NewAuthService(mockRepo, signingKey)
+	authService := NewAuthService(mockRepo, jwtkeys.NewSecret(signingKey), 15*time.Minute, 24*time.Hour)
 	return authService, mockRepo
 }
 
 func TestGenerateToken(t *testing.T) {
-	authService, _ := setupAuthService()
+	authService, mockRepo := setupAuthService()
+	ctx := context.Background()
 	username := "testuser"
 
-	token, err := authService.GenerateToken(username)
+	var stored models.RefreshToken
+
+	mockRepo.On("CreateRefreshToken", ctx, username, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(2).(models.RefreshToken)
+	}).Return(nil).Once()
+
+	tokens, err := authService.GenerateToken(ctx, username)
 	assert.Nil(t, err, "should not error out when generating a token")
+	assert.NotEmpty(t, tokens.RefreshToken, "a refresh token should be issued")
+	assert.Equal(t, hashRefreshToken(tokens.RefreshToken), stored.Hash, "only the hash of the refresh token should be stored")
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), tokens.ExpiresAt, time.Minute, "access token should be short-lived")
 
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+	parsedToken, err := jwt.Parse(tokens.Token, func(token *jwt.Token) (interface{}, error) {
 		return []byte("your-256-bit-secret"), nil
 	})
 	assert.Nil(t, err, "should be able to parse the token")
@@ -45,16 +79,106 @@ func TestGenerateToken(t *testing.T) {
 	assert.True(t, ok, "claims should be of type jwt.MapClaims")
 	assert.Equal(t, username, claims["username"], "username should match")
 	assert.True(t, claims["exp"].(float64) > float64(time.Now().Unix()), "token expiry should be in the future")
+	assert.Equal(t, stored.AccessJTI, claims["jti"], "the refresh token should be stored with the ID of its access token")
 }
 
 func TestValidateToken(t *testing.T) {
-	authService, _ := setupAuthService()
+	authService, mockRepo := setupAuthService()
+	ctx := context.Background()
 	username := "testuser"
-	token, _ := authService.GenerateToken(username)
 
-	validatedToken, err := authService.ValidateToken(token)
+	mockRepo.On("CreateRefreshToken", ctx, username, mock.Anything).Return(nil)
+
+	tokens, _ := authService.GenerateToken(ctx, username)
+	revokedTokens, _ := authService.GenerateToken(ctx, username)
+
+	claims := jwt.MapClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(revokedTokens.Token, claims)
+	require.NoError(t, err)
+
+	mockRepo.On("IsTokenRevoked", ctx, claims["jti"]).Return(true, nil).Once()
+	mockRepo.On("IsTokenRevoked", ctx, mock.Anything).Return(false, nil)
+
+	validatedToken, err := authService.ValidateToken(ctx, tokens.Token)
 	assert.Nil(t, err, "token validation should succeed")
 	assert.NotNil(t, validatedToken, "validated token should not be nil")
+
+	_, err = authService.ValidateToken(ctx, revokedTokens.Token)
+	assert.ErrorIs(t, err, models.ErrTokenRevoked, "a revoked token should be refused")
+
+	noID := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": username,
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	})
+	noIDToken, err := noID.SignedString([]byte("your-256-bit-secret"))
+	require.NoError(t, err)
+
+	_, err = authService.ValidateToken(ctx, noIDToken)
+	assert.ErrorIs(t, err, models.ErrTokenValidationError, "a token without ID cannot be revoked and should be refused")
+}
+
+func TestRefreshToken(t *testing.T) {
+	authService, mockRepo := setupAuthService()
+	ctx := context.Background()
+
+	var next models.RefreshToken
+
+	mockRepo.On("RotateRefreshToken", ctx, hashRefreshToken("old-refresh-token"), mock.Anything).
+		Run(func(args mock.Arguments) {
+			next = args.Get(2).(models.RefreshToken)
+		}).Return("testuser", nil).Once()
+	mockRepo.On("RotateRefreshToken", ctx, hashRefreshToken("used-refresh-token"), mock.Anything).
+		Return("", models.ErrInvalidRefreshToken).Once()
+
+	tokens, err := authService.RefreshToken(ctx, "old-refresh-token")
+	require.NoError(t, err, "a valid refresh token should be exchanged")
+	assert.NotEqual(t, "old-refresh-token", tokens.RefreshToken, "the refresh token should rotate")
+	assert.Equal(t, hashRefreshToken(tokens.RefreshToken), next.Hash, "the new refresh token should be stored")
+
+	claims := jwt.MapClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(tokens.Token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", claims["username"], "the access token should belong to the owner of the refresh token")
+	assert.Equal(t, next.AccessJTI, claims["jti"])
+
+	_, err = authService.RefreshToken(ctx, "used-refresh-token")
+	assert.ErrorIs(t, err, models.ErrInvalidRefreshToken, "a used refresh token should be refused")
+
+	_, err = authService.RefreshToken(ctx, "")
+	assert.ErrorIs(t, err, models.ErrInvalidRefreshToken, "an empty refresh token should be refused")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLogout(t *testing.T) {
+	authService, mockRepo := setupAuthService()
+	ctx := context.Background()
+
+	mockRepo.On("RevokeSession", ctx, "jti-1", mock.AnythingOfType("time.Time")).Return(nil).Twice()
+	mockRepo.On("RevokeUserSessions", ctx, "testuser").Return(nil).Once()
+
+	assert.NoError(t, authService.Logout(ctx, "testuser", "jti-1", false), "logout should revoke the session")
+	mockRepo.AssertNotCalled(t, "RevokeUserSessions", ctx, "testuser")
+
+	assert.NoError(t, authService.Logout(ctx, "testuser", "jti-1", true), "logout should revoke every session")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCheckSession(t *testing.T) {
+	authService, mockRepo := setupAuthService()
+	ctx := context.Background()
+
+	mockRepo.On("IsTokenRevoked", ctx, "live").Return(false, nil)
+	mockRepo.On("IsTokenRevoked", ctx, "revoked").Return(true, nil)
+	mockRepo.On("GetUserByUsername", ctx, "testuser").Return(&models.User{UserName: "testuser"}, nil)
+	mockRepo.On("GetUserByUsername", ctx, "deleted").Return((*models.User)(nil), models.ErrUserNotFound)
+
+	assert.NoError(t, authService.CheckSession(ctx, "testuser", "live"), "a live session should go on")
+	assert.ErrorIs(t, authService.CheckSession(ctx, "testuser", "revoked"), models.ErrTokenRevoked,
+		"a session with a revoked token should end")
+	assert.ErrorIs(t, authService.CheckSession(ctx, "deleted", "live"), models.ErrUserNotFound,
+		"a session of a deleted user should end")
 }
 
 func TestVerifyPassword(t *testing.T) {
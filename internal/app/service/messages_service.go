@@ -0,0 +1,766 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/stsolovey/kvant_chat/internal/app/repository"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+const (
+	maxMessageLength  = 4096
+	maxClientIDLength = 64
+	maxEmojiLength    = 32
+	previewLength     = 80
+	maxAttachments    = 10
+
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 100
+
+	// MentionHere mentions the room members who are online, MentionRoom all of them.
+	MentionHere = "here"
+	MentionRoom = "room"
+)
+
+type MessagesServiceInterface interface {
+	SaveMessage(ctx context.Context, msg models.Message) (*models.Message, bool, error)
+	RoomHistory(
+		ctx context.Context,
+		username string,
+		roomName string,
+		req models.HistoryRequest,
+	) (*models.HistoryPage, error)
+	DirectHistory(
+		ctx context.Context,
+		username string,
+		peer string,
+		req models.HistoryRequest,
+	) (*models.HistoryPage, error)
+	UndeliveredDirectMessages(ctx context.Context, username string) ([]models.Message, error)
+	MarkDelivered(ctx context.Context, messageIDs ...int) error
+	MarkRoomRead(ctx context.Context, roomName string, username string, messageID int) (int, error)
+	MarkDirectRead(ctx context.Context, username string, peer string, messageID int) (*models.ReadReceipt, error)
+	UnreadCounts(ctx context.Context, username string) ([]models.UnreadCount, error)
+	EditMessage(ctx context.Context, editor string, messageID int, content string) (*models.Message, error)
+	DeleteMessage(ctx context.Context, username string, messageID int) (*models.Message, error)
+	React(ctx context.Context, reaction models.Reaction) (*models.Message, bool, error)
+	Thread(ctx context.Context, username string, messageID int, req models.HistoryRequest) (*models.Thread, error)
+	FollowThread(ctx context.Context, username string, messageID int, follow bool) (int, error)
+	ThreadSubscribers(ctx context.Context, threadID int) ([]string, error)
+	RecordMentions(ctx context.Context, msg models.Message, online []string) ([]string, error)
+	Mentions(ctx context.Context, username string, req models.HistoryRequest) (*models.HistoryPage, error)
+	Message(ctx context.Context, username string, messageID int) (*models.Message, error)
+}
+
+type MessagesService struct {
+	repo       repository.MessagesRepositoryInterface
+	roomsRepo  repository.RoomsRepositoryInterface
+	moderation ModerationServiceInterface
+}
+
+func NewMessagesService(
+	repo repository.MessagesRepositoryInterface,
+	roomsRepo repository.RoomsRepositoryInterface,
+	moderation ModerationServiceInterface,
+) MessagesServiceInterface {
+	return &MessagesService{
+		repo:       repo,
+		roomsRepo:  roomsRepo,
+		moderation: moderation,
+	}
+}
+
+// SaveMessage persists a room or direct message; the stored copy carries the server-assigned ID and timestamp.
+// A retry carrying the ClientID of a message the sender already stored returns that message and false,
+// so it is acknowledged again but not delivered twice.
+// A reply joins the thread of the message it answers and subscribes its sender to the thread,
+// the first reply subscribes the author of the thread's first message too.
+// Attachments are referenced by the IDs of files the sender uploaded and not sent yet.
+func (s *MessagesService) SaveMessage(ctx context.Context, msg models.Message) (*models.Message, bool, error) {
+	attachments, err := attachmentRefs(msg.Attachments)
+	if err != nil {
+		return nil, false, err
+	}
+
+	msg.Attachments = attachments
+
+	switch err := validateContent(msg.Content); {
+	case errors.Is(err, models.ErrEmptyMessage) && len(attachments) > 0:
+		// Files may be sent without a text.
+	case err != nil:
+		return nil, false, err
+	}
+
+	if len(msg.ClientID) > maxClientIDLength {
+		return nil, false, models.ErrInvalidClientID
+	}
+
+	msg.ThreadID = 0
+	msg.Parent = nil
+
+	var parent *models.Message
+
+	if msg.ReplyTo != 0 {
+		parent, err = s.replyParent(ctx, msg)
+		if err != nil {
+			return nil, false, fmt.Errorf("messages service SaveMessage(...): %w", err)
+		}
+
+		msg.ThreadID = parent.ID
+		if parent.ThreadID != 0 {
+			msg.ThreadID = parent.ThreadID
+		}
+	}
+
+	saved, err := s.repo.Create(ctx, msg)
+	if errors.Is(err, models.ErrDuplicateMessage) {
+		stored, err := s.repo.GetByClientID(ctx, msg.Sender, msg.ClientID)
+		if err != nil {
+			return nil, false, fmt.Errorf("messages service SaveMessage(...) repo.GetByClientID(...): %w", err)
+		}
+
+		return stored, false, nil
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("messages service SaveMessage(...) repo.Create(...): %w", err)
+	}
+
+	if parent != nil {
+		saved.Parent = previewOf(parent)
+
+		if err := s.subscribeReply(ctx, saved, parent); err != nil {
+			return nil, false, fmt.Errorf("messages service SaveMessage(...): %w", err)
+		}
+	}
+
+	return saved, true, nil
+}
+
+// attachmentRefs keeps only the IDs of the referenced attachments, the rest is filled in when they are linked.
+func attachmentRefs(attachments []models.Attachment) ([]models.Attachment, error) {
+	if len(attachments) > maxAttachments {
+		return nil, models.ErrTooManyAttachments
+	}
+
+	refs := make([]models.Attachment, 0, len(attachments))
+
+	for _, attachment := range attachments {
+		if attachment.ID == "" {
+			return nil, models.ErrAttachmentNotFound
+		}
+
+		if !slices.ContainsFunc(refs, func(ref models.Attachment) bool { return ref.ID == attachment.ID }) {
+			refs = append(refs, models.Attachment{ID: attachment.ID})
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	return refs, nil
+}
+
+// replyParent returns the message a reply answers, it has to be in the same room or direct conversation.
+func (s *MessagesService) replyParent(ctx context.Context, msg models.Message) (*models.Message, error) {
+	parent, err := s.liveMessage(ctx, msg.ReplyTo)
+	if err != nil {
+		return nil, fmt.Errorf("replyParent: %w", err)
+	}
+
+	samePair := (parent.Sender == msg.Sender && parent.Receiver == msg.Receiver) ||
+		(parent.Sender == msg.Receiver && parent.Receiver == msg.Sender)
+
+	if parent.Room != msg.Room || (msg.Room == "" && !samePair) {
+		return nil, models.ErrInvalidReply
+	}
+
+	return parent, nil
+}
+
+func (s *MessagesService) subscribeReply(ctx context.Context, reply *models.Message, parent *models.Message) error {
+	if err := s.repo.SubscribeThread(ctx, reply.ThreadID, reply.Sender); err != nil {
+		return fmt.Errorf("subscribeReply repo.SubscribeThread(...): %w", err)
+	}
+
+	if parent.ThreadID == 0 && parent.Sender != reply.Sender {
+		if err := s.repo.SubscribeThread(ctx, reply.ThreadID, parent.Sender); err != nil {
+			return fmt.Errorf("subscribeReply repo.SubscribeThread(...): %w", err)
+		}
+	}
+
+	return nil
+}
+
+func previewOf(msg *models.Message) *models.MessagePreview {
+	content := []rune(msg.Content)
+	if len(content) > previewLength {
+		content = content[:previewLength]
+	}
+
+	return &models.MessagePreview{Sender: msg.Sender, Content: string(content), Deleted: msg.Deleted}
+}
+
+// RoomHistory returns a page of room messages to a member of the room, oldest first.
+// A non-positive limit means DefaultHistoryLimit.
+func (s *MessagesService) RoomHistory(
+	ctx context.Context,
+	username string,
+	roomName string,
+	req models.HistoryRequest,
+) (*models.HistoryPage, error) {
+	req, err := normalizeHistoryRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorizeReader(ctx, &models.Message{Room: roomName}, username); err != nil {
+		return nil, fmt.Errorf("messages service RoomHistory(...): %w", err)
+	}
+
+	messages, err := s.repo.ListRoomMessages(ctx, roomName, req)
+	if err != nil {
+		return nil, fmt.Errorf("messages service RoomHistory(...) repo.ListRoomMessages(...): %w", err)
+	}
+
+	if err := s.attachDetails(ctx, messages); err != nil {
+		return nil, fmt.Errorf("messages service RoomHistory(...): %w", err)
+	}
+
+	return newHistoryPage(messages, req.Limit), nil
+}
+
+// DirectHistory returns a page of direct messages exchanged by the user and the peer, oldest first.
+func (s *MessagesService) DirectHistory(
+	ctx context.Context,
+	username string,
+	peer string,
+	req models.HistoryRequest,
+) (*models.HistoryPage, error) {
+	req, err := normalizeHistoryRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.repo.ListDirectMessages(ctx, username, peer, req)
+	if err != nil {
+		return nil, fmt.Errorf("messages service DirectHistory(...) repo.ListDirectMessages(...): %w", err)
+	}
+
+	if err := s.attachDetails(ctx, messages); err != nil {
+		return nil, fmt.Errorf("messages service DirectHistory(...): %w", err)
+	}
+
+	return newHistoryPage(messages, req.Limit), nil
+}
+
+// UndeliveredDirectMessages returns the direct messages queued for the user while it was offline, oldest first.
+func (s *MessagesService) UndeliveredDirectMessages(ctx context.Context, username string) ([]models.Message, error) {
+	messages, err := s.repo.ListUndeliveredDirectMessages(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"messages service UndeliveredDirectMessages(...) repo.ListUndeliveredDirectMessages(...): %w", err)
+	}
+
+	if err := s.attachDetails(ctx, messages); err != nil {
+		return nil, fmt.Errorf("messages service UndeliveredDirectMessages(...): %w", err)
+	}
+
+	return messages, nil
+}
+
+// MarkDelivered records that the direct messages reached their receiver, so they are not queued anymore.
+func (s *MessagesService) MarkDelivered(ctx context.Context, messageIDs ...int) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	if err := s.repo.MarkDelivered(ctx, messageIDs); err != nil {
+		return fmt.Errorf("messages service MarkDelivered(...) repo.MarkDelivered(...): %w", err)
+	}
+
+	return nil
+}
+
+// MarkRoomRead moves the user's read marker of the room to messageID, the latest message when it is 0,
+// and returns the marker. Markers never move backwards.
+func (s *MessagesService) MarkRoomRead(
+	ctx context.Context,
+	roomName string,
+	username string,
+	messageID int,
+) (int, error) {
+	if messageID < 0 {
+		return 0, models.ErrInvalidMessageID
+	}
+
+	lastReadID, err := s.repo.MarkRoomRead(ctx, roomName, username, messageID)
+	if err != nil {
+		return 0, fmt.Errorf("messages service MarkRoomRead(...) repo.MarkRoomRead(...): %w", err)
+	}
+
+	return lastReadID, nil
+}
+
+// MarkDirectRead marks the direct messages from the peer read up to messageID, all of them when it is 0.
+// The returned receipt for the peer is nil when nothing was unread.
+func (s *MessagesService) MarkDirectRead(
+	ctx context.Context,
+	username string,
+	peer string,
+	messageID int,
+) (*models.ReadReceipt, error) {
+	if messageID < 0 {
+		return nil, models.ErrInvalidMessageID
+	}
+
+	receipt, err := s.repo.MarkDirectRead(ctx, username, peer, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages service MarkDirectRead(...) repo.MarkDirectRead(...): %w", err)
+	}
+
+	return receipt, nil
+}
+
+// UnreadCounts returns the unread messages per room of the user and per direct message peer.
+func (s *MessagesService) UnreadCounts(ctx context.Context, username string) ([]models.UnreadCount, error) {
+	counts, err := s.repo.ListUnreadCounts(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("messages service UnreadCounts(...) repo.ListUnreadCounts(...): %w", err)
+	}
+
+	if counts == nil {
+		counts = []models.UnreadCount{}
+	}
+
+	return counts, nil
+}
+
+// EditMessage replaces the content of a message, only its sender may edit it and, for a room message,
+// only while still a member of the room not muted in it.
+func (s *MessagesService) EditMessage(
+	ctx context.Context,
+	editor string,
+	messageID int,
+	content string,
+) (*models.Message, error) {
+	if err := validateContent(content); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.liveMessage(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages service EditMessage(...): %w", err)
+	}
+
+	if msg.Sender != editor {
+		return nil, models.ErrMessageEditForbidden
+	}
+
+	if err := s.authorizePoster(ctx, msg, editor); err != nil {
+		return nil, fmt.Errorf("messages service EditMessage(...): %w", err)
+	}
+
+	edited, err := s.repo.UpdateContent(ctx, messageID, content)
+	if err != nil {
+		return nil, fmt.Errorf("messages service EditMessage(...) repo.UpdateContent(...): %w", err)
+	}
+
+	return edited, nil
+}
+
+// DeleteMessage retracts a message, its sender and the moderators of its room may delete it.
+// The returned message is the tombstone left in the history.
+func (s *MessagesService) DeleteMessage(ctx context.Context, username string, messageID int) (*models.Message, error) {
+	msg, err := s.liveMessage(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages service DeleteMessage(...): %w", err)
+	}
+
+	if msg.Sender != username {
+		if msg.Room == "" {
+			return nil, models.ErrMessageDeleteForbidden
+		}
+
+		err := s.moderation.CheckModerator(ctx, msg.Room, username)
+		if errors.Is(err, models.ErrNotRoomModerator) {
+			return nil, models.ErrMessageDeleteForbidden
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("messages service DeleteMessage(...) moderation.CheckModerator(...): %w", err)
+		}
+	}
+
+	deleted, err := s.repo.MarkDeleted(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages service DeleteMessage(...) repo.MarkDeleted(...): %w", err)
+	}
+
+	return deleted, nil
+}
+
+// React adds or removes the user's emoji reaction to a message of a room the user is in and not muted in,
+// or of its direct messages. It returns the message with its updated reaction summary and whether anything changed.
+func (s *MessagesService) React(ctx context.Context, reaction models.Reaction) (*models.Message, bool, error) {
+	if reaction.Emoji == "" || len(reaction.Emoji) > maxEmojiLength ||
+		strings.ContainsFunc(reaction.Emoji, unicode.IsSpace) {
+		return nil, false, models.ErrInvalidReaction
+	}
+
+	msg, err := s.liveMessage(ctx, reaction.MessageID)
+	if err != nil {
+		return nil, false, fmt.Errorf("messages service React(...): %w", err)
+	}
+
+	if err := s.authorizePoster(ctx, msg, reaction.User); err != nil {
+		return nil, false, fmt.Errorf("messages service React(...): %w", err)
+	}
+
+	update := s.repo.RemoveReaction
+	if reaction.Added {
+		update = s.repo.AddReaction
+	}
+
+	changed, err := update(ctx, msg.ID, reaction.User, reaction.Emoji)
+	if err != nil {
+		return nil, false, fmt.Errorf("messages service React(...): %w", err)
+	}
+
+	reactions, err := s.repo.ListReactions(ctx, []int{msg.ID})
+	if err != nil {
+		return nil, false, fmt.Errorf("messages service React(...) repo.ListReactions(...): %w", err)
+	}
+
+	msg.Reactions = reactions[msg.ID]
+
+	return msg, changed, nil
+}
+
+// Thread returns the first message of the thread the message belongs to with a page of its replies.
+// Only those who may see the message may read its thread.
+func (s *MessagesService) Thread(
+	ctx context.Context,
+	username string,
+	messageID int,
+	req models.HistoryRequest,
+) (*models.Thread, error) {
+	req, err := normalizeHistoryRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := s.threadRoot(ctx, username, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages service Thread(...): %w", err)
+	}
+
+	replies, err := s.repo.ListThreadMessages(ctx, root.ID, req)
+	if err != nil {
+		return nil, fmt.Errorf("messages service Thread(...) repo.ListThreadMessages(...): %w", err)
+	}
+
+	messages := append([]models.Message{*root}, replies...)
+	if err := s.attachDetails(ctx, messages); err != nil {
+		return nil, fmt.Errorf("messages service Thread(...): %w", err)
+	}
+
+	page := newHistoryPage(messages[1:], req.Limit)
+
+	return &models.Thread{Root: messages[0], Replies: page.Messages, NextCursor: page.NextCursor}, nil
+}
+
+// FollowThread subscribes the user to the thread of the message or unsubscribes it and returns the thread ID.
+func (s *MessagesService) FollowThread(ctx context.Context, username string, messageID int, follow bool) (int, error) {
+	root, err := s.threadRoot(ctx, username, messageID)
+	if err != nil {
+		return 0, fmt.Errorf("messages service FollowThread(...): %w", err)
+	}
+
+	if follow {
+		err = s.repo.SubscribeThread(ctx, root.ID, username)
+	} else {
+		_, err = s.repo.UnsubscribeThread(ctx, root.ID, username)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("messages service FollowThread(...): %w", err)
+	}
+
+	return root.ID, nil
+}
+
+// ThreadSubscribers returns the users notified of new replies in the thread.
+func (s *MessagesService) ThreadSubscribers(ctx context.Context, threadID int) ([]string, error) {
+	usernames, err := s.repo.ListThreadSubscribers(ctx, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("messages service ThreadSubscribers(...) repo.ListThreadSubscribers(...): %w", err)
+	}
+
+	return usernames, nil
+}
+
+// threadRoot returns the first message of the thread the message belongs to, provided the user may see it.
+func (s *MessagesService) threadRoot(ctx context.Context, username string, messageID int) (*models.Message, error) {
+	if messageID <= 0 {
+		return nil, models.ErrInvalidMessageID
+	}
+
+	msg, err := s.repo.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("threadRoot repo.GetByID(...): %w", err)
+	}
+
+	if err := s.authorizeReader(ctx, msg, username); err != nil {
+		return nil, fmt.Errorf("threadRoot: %w", err)
+	}
+
+	if msg.ThreadID == 0 {
+		return msg, nil
+	}
+
+	root, err := s.repo.GetByID(ctx, msg.ThreadID)
+	if err != nil {
+		return nil, fmt.Errorf("threadRoot repo.GetByID(...): %w", err)
+	}
+
+	return root, nil
+}
+
+// RecordMentions stores who a room message mentions and returns their usernames: the members named
+// with @username, the online ones for @here and every member for @room. The sender is never mentioned.
+func (s *MessagesService) RecordMentions(ctx context.Context, msg models.Message, online []string) ([]string, error) {
+	names := parseMentions(msg.Content)
+	if msg.Room == "" || len(names) == 0 {
+		return nil, nil
+	}
+
+	members, err := s.roomsRepo.ListMembers(ctx, msg.Room)
+	if err != nil {
+		return nil, fmt.Errorf("messages service RecordMentions(...) roomsRepo.ListMembers(...): %w", err)
+	}
+
+	everyone := slices.Contains(names, MentionRoom)
+	here := slices.Contains(names, MentionHere)
+
+	var mentioned []string
+
+	for _, member := range members {
+		name := member.UserName
+
+		switch {
+		case name == msg.Sender:
+			continue
+		case everyone, here && slices.Contains(online, name), slices.Contains(names, name):
+			mentioned = append(mentioned, name)
+		}
+	}
+
+	if len(mentioned) == 0 {
+		return nil, nil
+	}
+
+	if err := s.repo.CreateMentions(ctx, msg.ID, mentioned); err != nil {
+		return nil, fmt.Errorf("messages service RecordMentions(...) repo.CreateMentions(...): %w", err)
+	}
+
+	return mentioned, nil
+}
+
+// Mentions returns a page of the messages mentioning the user, oldest first.
+func (s *MessagesService) Mentions(
+	ctx context.Context,
+	username string,
+	req models.HistoryRequest,
+) (*models.HistoryPage, error) {
+	req, err := normalizeHistoryRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.repo.ListMentions(ctx, username, req)
+	if err != nil {
+		return nil, fmt.Errorf("messages service Mentions(...) repo.ListMentions(...): %w", err)
+	}
+
+	if err := s.attachDetails(ctx, messages); err != nil {
+		return nil, fmt.Errorf("messages service Mentions(...): %w", err)
+	}
+
+	return newHistoryPage(messages, req.Limit), nil
+}
+
+// parseMentions returns the distinct names mentioned as @name at the start of a word,
+// without the punctuation following them.
+func parseMentions(content string) []string {
+	var names []string
+
+	for _, word := range strings.Fields(content) {
+		name, found := strings.CutPrefix(word, "@")
+		name = strings.TrimRight(name, ".,:;!?)'\"")
+
+		if found && name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Message returns a message the user may read, with its reactions and attachments.
+// Deleted messages are reported as missing.
+func (s *MessagesService) Message(ctx context.Context, username string, messageID int) (*models.Message, error) {
+	msg, err := s.liveMessage(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("messages service Message(...): %w", err)
+	}
+
+	if err := s.authorizeReader(ctx, msg, username); err != nil {
+		return nil, fmt.Errorf("messages service Message(...): %w", err)
+	}
+
+	messages := []models.Message{*msg}
+	if err := s.attachDetails(ctx, messages); err != nil {
+		return nil, fmt.Errorf("messages service Message(...): %w", err)
+	}
+
+	return &messages[0], nil
+}
+
+// authorizeReader lets room members see room messages and both parties see their direct messages.
+// Direct messages of others are reported as missing.
+func (s *MessagesService) authorizeReader(ctx context.Context, msg *models.Message, username string) error {
+	if msg.Room == "" {
+		if msg.Sender != username && msg.Receiver != username {
+			return models.ErrMessageNotFound
+		}
+
+		return nil
+	}
+
+	member, err := s.roomsRepo.IsMember(ctx, msg.Room, username)
+	if err != nil {
+		return fmt.Errorf("roomsRepo.IsMember(...): %w", err)
+	}
+
+	if !member {
+		return models.ErrNotRoomMember
+	}
+
+	return nil
+}
+
+// authorizePoster fails unless the user may see the message and, for a room message, post to its room.
+func (s *MessagesService) authorizePoster(ctx context.Context, msg *models.Message, username string) error {
+	if err := s.authorizeReader(ctx, msg, username); err != nil {
+		return err
+	}
+
+	if msg.Room == "" {
+		return nil
+	}
+
+	if err := s.moderation.CheckCanPost(ctx, msg.Room, username); err != nil {
+		return fmt.Errorf("moderation.CheckCanPost(...): %w", err)
+	}
+
+	return nil
+}
+
+// attachDetails fills in the reaction summaries and the attachments of the messages, deleted ones have none.
+func (s *MessagesService) attachDetails(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+
+	reactions, err := s.repo.ListReactions(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("repo.ListReactions(...): %w", err)
+	}
+
+	attachments, err := s.repo.ListAttachments(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("repo.ListAttachments(...): %w", err)
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+
+		// The files of a deleted message are retracted with it, like Download refuses them.
+		if !messages[i].Deleted {
+			messages[i].Attachments = attachments[messages[i].ID]
+		}
+	}
+
+	return nil
+}
+
+// liveMessage returns a message that was not deleted.
+func (s *MessagesService) liveMessage(ctx context.Context, messageID int) (*models.Message, error) {
+	if messageID <= 0 {
+		return nil, models.ErrInvalidMessageID
+	}
+
+	msg, err := s.repo.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("repo.GetByID(...): %w", err)
+	}
+
+	if msg.Deleted {
+		return nil, models.ErrMessageNotFound
+	}
+
+	return msg, nil
+}
+
+func validateContent(content string) error {
+	switch {
+	case strings.TrimSpace(content) == "":
+		return models.ErrEmptyMessage
+	case len(content) > maxMessageLength:
+		return models.ErrMessageTooLong
+	}
+
+	return nil
+}
+
+func normalizeHistoryRequest(req models.HistoryRequest) (models.HistoryRequest, error) {
+	req.Query = strings.TrimSpace(req.Query)
+
+	switch {
+	case req.BeforeID < 0:
+		return req, models.ErrInvalidCursor
+	case req.Limit > MaxHistoryLimit:
+		return req, models.ErrHistoryLimitTooLarge
+	case req.Limit <= 0:
+		req.Limit = DefaultHistoryLimit
+	}
+
+	return req, nil
+}
+
+// newHistoryPage points the cursor at the oldest message of a full page, a short page is the last one.
+func newHistoryPage(messages []models.Message, limit int) *models.HistoryPage {
+	page := &models.HistoryPage{Messages: messages}
+
+	if len(messages) == limit {
+		page.NextCursor = messages[0].ID
+	}
+
+	if page.Messages == nil {
+		page.Messages = []models.Message{}
+	}
+
+	return page
+}
@@ -0,0 +1,549 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+type MockMessagesRepo struct {
+	mock.Mock
+}
+
+func (m *MockMessagesRepo) Create(ctx context.Context, msg models.Message) (*models.Message, error) {
+	args := m.Called(ctx, msg)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.Message), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockMessagesRepo) GetByClientID(ctx context.Context, sender string, clientID string) (*models.Message, error) {
+	args := m.Called(ctx, sender, clientID)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.Message), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListRoomMessages(ctx context.Context, roomName string, req models.HistoryRequest) ([]models.Message, error) {
+	args := m.Called(ctx, roomName, req)
+	return args.Get(0).([]models.Message), args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListDirectMessages(ctx context.Context, username string, peer string, req models.HistoryRequest) ([]models.Message, error) {
+	args := m.Called(ctx, username, peer, req)
+	return args.Get(0).([]models.Message), args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListUndeliveredDirectMessages(ctx context.Context, username string) ([]models.Message, error) {
+	args := m.Called(ctx, username)
+	return args.Get(0).([]models.Message), args.Error(1)
+}
+
+func (m *MockMessagesRepo) MarkDelivered(ctx context.Context, messageIDs []int) error {
+	args := m.Called(ctx, messageIDs)
+	return args.Error(0)
+}
+
+func (m *MockMessagesRepo) MarkRoomRead(ctx context.Context, roomName string, username string, messageID int) (int, error) {
+	args := m.Called(ctx, roomName, username, messageID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockMessagesRepo) MarkDirectRead(ctx context.Context, username string, peer string, messageID int) (*models.ReadReceipt, error) {
+	args := m.Called(ctx, username, peer, messageID)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.ReadReceipt), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListUnreadCounts(ctx context.Context, username string) ([]models.UnreadCount, error) {
+	args := m.Called(ctx, username)
+	return args.Get(0).([]models.UnreadCount), args.Error(1)
+}
+
+func (m *MockMessagesRepo) GetByID(ctx context.Context, messageID int) (*models.Message, error) {
+	args := m.Called(ctx, messageID)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.Message), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockMessagesRepo) UpdateContent(ctx context.Context, messageID int, content string) (*models.Message, error) {
+	args := m.Called(ctx, messageID, content)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.Message), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockMessagesRepo) MarkDeleted(ctx context.Context, messageID int) (*models.Message, error) {
+	args := m.Called(ctx, messageID)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.Message), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockMessagesRepo) AddReaction(ctx context.Context, messageID int, username string, emoji string) (bool, error) {
+	args := m.Called(ctx, messageID, username, emoji)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMessagesRepo) RemoveReaction(ctx context.Context, messageID int, username string, emoji string) (bool, error) {
+	args := m.Called(ctx, messageID, username, emoji)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListReactions(ctx context.Context, messageIDs []int) (map[int][]models.ReactionCount, error) {
+	args := m.Called(ctx, messageIDs)
+	return args.Get(0).(map[int][]models.ReactionCount), args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListThreadMessages(ctx context.Context, threadID int, req models.HistoryRequest) ([]models.Message, error) {
+	args := m.Called(ctx, threadID, req)
+	return args.Get(0).([]models.Message), args.Error(1)
+}
+
+func (m *MockMessagesRepo) SubscribeThread(ctx context.Context, threadID int, username string) error {
+	args := m.Called(ctx, threadID, username)
+	return args.Error(0)
+}
+
+func (m *MockMessagesRepo) UnsubscribeThread(ctx context.Context, threadID int, username string) (bool, error) {
+	args := m.Called(ctx, threadID, username)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListThreadSubscribers(ctx context.Context, threadID int) ([]string, error) {
+	args := m.Called(ctx, threadID)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockMessagesRepo) CreateMentions(ctx context.Context, messageID int, usernames []string) error {
+	args := m.Called(ctx, messageID, usernames)
+	return args.Error(0)
+}
+
+func (m *MockMessagesRepo) ListAttachments(ctx context.Context, messageIDs []int) (map[int][]models.Attachment, error) {
+	args := m.Called(ctx, messageIDs)
+	return args.Get(0).(map[int][]models.Attachment), args.Error(1)
+}
+
+func (m *MockMessagesRepo) ListMentions(ctx context.Context, username string, req models.HistoryRequest) ([]models.Message, error) {
+	args := m.Called(ctx, username, req)
+	return args.Get(0).([]models.Message), args.Error(1)
+}
+
+func setupMessagesService() (MessagesServiceInterface, *MockMessagesRepo) {
+	messagesService, mockRepo, _ := setupMessagesServiceWithRooms()
+	return messagesService, mockRepo
+}
+
+func setupMessagesServiceWithRooms() (MessagesServiceInterface, *MockMessagesRepo, *MockRoomsRepo) {
+	messagesService, mockRepo, mockRoomsRepo, _ := setupMessagesServiceWithModeration()
+	return messagesService, mockRepo, mockRoomsRepo
+}
+
+func setupMessagesServiceWithModeration() (MessagesServiceInterface, *MockMessagesRepo, *MockRoomsRepo, *MockModerationRepo) {
+	mockRepo := new(MockMessagesRepo)
+	mockRoomsRepo := new(MockRoomsRepo)
+	mockModerationRepo := new(MockModerationRepo)
+	moderationService := NewModerationService(mockModerationRepo, mockRoomsRepo, nil)
+	return NewMessagesService(mockRepo, mockRoomsRepo, moderationService), mockRepo, mockRoomsRepo, mockModerationRepo
+}
+
+func TestSaveMessage(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+	msg := models.Message{Room: "general", Sender: "testuser", Content: "hello"}
+	stored := msg
+	stored.ID = 42
+	stored.CreatedAt = time.Now()
+
+	mockRepo.On("Create", ctx, msg).Return(&stored, nil).Once()
+
+	saved, created, err := messagesService.SaveMessage(ctx, msg)
+	assert.NoError(t, err, "saving a valid message should succeed")
+	assert.True(t, created, "a new message should be reported as created")
+	assert.Equal(t, 42, saved.ID, "message ID should be assigned by the repository")
+	assert.False(t, saved.CreatedAt.IsZero(), "message timestamp should be assigned by the repository")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSaveMessageValidation(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+
+	_, _, err := messagesService.SaveMessage(ctx, models.Message{Room: "general", Sender: "testuser", Content: "  "})
+	assert.ErrorIs(t, err, models.ErrEmptyMessage, "blank messages should be rejected")
+
+	longContent := strings.Repeat("a", maxMessageLength+1)
+	_, _, err = messagesService.SaveMessage(ctx, models.Message{Room: "general", Sender: "testuser", Content: longContent})
+	assert.ErrorIs(t, err, models.ErrMessageTooLong, "too long messages should be rejected")
+
+	longClientID := strings.Repeat("a", maxClientIDLength+1)
+	_, _, err = messagesService.SaveMessage(ctx, models.Message{Room: "general", Sender: "testuser", Content: "hi", ClientID: longClientID})
+	assert.ErrorIs(t, err, models.ErrInvalidClientID, "too long client IDs should be rejected")
+
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestSaveMessageWithAttachments(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+	msg := models.Message{
+		Room:        "general",
+		Sender:      "testuser",
+		Attachments: []models.Attachment{{ID: "f1", Name: "ignored.exe"}, {ID: "f1"}},
+	}
+	linked := models.Message{Room: "general", Sender: "testuser", Attachments: []models.Attachment{{ID: "f1"}}}
+	stored := linked
+	stored.ID = 42
+	stored.Attachments = []models.Attachment{{ID: "f1", Name: "plan.pdf", MIMEType: "application/pdf", MessageID: 42}}
+
+	mockRepo.On("Create", ctx, linked).Return(&stored, nil).Once()
+
+	saved, _, err := messagesService.SaveMessage(ctx, msg)
+	assert.NoError(t, err, "a message with attachments should not need content")
+	assert.Equal(t, "plan.pdf", saved.Attachments[0].Name, "attachments should be described by the repository")
+
+	tooMany := make([]models.Attachment, maxAttachments+1)
+	_, _, err = messagesService.SaveMessage(ctx, models.Message{Room: "general", Sender: "testuser", Content: "hi", Attachments: tooMany})
+	assert.ErrorIs(t, err, models.ErrTooManyAttachments, "the number of attachments should be limited")
+
+	_, _, err = messagesService.SaveMessage(ctx, models.Message{Room: "general", Sender: "testuser", Attachments: []models.Attachment{{}}})
+	assert.ErrorIs(t, err, models.ErrAttachmentNotFound, "attachments should be referenced by ID")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSaveMessageDuplicate(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+	msg := models.Message{ClientID: "c-1", Room: "general", Sender: "testuser", Content: "hello"}
+	stored := msg
+	stored.ID = 42
+	stored.CreatedAt = time.Now()
+
+	mockRepo.On("Create", ctx, msg).Return(nil, fmt.Errorf("wrapped: %w", models.ErrDuplicateMessage)).Once()
+	mockRepo.On("GetByClientID", ctx, "testuser", "c-1").Return(&stored, nil).Once()
+
+	saved, created, err := messagesService.SaveMessage(ctx, msg)
+	assert.NoError(t, err, "a retried message should not fail")
+	assert.False(t, created, "a retried message should not be reported as created")
+	assert.Equal(t, 42, saved.ID, "a retried message should resolve to the stored one")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRoomHistory(t *testing.T) {
+	messagesService, mockRepo, mockRoomsRepo := setupMessagesServiceWithRooms()
+	ctx := context.Background()
+	page := []models.Message{{ID: 1, Room: "general"}, {ID: 2, Room: "general", Deleted: true}}
+
+	mockRepo.On("ListRoomMessages", ctx, "general", models.HistoryRequest{Limit: DefaultHistoryLimit}).Return(page, nil).Once()
+	mockRepo.On("ListRoomMessages", ctx, "general", models.HistoryRequest{BeforeID: 10, Limit: 2, Query: "deploy"}).Return(page, nil).Once()
+	mockRepo.On("ListReactions", ctx, []int{1, 2}).
+		Return(map[int][]models.ReactionCount{2: {{Emoji: "+1", Count: 1, Users: []string{"testuser"}}}}, nil).Twice()
+	mockRepo.On("ListAttachments", ctx, []int{1, 2}).
+		Return(map[int][]models.Attachment{
+			1: {{ID: "f1", Name: "plan.pdf", MessageID: 1}},
+			2: {{ID: "f2", Name: "secret.pdf", MessageID: 2}},
+		}, nil).Twice()
+	mockRoomsRepo.On("IsMember", ctx, "general", "testuser").Return(true, nil).Twice()
+	mockRoomsRepo.On("IsMember", ctx, "general", "outsider").Return(false, nil).Once()
+
+	history, err := messagesService.RoomHistory(ctx, "testuser", "general", models.HistoryRequest{})
+	assert.NoError(t, err, "history without limit should use the default limit")
+	assert.Equal(t, page, history.Messages, "history should be returned as stored")
+	assert.Equal(t, 1, history.Messages[1].Reactions[0].Count, "history should carry the reaction summary")
+	assert.Equal(t, "plan.pdf", history.Messages[0].Attachments[0].Name, "history should carry the attachments")
+	assert.Empty(t, history.Messages[1].Attachments, "a deleted message should not show its attachments")
+	assert.Zero(t, history.NextCursor, "a short page should be the last one")
+
+	history, err = messagesService.RoomHistory(ctx, "testuser", "general", models.HistoryRequest{BeforeID: 10, Limit: 2, Query: " deploy "})
+	assert.NoError(t, err, "history page before a message should succeed")
+	assert.Equal(t, 1, history.NextCursor, "a full page should point at its oldest message")
+
+	_, err = messagesService.RoomHistory(ctx, "testuser", "general", models.HistoryRequest{Limit: MaxHistoryLimit + 1})
+	assert.ErrorIs(t, err, models.ErrHistoryLimitTooLarge, "too large limit should be rejected")
+
+	_, err = messagesService.RoomHistory(ctx, "testuser", "general", models.HistoryRequest{BeforeID: -1})
+	assert.ErrorIs(t, err, models.ErrInvalidCursor, "negative cursor should be rejected")
+
+	_, err = messagesService.RoomHistory(ctx, "outsider", "general", models.HistoryRequest{})
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "others should not read the room history")
+
+	mockRepo.AssertExpectations(t)
+	mockRoomsRepo.AssertExpectations(t)
+}
+
+func TestDirectHistory(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+
+	mockRepo.On("ListDirectMessages", ctx, "alice1", "bobbob", models.HistoryRequest{Limit: DefaultHistoryLimit}).
+		Return([]models.Message(nil), nil).Once()
+
+	history, err := messagesService.DirectHistory(ctx, "alice1", "bobbob", models.HistoryRequest{})
+	assert.NoError(t, err, "direct history should succeed")
+	assert.NotNil(t, history.Messages, "an empty page should still carry an empty list")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMarkDelivered(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+
+	mockRepo.On("MarkDelivered", ctx, []int{1, 2}).Return(nil).Once()
+
+	assert.NoError(t, messagesService.MarkDelivered(ctx, 1, 2), "marking messages delivered should succeed")
+	assert.NoError(t, messagesService.MarkDelivered(ctx), "marking nothing should succeed")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMarkRoomRead(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+
+	mockRepo.On("MarkRoomRead", ctx, "general", "testuser", 0).Return(42, nil).Once()
+	mockRepo.On("MarkRoomRead", ctx, "random", "testuser", 7).Return(0, models.ErrNotRoomMember).Once()
+
+	lastReadID, err := messagesService.MarkRoomRead(ctx, "general", "testuser", 0)
+	assert.NoError(t, err, "marking a room read should succeed")
+	assert.Equal(t, 42, lastReadID, "the read marker should be returned")
+
+	_, err = messagesService.MarkRoomRead(ctx, "random", "testuser", 7)
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "marking a foreign room read should fail")
+
+	_, err = messagesService.MarkRoomRead(ctx, "general", "testuser", -1)
+	assert.ErrorIs(t, err, models.ErrInvalidMessageID, "negative message IDs should be rejected")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUnreadCounts(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+
+	mockRepo.On("ListUnreadCounts", ctx, "testuser").Return([]models.UnreadCount(nil), nil).Once()
+
+	counts, err := messagesService.UnreadCounts(ctx, "testuser")
+	assert.NoError(t, err, "unread counts should succeed")
+	assert.NotNil(t, counts, "no unread messages should still be an empty list")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestEditMessage(t *testing.T) {
+	messagesService, mockRepo, mockRoomsRepo, mockModerationRepo := setupMessagesServiceWithModeration()
+	ctx := context.Background()
+
+	stored := &models.Message{ID: 7, Room: "general", Sender: "testuser", Content: "helo"}
+	editedAt := time.Now()
+	edited := &models.Message{ID: 7, Room: "general", Sender: "testuser", Content: "hello", EditedAt: &editedAt}
+
+	mockRepo.On("GetByID", ctx, 7).Return(stored, nil)
+	mockRepo.On("UpdateContent", ctx, 7, "hello").Return(edited, nil).Once()
+	mockRepo.On("GetByID", ctx, 8).Return(&models.Message{ID: 8, Sender: "testuser", Deleted: true}, nil).Once()
+	mockRoomsRepo.On("IsMember", ctx, "general", "testuser").Return(true, nil).Twice()
+	mockModerationRepo.On("ActiveSanction", ctx, "general", "testuser", models.SanctionMute).Return(nil, nil).Once()
+
+	result, err := messagesService.EditMessage(ctx, "testuser", 7, "hello")
+	assert.NoError(t, err, "the sender should be able to edit its message")
+	assert.Equal(t, edited, result, "the edited message should be returned")
+
+	_, err = messagesService.EditMessage(ctx, "otheruser", 7, "hijacked")
+	assert.ErrorIs(t, err, models.ErrMessageEditForbidden, "only the sender should be able to edit a message")
+
+	_, err = messagesService.EditMessage(ctx, "testuser", 8, "hello")
+	assert.ErrorIs(t, err, models.ErrMessageNotFound, "deleted messages should not be editable")
+
+	_, err = messagesService.EditMessage(ctx, "testuser", 7, " ")
+	assert.ErrorIs(t, err, models.ErrEmptyMessage, "an edit should not empty a message")
+
+	mockModerationRepo.On("ActiveSanction", ctx, "general", "testuser", models.SanctionMute).
+		Return(&models.Sanction{Room: "general", Username: "testuser", Kind: models.SanctionMute}, nil).Once()
+
+	_, err = messagesService.EditMessage(ctx, "testuser", 7, "hello")
+	assert.ErrorIs(t, err, models.ErrMutedInRoom, "muted senders should not edit their messages")
+
+	mockRoomsRepo.On("IsMember", ctx, "general", "testuser").Return(false, nil).Once()
+
+	_, err = messagesService.EditMessage(ctx, "testuser", 7, "hello")
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "senders kicked or banned from the room should not edit their messages")
+
+	mockRepo.AssertExpectations(t)
+	mockRoomsRepo.AssertExpectations(t)
+	mockModerationRepo.AssertExpectations(t)
+}
+
+func TestDeleteMessage(t *testing.T) {
+	mockRepo := new(MockMessagesRepo)
+	mockRoomsRepo := new(MockRoomsRepo)
+	moderationService := NewModerationService(new(MockModerationRepo), mockRoomsRepo, []string{"admin"})
+	messagesService := NewMessagesService(mockRepo, mockRoomsRepo, moderationService)
+	ctx := context.Background()
+
+	roomMessage := &models.Message{ID: 7, Room: "general", Sender: "testuser", Content: "oops"}
+	directMessage := &models.Message{ID: 8, Receiver: "otheruser", Sender: "testuser", Content: "oops"}
+	tombstone := &models.Message{ID: 7, Room: "general", Sender: "testuser", Deleted: true}
+
+	mockRepo.On("GetByID", ctx, 7).Return(roomMessage, nil)
+	mockRepo.On("GetByID", ctx, 8).Return(directMessage, nil)
+	mockRepo.On("MarkDeleted", ctx, 7).Return(tombstone, nil).Times(3)
+	mockRoomsRepo.On("GetByName", ctx, "general").Return(&models.Room{Name: "general"}, nil).Times(3)
+	mockRoomsRepo.On("MemberRole", ctx, "general", "moderator").Return(models.RoleModerator, nil).Once()
+	mockRoomsRepo.On("MemberRole", ctx, "general", "otheruser").Return(models.RoleMember, nil).Once()
+
+	result, err := messagesService.DeleteMessage(ctx, "testuser", 7)
+	assert.NoError(t, err, "the sender should be able to delete its message")
+	assert.True(t, result.Deleted, "the tombstone should be returned")
+
+	_, err = messagesService.DeleteMessage(ctx, "moderator", 7)
+	assert.NoError(t, err, "a room moderator should be able to delete any message of the room")
+
+	_, err = messagesService.DeleteMessage(ctx, "admin", 7)
+	assert.NoError(t, err, "a server admin should be able to delete any room message")
+
+	_, err = messagesService.DeleteMessage(ctx, "otheruser", 7)
+	assert.ErrorIs(t, err, models.ErrMessageDeleteForbidden, "other members should not delete messages")
+
+	_, err = messagesService.DeleteMessage(ctx, "otheruser", 8)
+	assert.ErrorIs(t, err, models.ErrMessageDeleteForbidden, "the receiver should not delete a direct message")
+
+	_, err = messagesService.DeleteMessage(ctx, "testuser", 0)
+	assert.ErrorIs(t, err, models.ErrInvalidMessageID, "message IDs should be positive")
+
+	mockRepo.AssertExpectations(t)
+	mockRoomsRepo.AssertExpectations(t)
+}
+
+func TestReact(t *testing.T) {
+	messagesService, mockRepo, mockRoomsRepo, mockModerationRepo := setupMessagesServiceWithModeration()
+	ctx := context.Background()
+
+	summary := []models.ReactionCount{{Emoji: "+1", Count: 1, Users: []string{"testuser"}}}
+
+	mockRepo.On("GetByID", ctx, 7).Return(&models.Message{ID: 7, Room: "general", Sender: "otheruser"}, nil)
+	mockRepo.On("GetByID", ctx, 8).Return(&models.Message{ID: 8, Receiver: "otheruser", Sender: "thirduser"}, nil)
+	mockRoomsRepo.On("IsMember", ctx, "general", "testuser").Return(true, nil).Twice()
+	mockModerationRepo.On("ActiveSanction", ctx, "general", "testuser", models.SanctionMute).Return(nil, nil).Once()
+	mockRoomsRepo.On("IsMember", ctx, "general", "outsider").Return(false, nil).Once()
+	mockRepo.On("AddReaction", ctx, 7, "testuser", "+1").Return(true, nil).Once()
+	mockRepo.On("ListReactions", ctx, []int{7}).Return(map[int][]models.ReactionCount{7: summary}, nil).Once()
+
+	msg, changed, err := messagesService.React(ctx, models.Reaction{MessageID: 7, User: "testuser", Emoji: "+1", Added: true})
+	assert.NoError(t, err, "room members should be able to react")
+	assert.True(t, changed, "a new reaction should be reported as a change")
+	assert.Equal(t, summary, msg.Reactions, "the updated summary should be returned")
+
+	_, _, err = messagesService.React(ctx, models.Reaction{MessageID: 7, User: "outsider", Emoji: "+1", Added: true})
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "only room members should react to room messages")
+
+	_, _, err = messagesService.React(ctx, models.Reaction{MessageID: 8, User: "testuser", Emoji: "+1", Added: true})
+	assert.ErrorIs(t, err, models.ErrMessageNotFound, "direct messages of others should stay hidden")
+
+	_, _, err = messagesService.React(ctx, models.Reaction{MessageID: 7, User: "testuser", Emoji: "thumbs up"})
+	assert.ErrorIs(t, err, models.ErrInvalidReaction, "reactions with spaces should be rejected")
+
+	mockModerationRepo.On("ActiveSanction", ctx, "general", "testuser", models.SanctionMute).
+		Return(&models.Sanction{Room: "general", Username: "testuser", Kind: models.SanctionMute}, nil).Once()
+
+	_, _, err = messagesService.React(ctx, models.Reaction{MessageID: 7, User: "testuser", Emoji: "+1", Added: true})
+	assert.ErrorIs(t, err, models.ErrMutedInRoom, "muted members should not react")
+
+	mockRepo.AssertExpectations(t)
+	mockRoomsRepo.AssertExpectations(t)
+	mockModerationRepo.AssertExpectations(t)
+}
+
+func TestSaveReply(t *testing.T) {
+	messagesService, mockRepo := setupMessagesService()
+	ctx := context.Background()
+
+	root := &models.Message{ID: 7, Room: "general", Sender: "rootauthor", Content: "ship it?"}
+	reply := &models.Message{ID: 9, Room: "general", Sender: "testuser", Content: "yes", ReplyTo: 8, ThreadID: 7}
+
+	mockRepo.On("GetByID", ctx, 7).Return(root, nil)
+	mockRepo.On("GetByID", ctx, 8).Return(&models.Message{ID: 8, Room: "general", Sender: "otheruser", ThreadID: 7}, nil)
+	mockRepo.On("Create", ctx, models.Message{Room: "general", Sender: "testuser", Content: "sure", ReplyTo: 7, ThreadID: 7}).
+		Return(&models.Message{ID: 8, Room: "general", Sender: "testuser", Content: "sure", ReplyTo: 7, ThreadID: 7}, nil).Once()
+	mockRepo.On("Create", ctx, models.Message{Room: "general", Sender: "testuser", Content: "yes", ReplyTo: 8, ThreadID: 7}).
+		Return(reply, nil).Once()
+	mockRepo.On("SubscribeThread", ctx, 7, "testuser").Return(nil).Twice()
+	mockRepo.On("SubscribeThread", ctx, 7, "rootauthor").Return(nil).Once()
+
+	saved, _, err := messagesService.SaveMessage(ctx, models.Message{Room: "general", Sender: "testuser", Content: "sure", ReplyTo: 7})
+	assert.NoError(t, err, "replying to a message should succeed")
+	assert.Equal(t, 7, saved.ThreadID, "a reply to the first message should start its thread")
+	assert.Equal(t, &models.MessagePreview{Sender: "rootauthor", Content: "ship it?"}, saved.Parent,
+		"a reply should carry a preview of its parent")
+
+	saved, _, err = messagesService.SaveMessage(ctx, models.Message{Room: "general", Sender: "testuser", Content: "yes", ReplyTo: 8})
+	assert.NoError(t, err, "replying to a reply should succeed")
+	assert.Equal(t, 7, saved.ThreadID, "a reply to a reply should stay in the thread")
+
+	_, _, err = messagesService.SaveMessage(ctx, models.Message{Room: "random", Sender: "testuser", Content: "hi", ReplyTo: 7})
+	assert.ErrorIs(t, err, models.ErrInvalidReply, "a reply should be sent to the room of its parent")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestThread(t *testing.T) {
+	messagesService, mockRepo, mockRoomsRepo := setupMessagesServiceWithRooms()
+	ctx := context.Background()
+
+	root := &models.Message{ID: 7, Room: "general", Sender: "rootauthor", Content: "ship it?"}
+	replies := []models.Message{{ID: 8, Room: "general", Sender: "testuser", ReplyTo: 7, ThreadID: 7}}
+
+	mockRepo.On("GetByID", ctx, 8).Return(&replies[0], nil).Once()
+	mockRepo.On("GetByID", ctx, 7).Return(root, nil).Once()
+	mockRoomsRepo.On("IsMember", ctx, "general", "testuser").Return(true, nil).Once()
+	mockRepo.On("ListThreadMessages", ctx, 7, models.HistoryRequest{Limit: DefaultHistoryLimit}).Return(replies, nil).Once()
+	mockRepo.On("ListReactions", ctx, []int{7, 8}).Return(map[int][]models.ReactionCount{}, nil).Once()
+	mockRepo.On("ListAttachments", ctx, []int{7, 8}).Return(map[int][]models.Attachment{}, nil).Once()
+
+	thread, err := messagesService.Thread(ctx, "testuser", 8, models.HistoryRequest{})
+	assert.NoError(t, err, "a thread should be found by any of its messages")
+	assert.Equal(t, 7, thread.Root.ID, "the thread should start with its first message")
+	assert.Len(t, thread.Replies, 1, "the replies should follow")
+
+	mockRepo.AssertExpectations(t)
+	mockRoomsRepo.AssertExpectations(t)
+}
+
+func TestRecordMentions(t *testing.T) {
+	messagesService, mockRepo, mockRoomsRepo := setupMessagesServiceWithRooms()
+	ctx := context.Background()
+
+	members := []models.User{{UserName: "alice1"}, {UserName: "bobbob"}, {UserName: "carol1"}, {UserName: "davedave"}}
+	mockRoomsRepo.On("ListMembers", ctx, "general").Return(members, nil)
+	mockRepo.On("CreateMentions", ctx, 7, []string{"bobbob", "carol1"}).Return(nil).Once()
+	mockRepo.On("CreateMentions", ctx, 8, []string{"bobbob", "carol1", "davedave"}).Return(nil).Once()
+
+	msg := models.Message{ID: 7, Room: "general", Sender: "alice1", Content: "@bobbob, @here: ready? @alice1 @stranger"}
+	mentioned, err := messagesService.RecordMentions(ctx, msg, []string{"alice1", "carol1"})
+	assert.NoError(t, err, "recording mentions should succeed")
+	assert.Equal(t, []string{"bobbob", "carol1"}, mentioned,
+		"named members and online ones for @here should be mentioned, the sender and outsiders not")
+
+	msg = models.Message{ID: 8, Room: "general", Sender: "alice1", Content: "deploy at noon @room"}
+	mentioned, err = messagesService.RecordMentions(ctx, msg, nil)
+	assert.NoError(t, err, "recording mentions should succeed")
+	assert.Len(t, mentioned, 3, "@room should mention every member but the sender")
+
+	mentioned, err = messagesService.RecordMentions(ctx, models.Message{ID: 9, Room: "general", Content: "mail me at a@b.c"}, nil)
+	assert.NoError(t, err, "a message without mentions should succeed")
+	assert.Empty(t, mentioned, "@ inside a word should not be a mention")
+
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stsolovey/kvant_chat/internal/app/repository"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+type ModerationServiceInterface interface {
+	Kick(
+		ctx context.Context,
+		roomName string,
+		moderator string,
+		target string,
+		reason string,
+	) (*models.ModerationAction, error)
+	Ban(
+		ctx context.Context,
+		roomName string,
+		moderator string,
+		target string,
+		duration time.Duration,
+		reason string,
+	) (*models.ModerationAction, error)
+	Unban(ctx context.Context, roomName string, moderator string, target string) (*models.ModerationAction, error)
+	Mute(
+		ctx context.Context,
+		roomName string,
+		moderator string,
+		target string,
+		duration time.Duration,
+		reason string,
+	) (*models.ModerationAction, error)
+	Unmute(ctx context.Context, roomName string, moderator string, target string) (*models.ModerationAction, error)
+	CheckCanPost(ctx context.Context, roomName string, username string) error
+	CheckModerator(ctx context.Context, roomName string, username string) error
+	ListBans(ctx context.Context, roomName string, requester string) ([]models.Sanction, error)
+	AuditLog(ctx context.Context, roomName string, requester string, limit int) ([]models.ModerationAction, error)
+}
+
+// ModerationService lets the owner and the moderators of a room, and the server admins in every room,
+// keep users out of it or silent for a while.
+type ModerationService struct {
+	repo      repository.ModerationRepositoryInterface
+	roomsRepo repository.RoomsRepositoryInterface
+	admins    map[string]bool
+}
+
+func NewModerationService(
+	repo repository.ModerationRepositoryInterface,
+	roomsRepo repository.RoomsRepositoryInterface,
+	admins []string,
+) ModerationServiceInterface {
+	adminSet := make(map[string]bool, len(admins))
+	for _, admin := range admins {
+		adminSet[admin] = true
+	}
+
+	return &ModerationService{
+		repo:      repo,
+		roomsRepo: roomsRepo,
+		admins:    adminSet,
+	}
+}
+
+// Kick removes the member from the room, a public room can be joined again right away.
+func (s *ModerationService) Kick(
+	ctx context.Context,
+	roomName string,
+	moderator string,
+	target string,
+	reason string,
+) (*models.ModerationAction, error) {
+	return s.apply(ctx, models.ModerationAction{
+		Room:      roomName,
+		Action:    models.ActionKick,
+		Moderator: moderator,
+		Target:    target,
+		Reason:    reason,
+	}, 0)
+}
+
+// Ban removes the user from the room and keeps it out for the duration, forever when it is 0.
+func (s *ModerationService) Ban(
+	ctx context.Context,
+	roomName string,
+	moderator string,
+	target string,
+	duration time.Duration,
+	reason string,
+) (*models.ModerationAction, error) {
+	return s.apply(ctx, models.ModerationAction{
+		Room:      roomName,
+		Action:    models.ActionBan,
+		Moderator: moderator,
+		Target:    target,
+		Reason:    reason,
+	}, duration)
+}
+
+func (s *ModerationService) Unban(
+	ctx context.Context,
+	roomName string,
+	moderator string,
+	target string,
+) (*models.ModerationAction, error) {
+	return s.apply(ctx, models.ModerationAction{
+		Room:      roomName,
+		Action:    models.ActionUnban,
+		Moderator: moderator,
+		Target:    target,
+	}, 0)
+}
+
+// Mute keeps the member from posting to the room for the duration, forever when it is 0.
+func (s *ModerationService) Mute(
+	ctx context.Context,
+	roomName string,
+	moderator string,
+	target string,
+	duration time.Duration,
+	reason string,
+) (*models.ModerationAction, error) {
+	return s.apply(ctx, models.ModerationAction{
+		Room:      roomName,
+		Action:    models.ActionMute,
+		Moderator: moderator,
+		Target:    target,
+		Reason:    reason,
+	}, duration)
+}
+
+func (s *ModerationService) Unmute(
+	ctx context.Context,
+	roomName string,
+	moderator string,
+	target string,
+) (*models.ModerationAction, error) {
+	return s.apply(ctx, models.ModerationAction{
+		Room:      roomName,
+		Action:    models.ActionUnmute,
+		Moderator: moderator,
+		Target:    target,
+	}, 0)
+}
+
+// CheckCanPost fails with ErrMutedInRoom while the user is muted in the room.
+func (s *ModerationService) CheckCanPost(ctx context.Context, roomName string, username string) error {
+	mute, err := s.repo.ActiveSanction(ctx, roomName, username, models.SanctionMute)
+	if err != nil {
+		return fmt.Errorf("moderation service CheckCanPost(...): %w", err)
+	}
+
+	if mute != nil {
+		return models.ErrMutedInRoom
+	}
+
+	return nil
+}
+
+// CheckModerator fails with ErrNotRoomModerator unless the user moderates the room: its owner,
+// its moderators and the admins.
+func (s *ModerationService) CheckModerator(ctx context.Context, roomName string, username string) error {
+	if _, err := s.moderatorRole(ctx, roomName, username); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *ModerationService) ListBans(
+	ctx context.Context,
+	roomName string,
+	requester string,
+) ([]models.Sanction, error) {
+	if _, err := s.moderatorRole(ctx, roomName, requester); err != nil {
+		return nil, err
+	}
+
+	bans, err := s.repo.ListSanctions(ctx, roomName, models.SanctionBan)
+	if err != nil {
+		return nil, fmt.Errorf("moderation service ListBans(...): %w", err)
+	}
+
+	return bans, nil
+}
+
+// AuditLog returns the latest moderation actions in the room.
+// A non-positive limit means DefaultHistoryLimit.
+func (s *ModerationService) AuditLog(
+	ctx context.Context,
+	roomName string,
+	requester string,
+	limit int,
+) ([]models.ModerationAction, error) {
+	switch {
+	case limit > MaxHistoryLimit:
+		return nil, models.ErrHistoryLimitTooLarge
+	case limit <= 0:
+		limit = DefaultHistoryLimit
+	}
+
+	if _, err := s.moderatorRole(ctx, roomName, requester); err != nil {
+		return nil, err
+	}
+
+	actions, err := s.repo.ListActions(ctx, roomName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("moderation service AuditLog(...): %w", err)
+	}
+
+	return actions, nil
+}
+
+// apply records the action after checking the moderator may take it against the target:
+// nobody moderates themselves or the owner, only the owner and admins moderate moderators.
+func (s *ModerationService) apply(
+	ctx context.Context,
+	action models.ModerationAction,
+	duration time.Duration,
+) (*models.ModerationAction, error) {
+	if duration < 0 {
+		return nil, models.ErrInvalidDuration
+	}
+
+	if duration > 0 {
+		expiresAt := time.Now().Add(duration)
+		action.ExpiresAt = &expiresAt
+	}
+
+	role, err := s.moderatorRole(ctx, action.Room, action.Moderator)
+	if err != nil {
+		return nil, err
+	}
+
+	if action.Target == action.Moderator {
+		return nil, models.ErrCannotModerate
+	}
+
+	targetRole, err := s.roomsRepo.MemberRole(ctx, action.Room, action.Target)
+	if err != nil {
+		return nil, fmt.Errorf("moderation service apply(...) roomsRepo.MemberRole(...): %w", err)
+	}
+
+	if targetRole == models.RoleOwner || (targetRole == models.RoleModerator && role == models.RoleModerator) {
+		return nil, models.ErrCannotModerate
+	}
+
+	recorded, err := s.repo.Record(ctx, action)
+	if err != nil {
+		return nil, fmt.Errorf("moderation service apply(...) repo.Record(...): %w", err)
+	}
+
+	return recorded, nil
+}
+
+// moderatorRole returns the role of the user who moderates the room, admins count as owners.
+// It fails with ErrNotRoomModerator for plain members and others.
+func (s *ModerationService) moderatorRole(ctx context.Context, roomName string, username string) (string, error) {
+	if _, err := s.roomsRepo.GetByName(ctx, roomName); err != nil {
+		return "", fmt.Errorf("moderation service roomsRepo.GetByName(...): %w", err)
+	}
+
+	if s.admins[username] {
+		return models.RoleOwner, nil
+	}
+
+	role, err := s.roomsRepo.MemberRole(ctx, roomName, username)
+	if err != nil {
+		return "", fmt.Errorf("moderation service roomsRepo.MemberRole(...): %w", err)
+	}
+
+	if role != models.RoleOwner && role != models.RoleModerator {
+		return "", models.ErrNotRoomModerator
+	}
+
+	return role, nil
+}
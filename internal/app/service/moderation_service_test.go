@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+type MockModerationRepo struct {
+	mock.Mock
+}
+
+func (m *MockModerationRepo) Record(ctx context.Context, action models.ModerationAction) (*models.ModerationAction, error) {
+	args := m.Called(ctx, action)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ModerationAction), args.Error(1)
+}
+
+func (m *MockModerationRepo) ActiveSanction(
+	ctx context.Context,
+	roomName string,
+	username string,
+	kind string,
+) (*models.Sanction, error) {
+	args := m.Called(ctx, roomName, username, kind)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Sanction), args.Error(1)
+}
+
+func (m *MockModerationRepo) ListSanctions(ctx context.Context, roomName string, kind string) ([]models.Sanction, error) {
+	args := m.Called(ctx, roomName, kind)
+	return args.Get(0).([]models.Sanction), args.Error(1)
+}
+
+func (m *MockModerationRepo) ListActions(ctx context.Context, roomName string, limit int) ([]models.ModerationAction, error) {
+	args := m.Called(ctx, roomName, limit)
+	return args.Get(0).([]models.ModerationAction), args.Error(1)
+}
+
+func setupModerationService() (ModerationServiceInterface, *MockModerationRepo, *MockRoomsRepo) {
+	mockRepo := new(MockModerationRepo)
+	mockRoomsRepo := new(MockRoomsRepo)
+	return NewModerationService(mockRepo, mockRoomsRepo, []string{"admin"}), mockRepo, mockRoomsRepo
+}
+
+// expectRoles sets the roles of the members of #backend, everyone else is not a member.
+func expectRoles(ctx context.Context, mockRoomsRepo *MockRoomsRepo) {
+	mockRoomsRepo.On("GetByName", ctx, "backend").Return(&models.Room{ID: 2, Name: "backend"}, nil)
+	mockRoomsRepo.On("MemberRole", ctx, "backend", "owner").Return(models.RoleOwner, nil)
+	mockRoomsRepo.On("MemberRole", ctx, "backend", "mod").Return(models.RoleModerator, nil)
+	mockRoomsRepo.On("MemberRole", ctx, "backend", "mod2").Return(models.RoleModerator, nil)
+	mockRoomsRepo.On("MemberRole", ctx, "backend", "member").Return(models.RoleMember, nil)
+	mockRoomsRepo.On("MemberRole", ctx, "backend", mock.Anything).Return("", nil)
+}
+
+func TestBan(t *testing.T) {
+	moderationService, mockRepo, mockRoomsRepo := setupModerationService()
+	ctx := context.Background()
+	expectRoles(ctx, mockRoomsRepo)
+
+	var recorded models.ModerationAction
+
+	mockRepo.On("Record", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		recorded = args.Get(1).(models.ModerationAction)
+	}).Return(&models.ModerationAction{ID: 1}, nil)
+
+	before := time.Now()
+
+	_, err := moderationService.Ban(ctx, "backend", "mod", "member", time.Hour, "spam")
+	require.NoError(t, err, "moderators should ban members")
+	assert.Equal(t, models.ActionBan, recorded.Action, "the ban should be recorded")
+	assert.Equal(t, "spam", recorded.Reason, "the reason should be recorded")
+	require.NotNil(t, recorded.ExpiresAt, "a ban for a while should expire")
+	assert.WithinDuration(t, before.Add(time.Hour), *recorded.ExpiresAt, time.Minute, "the ban should last the duration")
+
+	_, err = moderationService.Ban(ctx, "backend", "admin", "stranger", 0, "")
+	require.NoError(t, err, "admins should ban in every room")
+	assert.Nil(t, recorded.ExpiresAt, "a ban without duration should be permanent")
+
+	_, err = moderationService.Ban(ctx, "backend", "member", "mod", 0, "")
+	assert.ErrorIs(t, err, models.ErrNotRoomModerator, "plain members should not ban")
+
+	_, err = moderationService.Ban(ctx, "backend", "mod", "mod2", 0, "")
+	assert.ErrorIs(t, err, models.ErrCannotModerate, "moderators should not ban each other")
+
+	_, err = moderationService.Ban(ctx, "backend", "mod", "owner", 0, "")
+	assert.ErrorIs(t, err, models.ErrCannotModerate, "the owner should not be banned")
+
+	_, err = moderationService.Ban(ctx, "backend", "mod", "mod", 0, "")
+	assert.ErrorIs(t, err, models.ErrCannotModerate, "moderators should not ban themselves")
+
+	_, err = moderationService.Ban(ctx, "backend", "mod", "member", -time.Hour, "")
+	assert.ErrorIs(t, err, models.ErrInvalidDuration, "a negative duration should be rejected")
+
+	_, err = moderationService.Ban(ctx, "backend", "owner", "mod", 0, "")
+	require.NoError(t, err, "the owner should ban moderators")
+
+	mockRepo.AssertNumberOfCalls(t, "Record", 3)
+}
+
+func TestKickAndUnmute(t *testing.T) {
+	moderationService, mockRepo, mockRoomsRepo := setupModerationService()
+	ctx := context.Background()
+	expectRoles(ctx, mockRoomsRepo)
+
+	kick := models.ModerationAction{Room: "backend", Action: models.ActionKick, Moderator: "mod", Target: "stranger"}
+	mockRepo.On("Record", ctx, kick).Return(nil, models.ErrNotRoomMember).Once()
+
+	_, err := moderationService.Kick(ctx, "backend", "mod", "stranger", "")
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "only members should be kicked")
+
+	unmute := models.ModerationAction{Room: "backend", Action: models.ActionUnmute, Moderator: "mod", Target: "member"}
+	mockRepo.On("Record", ctx, unmute).Return(&unmute, nil).Once()
+
+	action, err := moderationService.Unmute(ctx, "backend", "mod", "member")
+	require.NoError(t, err, "moderators should unmute members")
+	assert.Equal(t, models.ActionUnmute, action.Action, "the unmute should be recorded")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCheckCanPost(t *testing.T) {
+	moderationService, mockRepo, _ := setupModerationService()
+	ctx := context.Background()
+
+	mockRepo.On("ActiveSanction", ctx, "backend", "member", models.SanctionMute).Return(nil, nil).Once()
+	mockRepo.On("ActiveSanction", ctx, "backend", "muted", models.SanctionMute).
+		Return(&models.Sanction{Room: "backend", Username: "muted", Kind: models.SanctionMute}, nil).Once()
+
+	assert.NoError(t, moderationService.CheckCanPost(ctx, "backend", "member"), "members should post")
+	assert.ErrorIs(t, moderationService.CheckCanPost(ctx, "backend", "muted"), models.ErrMutedInRoom,
+		"muted members should not post")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAuditLog(t *testing.T) {
+	moderationService, mockRepo, mockRoomsRepo := setupModerationService()
+	ctx := context.Background()
+	expectRoles(ctx, mockRoomsRepo)
+
+	actions := []models.ModerationAction{{ID: 2, Action: models.ActionUnban}, {ID: 1, Action: models.ActionBan}}
+	mockRepo.On("ListActions", ctx, "backend", DefaultHistoryLimit).Return(actions, nil).Once()
+
+	log, err := moderationService.AuditLog(ctx, "backend", "owner", 0)
+	require.NoError(t, err, "the owner should read the audit log")
+	assert.Equal(t, actions, log, "the actions should be returned as stored")
+
+	_, err = moderationService.AuditLog(ctx, "backend", "member", 0)
+	assert.ErrorIs(t, err, models.ErrNotRoomModerator, "plain members should not read the audit log")
+
+	_, err = moderationService.AuditLog(ctx, "backend", "owner", MaxHistoryLimit+1)
+	assert.ErrorIs(t, err, models.ErrHistoryLimitTooLarge, "too large limit should be rejected")
+
+	mockRepo.AssertExpectations(t)
+}
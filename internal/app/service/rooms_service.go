@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/stsolovey/kvant_chat/internal/app/repository"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+var roomNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+type RoomsServiceInterface interface {
+	CreateRoom(ctx context.Context, name string, creator *models.User, private bool) (*models.Room, error)
+	EnsureRoom(ctx context.Context, name string) error
+	ListRooms(ctx context.Context) ([]models.Room, error)
+	JoinRoom(ctx context.Context, name string, user *models.User) error
+	LeaveRoom(ctx context.Context, name string, user *models.User) error
+	ListUserRooms(ctx context.Context, user *models.User) ([]string, error)
+	ListRoomMembers(ctx context.Context, name string) ([]models.User, error)
+	SetRoomSilenced(ctx context.Context, name string, user *models.User, silenced bool) error
+	ListSilencedRooms(ctx context.Context, user *models.User) ([]string, error)
+	Invite(ctx context.Context, name string, inviter string, invitee string) (*models.RoomInvitation, error)
+	AcceptInvitation(ctx context.Context, name string, username string) error
+	DeclineInvitation(ctx context.Context, name string, username string) error
+	ListInvitations(ctx context.Context, username string) ([]models.RoomInvitation, error)
+	SetMemberRole(ctx context.Context, name string, actor string, member string, role string) error
+}
+
+type RoomsService struct {
+	repo           repository.RoomsRepositoryInterface
+	moderationRepo repository.ModerationRepositoryInterface
+}
+
+func NewRoomsService(
+	repo repository.RoomsRepositoryInterface,
+	moderationRepo repository.ModerationRepositoryInterface,
+) RoomsServiceInterface {
+	return &RoomsService{
+		repo:           repo,
+		moderationRepo: moderationRepo,
+	}
+}
+
+// CreateRoom creates a room owned by its creator. Only those invited can join a private room.
+func (s *RoomsService) CreateRoom(
+	ctx context.Context,
+	name string,
+	creator *models.User,
+	private bool,
+) (*models.Room, error) {
+	if !roomNameRegexp.MatchString(name) {
+		return nil, models.ErrInvalidRoomName
+	}
+
+	room, err := s.repo.Create(ctx, name, creator.ID, private)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service CreateRoom(...) repo.Create(...): %w", err)
+	}
+
+	return room, nil
+}
+
+func (s *RoomsService) EnsureRoom(ctx context.Context, name string) error {
+	if err := s.repo.Ensure(ctx, name); err != nil {
+		return fmt.Errorf("rooms service EnsureRoom(...): %w", err)
+	}
+
+	return nil
+}
+
+func (s *RoomsService) ListRooms(ctx context.Context) ([]models.Room, error) {
+	rooms, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service ListRooms(...): %w", err)
+	}
+
+	return rooms, nil
+}
+
+// JoinRoom makes the user a member of a public room, private rooms are only open to their members.
+// Users banned from the room cannot join it.
+func (s *RoomsService) JoinRoom(ctx context.Context, name string, user *models.User) error {
+	room, err := s.repo.GetByName(ctx, name)
+	if err != nil {
+		return fmt.Errorf("rooms service JoinRoom(...) repo.GetByName(...): %w", err)
+	}
+
+	if err := s.checkNotBanned(ctx, name, user.UserName); err != nil {
+		return fmt.Errorf("rooms service JoinRoom(...): %w", err)
+	}
+
+	if room.Private {
+		role, err := s.repo.MemberRole(ctx, name, user.UserName)
+		if err != nil {
+			return fmt.Errorf("rooms service JoinRoom(...) repo.MemberRole(...): %w", err)
+		}
+
+		if role == "" {
+			return models.ErrRoomPrivate
+		}
+	}
+
+	if err := s.repo.AddMember(ctx, name, user.ID, models.RoleMember); err != nil {
+		return fmt.Errorf("rooms service JoinRoom(...): %w", err)
+	}
+
+	return nil
+}
+
+// LeaveRoom ends the user's membership, a leaving owner hands the room over to another member.
+func (s *RoomsService) LeaveRoom(ctx context.Context, name string, user *models.User) error {
+	if err := s.repo.RemoveMember(ctx, name, user.ID); err != nil {
+		return fmt.Errorf("rooms service LeaveRoom(...): %w", err)
+	}
+
+	return nil
+}
+
+func (s *RoomsService) ListUserRooms(ctx context.Context, user *models.User) ([]string, error) {
+	names, err := s.repo.ListUserRooms(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service ListUserRooms(...): %w", err)
+	}
+
+	return names, nil
+}
+
+func (s *RoomsService) ListRoomMembers(ctx context.Context, name string) ([]models.User, error) {
+	members, err := s.repo.ListMembers(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service ListRoomMembers(...): %w", err)
+	}
+
+	return members, nil
+}
+
+// SetRoomSilenced silences the room for the member: new messages are not pushed to it,
+// replies in the threads it follows still are.
+func (s *RoomsService) SetRoomSilenced(ctx context.Context, name string, user *models.User, silenced bool) error {
+	if err := s.repo.SetSilenced(ctx, name, user.ID, silenced); err != nil {
+		return fmt.Errorf("rooms service SetRoomSilenced(...): %w", err)
+	}
+
+	return nil
+}
+
+func (s *RoomsService) ListSilencedRooms(ctx context.Context, user *models.User) ([]string, error) {
+	names, err := s.repo.ListSilencedRooms(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service ListSilencedRooms(...): %w", err)
+	}
+
+	return names, nil
+}
+
+// Invite invites a registered user to the room. Any member may invite to a public room,
+// only owners and moderators to a private one.
+func (s *RoomsService) Invite(
+	ctx context.Context,
+	name string,
+	inviter string,
+	invitee string,
+) (*models.RoomInvitation, error) {
+	room, err := s.repo.GetByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service Invite(...) repo.GetByName(...): %w", err)
+	}
+
+	role, err := s.repo.MemberRole(ctx, name, inviter)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service Invite(...) repo.MemberRole(...): %w", err)
+	}
+
+	switch {
+	case role == "":
+		return nil, models.ErrNotRoomMember
+	case room.Private && role == models.RoleMember:
+		return nil, models.ErrNotRoomModerator
+	}
+
+	inviteeRole, err := s.repo.MemberRole(ctx, name, invitee)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service Invite(...) repo.MemberRole(...): %w", err)
+	}
+
+	if inviteeRole != "" {
+		return nil, models.ErrAlreadyRoomMember
+	}
+
+	if err := s.checkNotBanned(ctx, name, invitee); err != nil {
+		return nil, fmt.Errorf("rooms service Invite(...): %w", err)
+	}
+
+	invitation, err := s.repo.CreateInvitation(ctx, name, inviter, invitee)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service Invite(...) repo.CreateInvitation(...): %w", err)
+	}
+
+	return invitation, nil
+}
+
+// AcceptInvitation makes the invited user a member of the room unless it was banned since.
+func (s *RoomsService) AcceptInvitation(ctx context.Context, name string, username string) error {
+	if err := s.checkNotBanned(ctx, name, username); err != nil {
+		return fmt.Errorf("rooms service AcceptInvitation(...): %w", err)
+	}
+
+	accepted, err := s.repo.AcceptInvitation(ctx, name, username)
+	if err != nil {
+		return fmt.Errorf("rooms service AcceptInvitation(...): %w", err)
+	}
+
+	if !accepted {
+		return models.ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+func (s *RoomsService) DeclineInvitation(ctx context.Context, name string, username string) error {
+	declined, err := s.repo.DeleteInvitation(ctx, name, username)
+	if err != nil {
+		return fmt.Errorf("rooms service DeclineInvitation(...): %w", err)
+	}
+
+	if !declined {
+		return models.ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+func (s *RoomsService) ListInvitations(ctx context.Context, username string) ([]models.RoomInvitation, error) {
+	invitations, err := s.repo.ListInvitations(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("rooms service ListInvitations(...): %w", err)
+	}
+
+	return invitations, nil
+}
+
+// SetMemberRole lets the owner appoint moderators among the members or make them plain members again.
+// The owner's own role cannot change.
+func (s *RoomsService) SetMemberRole(ctx context.Context, name string, actor string, member string, role string) error {
+	if role != models.RoleModerator && role != models.RoleMember {
+		return models.ErrInvalidRole
+	}
+
+	actorRole, err := s.repo.MemberRole(ctx, name, actor)
+	if err != nil {
+		return fmt.Errorf("rooms service SetMemberRole(...) repo.MemberRole(...): %w", err)
+	}
+
+	if actorRole != models.RoleOwner {
+		return models.ErrNotRoomOwner
+	}
+
+	memberRole, err := s.repo.MemberRole(ctx, name, member)
+	if err != nil {
+		return fmt.Errorf("rooms service SetMemberRole(...) repo.MemberRole(...): %w", err)
+	}
+
+	switch memberRole {
+	case "":
+		return models.ErrNotRoomMember
+	case models.RoleOwner:
+		return models.ErrInvalidRole
+	}
+
+	if err := s.repo.SetRole(ctx, name, member, role); err != nil {
+		return fmt.Errorf("rooms service SetMemberRole(...) repo.SetRole(...): %w", err)
+	}
+
+	return nil
+}
+
+// checkNotBanned fails with ErrBannedFromRoom while the user is banned from the room.
+func (s *RoomsService) checkNotBanned(ctx context.Context, name string, username string) error {
+	ban, err := s.moderationRepo.ActiveSanction(ctx, name, username, models.SanctionBan)
+	if err != nil {
+		return fmt.Errorf("moderationRepo.ActiveSanction(...): %w", err)
+	}
+
+	if ban != nil {
+		return models.ErrBannedFromRoom
+	}
+
+	return nil
+}
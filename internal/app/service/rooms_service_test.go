@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+type MockRoomsRepo struct {
+	mock.Mock
+}
+
+func (m *MockRoomsRepo) Create(ctx context.Context, name string, creatorID int, private bool) (*models.Room, error) {
+	args := m.Called(ctx, name, creatorID, private)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.Room), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockRoomsRepo) Ensure(ctx context.Context, name string) error {
+	return m.Called(ctx, name).Error(0)
+}
+
+func (m *MockRoomsRepo) List(ctx context.Context) ([]models.Room, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]models.Room), args.Error(1)
+}
+
+func (m *MockRoomsRepo) GetByName(ctx context.Context, name string) (*models.Room, error) {
+	args := m.Called(ctx, name)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.Room), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockRoomsRepo) AddMember(ctx context.Context, roomName string, userID int, role string) error {
+	return m.Called(ctx, roomName, userID, role).Error(0)
+}
+
+func (m *MockRoomsRepo) RemoveMember(ctx context.Context, roomName string, userID int) error {
+	return m.Called(ctx, roomName, userID).Error(0)
+}
+
+func (m *MockRoomsRepo) ListMembers(ctx context.Context, roomName string) ([]models.User, error) {
+	args := m.Called(ctx, roomName)
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+func (m *MockRoomsRepo) ListUserRooms(ctx context.Context, userID int) ([]string, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRoomsRepo) IsMember(ctx context.Context, roomName string, username string) (bool, error) {
+	args := m.Called(ctx, roomName, username)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRoomsRepo) MemberRole(ctx context.Context, roomName string, username string) (string, error) {
+	args := m.Called(ctx, roomName, username)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockRoomsRepo) SetRole(ctx context.Context, roomName string, username string, role string) error {
+	return m.Called(ctx, roomName, username, role).Error(0)
+}
+
+func (m *MockRoomsRepo) CreateInvitation(
+	ctx context.Context,
+	roomName string,
+	inviter string,
+	invitee string,
+) (*models.RoomInvitation, error) {
+	args := m.Called(ctx, roomName, inviter, invitee)
+	if args.Get(0) != nil {
+		return args.Get(0).(*models.RoomInvitation), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockRoomsRepo) AcceptInvitation(ctx context.Context, roomName string, username string) (bool, error) {
+	args := m.Called(ctx, roomName, username)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRoomsRepo) DeleteInvitation(ctx context.Context, roomName string, username string) (bool, error) {
+	args := m.Called(ctx, roomName, username)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRoomsRepo) ListInvitations(ctx context.Context, username string) ([]models.RoomInvitation, error) {
+	args := m.Called(ctx, username)
+	return args.Get(0).([]models.RoomInvitation), args.Error(1)
+}
+
+func (m *MockRoomsRepo) SetSilenced(ctx context.Context, roomName string, userID int, silenced bool) error {
+	args := m.Called(ctx, roomName, userID, silenced)
+	return args.Error(0)
+}
+
+func (m *MockRoomsRepo) ListSilencedRooms(ctx context.Context, userID int) ([]string, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func setupRoomsService() (RoomsServiceInterface, *MockRoomsRepo) {
+	roomsService, mockRepo, _ := setupRoomsServiceWithModeration()
+	return roomsService, mockRepo
+}
+
+func setupRoomsServiceWithModeration() (RoomsServiceInterface, *MockRoomsRepo, *MockModerationRepo) {
+	mockRepo := new(MockRoomsRepo)
+	mockModerationRepo := new(MockModerationRepo)
+	return NewRoomsService(mockRepo, mockModerationRepo), mockRepo, mockModerationRepo
+}
+
+func TestCreateRoom(t *testing.T) {
+	roomsService, mockRepo := setupRoomsService()
+	ctx := context.Background()
+	creator := &models.User{ID: 7, UserName: "testuser"}
+
+	mockRepo.On("Create", ctx, "backend", 7, false).Return(&models.Room{ID: 2, Name: "backend"}, nil).Once()
+
+	room, err := roomsService.CreateRoom(ctx, "backend", creator, false)
+	assert.NoError(t, err, "creating a room should succeed")
+	assert.Equal(t, "backend", room.Name, "room name should match")
+	mockRepo.AssertExpectations(t)
+
+	mockRepo.On("Create", ctx, "backend", 7, false).Return(nil, models.ErrRoomExists).Once()
+
+	_, err = roomsService.CreateRoom(ctx, "backend", creator, false)
+	assert.ErrorIs(t, err, models.ErrRoomExists, "creating a duplicate room should fail")
+}
+
+func TestCreateRoomInvalidName(t *testing.T) {
+	roomsService, mockRepo := setupRoomsService()
+	ctx := context.Background()
+	creator := &models.User{ID: 7, UserName: "testuser"}
+
+	for _, name := range []string{"", "with space", "#hash", "a-very-long-room-name-that-is-over-32"} {
+		_, err := roomsService.CreateRoom(ctx, name, creator, false)
+		assert.ErrorIs(t, err, models.ErrInvalidRoomName, "room name %q should be rejected", name)
+	}
+
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestJoinPrivateRoom(t *testing.T) {
+	roomsService, mockRepo, mockModerationRepo := setupRoomsServiceWithModeration()
+	ctx := context.Background()
+	member := &models.User{ID: 7, UserName: "member"}
+	outsider := &models.User{ID: 8, UserName: "outsider"}
+	banned := &models.User{ID: 9, UserName: "banned"}
+
+	mockRepo.On("GetByName", ctx, "secret").Return(&models.Room{ID: 2, Name: "secret", Private: true}, nil).Times(3)
+	mockModerationRepo.On("ActiveSanction", ctx, "secret", "banned", models.SanctionBan).
+		Return(&models.Sanction{Room: "secret", Username: "banned", Kind: models.SanctionBan}, nil).Once()
+	mockModerationRepo.On("ActiveSanction", ctx, "secret", mock.Anything, models.SanctionBan).Return(nil, nil).Twice()
+	mockRepo.On("MemberRole", ctx, "secret", "outsider").Return("", nil).Once()
+	mockRepo.On("MemberRole", ctx, "secret", "member").Return(models.RoleMember, nil).Once()
+	mockRepo.On("AddMember", ctx, "secret", 7, models.RoleMember).Return(nil).Once()
+
+	err := roomsService.JoinRoom(ctx, "secret", outsider)
+	assert.ErrorIs(t, err, models.ErrRoomPrivate, "joining a private room without an invitation should fail")
+
+	err = roomsService.JoinRoom(ctx, "secret", member)
+	assert.NoError(t, err, "members should rejoin their private room")
+
+	err = roomsService.JoinRoom(ctx, "secret", banned)
+	assert.ErrorIs(t, err, models.ErrBannedFromRoom, "banned users should not join")
+
+	mockRepo.AssertExpectations(t)
+	mockModerationRepo.AssertExpectations(t)
+}
+
+func TestInvite(t *testing.T) {
+	roomsService, mockRepo, mockModerationRepo := setupRoomsServiceWithModeration()
+	ctx := context.Background()
+
+	mockModerationRepo.On("ActiveSanction", ctx, "secret", mock.Anything, models.SanctionBan).Return(nil, nil)
+
+	mockRepo.On("GetByName", ctx, "secret").Return(&models.Room{ID: 2, Name: "secret", Private: true}, nil)
+	mockRepo.On("MemberRole", ctx, "secret", "owner").Return(models.RoleOwner, nil)
+	mockRepo.On("MemberRole", ctx, "secret", "member").Return(models.RoleMember, nil)
+	mockRepo.On("MemberRole", ctx, "secret", "outsider").Return("", nil)
+	mockRepo.On("MemberRole", ctx, "secret", "bob").Return("", nil)
+	mockRepo.On("CreateInvitation", ctx, "secret", "owner", "bob").
+		Return(&models.RoomInvitation{Room: "secret", Inviter: "owner", Invitee: "bob"}, nil).Once()
+
+	invitation, err := roomsService.Invite(ctx, "secret", "owner", "bob")
+	assert.NoError(t, err, "the owner should invite to a private room")
+	assert.Equal(t, "bob", invitation.Invitee, "the invitation should be for the invitee")
+
+	_, err = roomsService.Invite(ctx, "secret", "member", "bob")
+	assert.ErrorIs(t, err, models.ErrNotRoomModerator, "plain members should not invite to a private room")
+
+	_, err = roomsService.Invite(ctx, "secret", "outsider", "bob")
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "others should not invite to the room")
+
+	_, err = roomsService.Invite(ctx, "secret", "owner", "member")
+	assert.ErrorIs(t, err, models.ErrAlreadyRoomMember, "members should not be invited again")
+
+	mockRepo.On("AcceptInvitation", ctx, "secret", "bob").Return(true, nil).Once()
+	mockRepo.On("AcceptInvitation", ctx, "secret", "eve").Return(false, nil).Once()
+
+	assert.NoError(t, roomsService.AcceptInvitation(ctx, "secret", "bob"), "accepting an invitation should succeed")
+	assert.ErrorIs(t, roomsService.AcceptInvitation(ctx, "secret", "eve"), models.ErrInvitationNotFound,
+		"accepting without an invitation should fail")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSetMemberRole(t *testing.T) {
+	roomsService, mockRepo := setupRoomsService()
+	ctx := context.Background()
+
+	mockRepo.On("MemberRole", ctx, "backend", "owner").Return(models.RoleOwner, nil)
+	mockRepo.On("MemberRole", ctx, "backend", "member").Return(models.RoleMember, nil)
+	mockRepo.On("MemberRole", ctx, "backend", "outsider").Return("", nil)
+	mockRepo.On("SetRole", ctx, "backend", "member", models.RoleModerator).Return(nil).Once()
+
+	err := roomsService.SetMemberRole(ctx, "backend", "owner", "member", models.RoleModerator)
+	assert.NoError(t, err, "the owner should appoint moderators")
+
+	err = roomsService.SetMemberRole(ctx, "backend", "member", "owner", models.RoleMember)
+	assert.ErrorIs(t, err, models.ErrNotRoomOwner, "only the owner should change roles")
+
+	err = roomsService.SetMemberRole(ctx, "backend", "owner", "outsider", models.RoleModerator)
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "only members should get a role")
+
+	err = roomsService.SetMemberRole(ctx, "backend", "owner", "owner", models.RoleMember)
+	assert.ErrorIs(t, err, models.ErrInvalidRole, "the owner should stay the owner")
+
+	err = roomsService.SetMemberRole(ctx, "backend", "owner", "member", models.RoleOwner)
+	assert.ErrorIs(t, err, models.ErrInvalidRole, "ownership should not be handed out")
+
+	mockRepo.AssertExpectations(t)
+}
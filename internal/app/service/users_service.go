@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/stsolovey/kvant_chat/internal/app/repository"
 	"github.com/stsolovey/kvant_chat/internal/models"
@@ -11,7 +12,8 @@ import (
 )
 
 type UsersServiceInterface interface {
-	RegisterUser(ctx context.Context, input models.UserRegisterInput) (*models.UserResponse, string, error)
+	RegisterUser(ctx context.Context, input models.UserRegisterInput) (*models.UserResponse, *models.TokenPair, error)
+	TouchLastSeen(ctx context.Context, username string) error
 }
 
 type UsersService struct {
@@ -32,32 +34,32 @@ func NewUsersService(
 func (s *UsersService) RegisterUser(
 	ctx context.Context,
 	input models.UserRegisterInput,
-) (*models.UserResponse, string, error) {
+) (*models.UserResponse, *models.TokenPair, error) {
 	const (
 		minUsernameLength = 6
 		minPasswordLength = 6
 	)
 
 	if len(input.UserName) < minUsernameLength {
-		return nil, "", models.ErrUsernameTooShort
+		return nil, nil, models.ErrUsernameTooShort
 	}
 
 	if len(input.HashPassword) < minPasswordLength {
-		return nil, "", models.ErrPasswordTooShort
+		return nil, nil, models.ErrPasswordTooShort
 	}
 
 	_, err := s.repo.GetUserByUsername(ctx, input.UserName)
 
 	switch {
 	case err == nil:
-		return nil, "", models.ErrUsernameExists
+		return nil, nil, models.ErrUsernameExists
 	case !errors.Is(err, models.ErrUserNotFound):
-		return nil, "", fmt.Errorf("users service RegisterUser(..) GetUserByUsername(...) error: %w", err)
+		return nil, nil, fmt.Errorf("users service RegisterUser(..) GetUserByUsername(...) error: %w", err)
 	}
 
 	hashedPassword, err := utils.HashPassword(input.HashPassword)
 	if err != nil {
-		return nil, "", fmt.Errorf("users service RegisterUser(..) utils.HashPassword(...) error: %w", err)
+		return nil, nil, fmt.Errorf("users service RegisterUser(..) utils.HashPassword(...) error: %w", err)
 	}
 
 	input.HashPassword = hashedPassword
@@ -67,7 +69,7 @@ func (s *UsersService) RegisterUser(
 		HashPassword: input.HashPassword,
 	})
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create user: %w", err)
+		return nil, nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
 	userResponse := &models.UserResponse{
@@ -77,10 +79,19 @@ func (s *UsersService) RegisterUser(
 		UpdatedAt: user.UpdatedAt,
 	}
 
-	token, err := s.authService.GenerateToken(user.UserName)
+	tokens, err := s.authService.GenerateToken(ctx, user.UserName)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+		return nil, nil, fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	return userResponse, token, nil
+	return userResponse, tokens, nil
+}
+
+// TouchLastSeen records that the user was around just now.
+func (s *UsersService) TouchLastSeen(ctx context.Context, username string) error {
+	if err := s.repo.UpdateLastSeen(ctx, username, time.Now()); err != nil {
+		return fmt.Errorf("users service TouchLastSeen(...) repo.UpdateLastSeen(...): %w", err)
+	}
+
+	return nil
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
@@ -27,21 +28,39 @@ func (m *MockUsersRepo) GetUserByUsername(ctx context.Context, username string)
 	return nil, args.Error(1)
 }
 
+func (m *MockUsersRepo) UpdateLastSeen(ctx context.Context, username string, lastSeenAt time.Time) error {
+	args := m.Called(ctx, username, lastSeenAt)
+	return args.Error(0)
+}
+
 type MockAuthService struct {
 	mock.Mock
 }
 
-func (m *MockAuthService) LoginUser(ctx context.Context, input models.UserLoginInput) (string, error) {
+func (m *MockAuthService) LoginUser(ctx context.Context, input models.UserLoginInput) (*models.TokenPair, error) {
 	args := m.Called(input)
-	return args.String(0), args.Error(1)
+	return args.Get(0).(*models.TokenPair), args.Error(1)
 }
 
-func (m *MockAuthService) GenerateToken(username string) (string, error) {
+func (m *MockAuthService) GenerateToken(ctx context.Context, username string) (*models.TokenPair, error) {
 	args := m.Called(username)
-	return args.String(0), args.Error(1)
+	return args.Get(0).(*models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthService) RefreshToken(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	args := m.Called(refreshToken)
+	return args.Get(0).(*models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthService) Logout(ctx context.Context, username string, jti string, allSessions bool) error {
+	return m.Called(username, jti, allSessions).Error(0)
+}
+
+func (m *MockAuthService) CheckSession(ctx context.Context, username string, jti string) error {
+	return m.Called(username, jti).Error(0)
 }
 
-func (m *MockAuthService) ValidateToken(tokenString string) (*jwt.Token, error) {
+func (m *MockAuthService) ValidateToken(ctx context.Context, tokenString string) (*jwt.Token, error) {
 	args := m.Called(tokenString)
 	return args.Get(0).(*jwt.Token), args.Error(1)
 }
@@ -56,6 +75,10 @@ func (m *MockAuthService) GetUserByUsername(ctx context.Context, username string
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
+func (m *MockAuthService) JWKS() models.JWKS {
+	return m.Called().Get(0).(models.JWKS)
+}
+
 func setupUsersService() (*UsersService, *MockUsersRepo, *MockAuthService) {
 	mockUsersRepo := new(MockUsersRepo)
 	mockAuthService := new(MockAuthService)
@@ -75,7 +98,7 @@ func TestRegisterUser(t *testing.T) {
 		UserName:     "newuser",
 		HashPassword: "hashedpassword123",
 	}
-	expectedToken := "token123"
+	expectedToken := &models.TokenPair{Token: "token123", RefreshToken: "refresh123"}
 	expectedResponse := &models.UserResponse{
 		UserName: "newuser",
 	}
@@ -100,3 +123,14 @@ func TestRegisterUser(t *testing.T) {
 	assert.Error(t, err, "Should return error when username already exists")
 	assert.Equal(t, models.ErrUsernameExists, err, "Error should be 'username already exists'")
 }
+
+func TestTouchLastSeen(t *testing.T) {
+	mockUsersRepo := new(MockUsersRepo)
+	usersService := NewUsersService(mockUsersRepo, new(MockAuthService))
+	ctx := context.Background()
+
+	mockUsersRepo.On("UpdateLastSeen", ctx, "testuser", mock.AnythingOfType("time.Time")).Return(nil).Once()
+
+	assert.NoError(t, usersService.TouchLastSeen(ctx, "testuser"), "recording last seen time should succeed")
+	mockUsersRepo.AssertExpectations(t)
+}
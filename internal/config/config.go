@@ -1,13 +1,18 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/jwtkeys"
 )
 
 var (
@@ -18,7 +23,12 @@ var (
 	errMissingDB        = errors.New("postgresDB environment variable is missing")
 	errMissingAppPort   = errors.New("appPort environment variable is missing")
 	errMissingTCPPort   = errors.New("tcpPort environment variable is missing")
-	errMissingJwtSecret = errors.New("jwtSecret environment variable is missing")
+	errMissingJwtSecret = errors.New("jwtSecret or jwtKeysDir environment variable is missing")
+
+	errNotANumber              = errors.New("environment variable is not a non-negative number")
+	errNotADuration            = errors.New("environment variable is not a positive duration")
+	errInvalidSlowClientPolicy = errors.New("slowClientPolicy must be drop_oldest or disconnect")
+	errInvalidKeyRotation      = errors.New("jwtKeyRotationInterval must be longer than jwtKeyActivationDelay")
 
 	errServerHost = errors.New("serverHost environment variable is missing")
 	errHTTPPort   = errors.New("httpPort environment variable is missing")
@@ -31,13 +41,76 @@ type Config struct {
 	AppHost        string
 	HTTPPort       string
 	TCPPort        string
-	SigningKey     []byte
 	ServerHost     string
 	TCPServerAddr  string
 	HTTPServerAddr string
 	HTTPServerURL  string
 	LoginURL       string
 	RegisterURL    string
+	RefreshURL     string
+	LogoutURL      string
+
+	HistoryReplayLimit int
+
+	OutboundQueueSize int
+	SlowClientPolicy  string
+	WriteTimeout      time.Duration
+
+	ResumeWindow     time.Duration
+	ResumeBufferSize int
+
+	TypingTimeout time.Duration
+
+	AttachmentsDir         string
+	MaxAttachmentSize      int64
+	AllowedAttachmentTypes []string
+	AttachmentsURL         string
+
+	AdminUsers []string
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// SigningKeys sign and verify access tokens, reloaded from disk every JWTKeysReloadInterval
+	// and rotated on the JWTKeyRotation schedule, if any.
+	SigningKeys           *jwtkeys.Keyring
+	JWTKeysReloadInterval time.Duration
+	JWTKeyRotation        jwtkeys.Rotation
+
+	SessionCheckInterval time.Duration
+	ReauthWindow         time.Duration
+
+	// TLSConfig secures the chat and HTTP connections, nil when they are cleartext.
+	TLSConfig *tls.Config
+}
+
+// Policies applied when a client's outbound queue is full.
+const (
+	SlowClientDropOldest = "drop_oldest"
+	SlowClientDisconnect = "disconnect"
+)
+
+const (
+	defaultHistoryReplayLimit = 20
+	defaultOutboundQueueSize  = 256
+	defaultWriteTimeout       = 10 * time.Second
+	defaultResumeWindow       = 2 * time.Minute
+	defaultResumeBufferSize   = 500
+	defaultTypingTimeout      = 5 * time.Second
+	defaultAttachmentsDir     = "data/attachments"
+	defaultMaxAttachmentSize  = 10 << 20
+	defaultAccessTokenTTL     = 15 * time.Minute
+	defaultRefreshTokenTTL    = 30 * 24 * time.Hour
+	defaultSessionCheck       = 30 * time.Second
+	defaultReauthWindow       = 2 * time.Minute
+	defaultKeyActivationDelay = 10 * time.Minute
+	defaultKeysReloadInterval = time.Minute
+)
+
+// defaultAllowedAttachmentTypes are the MIME types accepted for upload when ALLOWED_ATTACHMENT_TYPES is unset.
+var defaultAllowedAttachmentTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp",
+	"text/plain", "application/pdf", "application/zip",
 }
 
 func New(log *logrus.Logger, path string) (*Config, error) {
@@ -55,7 +128,123 @@ func New(log *logrus.Logger, path string) (*Config, error) {
 	appPort := os.Getenv("APP_PORT")
 	tcpPort := os.Getenv("TCP_PORT")
 	jwtSecret := os.Getenv("JWT_SECRET")
-	signingKey := []byte(jwtSecret)
+	jwtKeysDir := os.Getenv("JWT_KEYS_DIR")
+
+	historyReplayLimit, err := intFromEnv("HISTORY_REPLAY_LIMIT", defaultHistoryReplayLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	outboundQueueSize, err := intFromEnv("OUTBOUND_QUEUE_SIZE", defaultOutboundQueueSize)
+	if err != nil {
+		return nil, err
+	}
+
+	writeTimeout, err := durationFromEnv("WRITE_TIMEOUT", defaultWriteTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	resumeWindow, err := durationFromEnv("RESUME_WINDOW", defaultResumeWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	resumeBufferSize, err := intFromEnv("RESUME_BUFFER_SIZE", defaultResumeBufferSize)
+	if err != nil {
+		return nil, err
+	}
+
+	typingTimeout, err := durationFromEnv("TYPING_TIMEOUT", defaultTypingTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	maxAttachmentSize, err := intFromEnv("MAX_ATTACHMENT_SIZE", defaultMaxAttachmentSize)
+	if err != nil {
+		return nil, err
+	}
+
+	attachmentsDir := os.Getenv("ATTACHMENTS_DIR")
+	if attachmentsDir == "" {
+		attachmentsDir = defaultAttachmentsDir
+	}
+
+	allowedAttachmentTypes := listFromEnv("ALLOWED_ATTACHMENT_TYPES", defaultAllowedAttachmentTypes)
+
+	// Admins moderate every room, like its owner.
+	adminUsers := listFromEnv("ADMIN_USERS", nil)
+
+	accessTokenTTL, err := durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTokenTTL, err := durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chat sessions are checked for revoked tokens and deleted users every SESSION_CHECK_INTERVAL,
+	// and asked for a fresh token REAUTH_WINDOW before theirs expires.
+	sessionCheckInterval, err := durationFromEnv("SESSION_CHECK_INTERVAL", defaultSessionCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	reauthWindow, err := durationFromEnv("REAUTH_WINDOW", defaultReauthWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys of JWT_KEYS_DIR replace JWT_SECRET. A new key signs once it has been published in the JWKS
+	// for JWT_KEY_ACTIVATION_DELAY, so services caching the JWKS learn it first.
+	keyActivationDelay, err := durationFromEnv("JWT_KEY_ACTIVATION_DELAY", defaultKeyActivationDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	keysReloadInterval, err := durationFromEnv("JWT_KEYS_RELOAD_INTERVAL", defaultKeysReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unset, rotation is left to the operator. Generated keys are removed once the tokens they signed expired.
+	keyRotationInterval, err := durationFromEnv("JWT_KEY_ROTATION_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyRotationInterval != 0 && keyRotationInterval <= keyActivationDelay {
+		return nil, errInvalidKeyRotation
+	}
+
+	var signingKeys *jwtkeys.Keyring
+
+	if jwtKeysDir != "" {
+		signingKeys, err = jwtkeys.Load(jwtKeysDir, keyActivationDelay, log)
+		if err != nil {
+			return nil, fmt.Errorf("JWT keys: %w", err)
+		}
+	} else if jwtSecret != "" {
+		signingKeys = jwtkeys.NewSecret([]byte(jwtSecret))
+	}
+
+	tlsMinVersion, err := tlsMinVersionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := serverTLSConfig(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"),
+		os.Getenv("TLS_CLIENT_CA_FILE"), tlsMinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("TLS: %w", err)
+	}
+
+	slowClientPolicy := os.Getenv("SLOW_CLIENT_POLICY")
+	if slowClientPolicy == "" {
+		slowClientPolicy = SlowClientDropOldest
+	}
 
 	var dsn string
 
@@ -74,19 +263,45 @@ func New(log *logrus.Logger, path string) (*Config, error) {
 		return nil, errMissingAppPort
 	case tcpPort == "":
 		return nil, errMissingTCPPort
-	case jwtSecret == "" || len(signingKey) == 0:
+	case signingKeys == nil:
 		return nil, errMissingJwtSecret
+	case slowClientPolicy != SlowClientDropOldest && slowClientPolicy != SlowClientDisconnect:
+		return nil, errInvalidSlowClientPolicy
 	default:
 		hostPort := net.JoinHostPort(postgresHost, postgresPort)
 		dsn = fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
 			postgresUser, postgresPassword, hostPort, postgresDB)
 
 		return &Config{
-			DatabaseURL: dsn,
-			AppPort:     appPort,
-			AppHost:     appHost,
-			TCPPort:     tcpPort,
-			SigningKey:  signingKey,
+			DatabaseURL:        dsn,
+			AppPort:            appPort,
+			AppHost:            appHost,
+			TCPPort:            tcpPort,
+			HistoryReplayLimit: historyReplayLimit,
+			OutboundQueueSize:  max(outboundQueueSize, 1),
+			SlowClientPolicy:   slowClientPolicy,
+			WriteTimeout:       writeTimeout,
+			ResumeWindow:       resumeWindow,
+			ResumeBufferSize:   resumeBufferSize,
+			TypingTimeout:      typingTimeout,
+
+			AttachmentsDir:         attachmentsDir,
+			MaxAttachmentSize:      int64(maxAttachmentSize),
+			AllowedAttachmentTypes: allowedAttachmentTypes,
+
+			AdminUsers: adminUsers,
+
+			AccessTokenTTL:  accessTokenTTL,
+			RefreshTokenTTL: refreshTokenTTL,
+
+			SigningKeys:           signingKeys,
+			JWTKeysReloadInterval: keysReloadInterval,
+			JWTKeyRotation:        jwtkeys.Rotation{Interval: keyRotationInterval, Retention: accessTokenTTL},
+
+			SessionCheckInterval: sessionCheckInterval,
+			ReauthWindow:         reauthWindow,
+
+			TLSConfig: tlsConfig,
 		}, nil
 	}
 }
@@ -110,17 +325,41 @@ func NewClientConfig(log *logrus.Logger, path string) (*Config, error) {
 		return nil, errTCPPort
 	}
 
+	tlsMinVersion, err := tlsMinVersionFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// The client trusts only the CA in TLS_CA_FILE and presents its own certificate when the server asks for one.
+	tlsConfig, err := clientTLSConfig(os.Getenv("TLS_CA_FILE"), os.Getenv("TLS_CLIENT_CERT_FILE"),
+		os.Getenv("TLS_CLIENT_KEY_FILE"), tlsMinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("TLS: %w", err)
+	}
+
+	scheme := "http://"
+	if tlsConfig != nil {
+		tlsConfig.ServerName = serverHost
+		scheme = "https://"
+	}
+
 	const (
 		userPath         = "/api/v1/user"
 		loginEndpoint    = "/login"
 		registerEndpoint = "/register"
+		refreshEndpoint  = "/refresh"
+		logoutEndpoint   = "/logout"
+		attachmentsPath  = "/api/v1/attachments"
 	)
 
 	tcpServerAddr := serverHost + ":" + tcpPort
 	httpServerAddr := serverHost + ":" + httpPort
-	httpServerURL := "http://" + httpServerAddr + userPath
+	httpServerURL := scheme + httpServerAddr + userPath
 	loginURL := httpServerURL + loginEndpoint
 	registerURL := httpServerURL + registerEndpoint
+	refreshURL := httpServerURL + refreshEndpoint
+	logoutURL := httpServerURL + logoutEndpoint
+	attachmentsURL := scheme + httpServerAddr + attachmentsPath
 
 	return &Config{
 		ServerHost:     serverHost,
@@ -131,5 +370,57 @@ func NewClientConfig(log *logrus.Logger, path string) (*Config, error) {
 		HTTPServerURL:  httpServerURL,
 		LoginURL:       loginURL,
 		RegisterURL:    registerURL,
+		RefreshURL:     refreshURL,
+		LogoutURL:      logoutURL,
+		AttachmentsURL: attachmentsURL,
+		TLSConfig:      tlsConfig,
 	}, nil
 }
+
+// intFromEnv reads an optional non-negative integer, falling back to def when the variable is unset.
+func intFromEnv(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s=%q: %w", key, value, errNotANumber)
+	}
+
+	return n, nil
+}
+
+// listFromEnv reads an optional comma separated list, falling back to def when the variable is unset.
+func listFromEnv(key string, def []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// durationFromEnv reads an optional positive duration such as "10s", falling back to def when the variable is unset.
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s=%q: %w", key, value, errNotADuration)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	errTLSKeyPair       = errors.New("tlsCertFile and tlsKeyFile must be set together")
+	errInvalidTLSVer    = errors.New("tlsMinVersion must be 1.2 or 1.3")
+	errNoCACertificates = errors.New("no PEM certificates found")
+)
+
+const defaultTLSMinVersion = tls.VersionTLS12
+
+// tlsVersions are the accepted values of TLS_MIN_VERSION, older versions are not offered.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsMinVersionFromEnv reads TLS_MIN_VERSION, falling back to TLS 1.2 when the variable is unset.
+func tlsMinVersionFromEnv() (uint16, error) {
+	value := os.Getenv("TLS_MIN_VERSION")
+	if value == "" {
+		return defaultTLSMinVersion, nil
+	}
+
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("TLS_MIN_VERSION=%q: %w", value, errInvalidTLSVer)
+	}
+
+	return version, nil
+}
+
+// serverTLSConfig builds the TLS configuration shared by the chat and HTTP listeners, nil when no certificate
+// is set and the servers run in cleartext. With a client CA, clients must present a certificate it signed.
+func serverTLSConfig(certFile, keyFile, clientCAFile string, minVersion uint16) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil //nolint:nilnil // TLS is disabled.
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, errTLSKeyPair
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("serverTLSConfig tls.LoadX509KeyPair(...): %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}
+
+	if clientCAFile != "" {
+		pool, err := certPool(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("serverTLSConfig client CA: %w", err)
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// clientTLSConfig builds the client side TLS configuration, nil when no CA is set and the client speaks
+// cleartext. Only servers with a certificate signed by the pinned CA are trusted, the system roots are not.
+func clientTLSConfig(caFile, certFile, keyFile string, minVersion uint16) (*tls.Config, error) {
+	if caFile == "" {
+		return nil, nil //nolint:nilnil // TLS is disabled.
+	}
+
+	pool, err := certPool(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("clientTLSConfig CA: %w", err)
+	}
+
+	cfg := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: minVersion,
+	}
+
+	switch {
+	case certFile == "" && keyFile == "":
+	case certFile == "" || keyFile == "":
+		return nil, errTLSKeyPair
+	default:
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("clientTLSConfig tls.LoadX509KeyPair(...): %w", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// certPool reads the PEM certificates of a CA file.
+func certPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile(...): %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: %w", path, errNoCACertificates)
+	}
+
+	return pool, nil
+}
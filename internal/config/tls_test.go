@@ -0,0 +1,215 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA signs the certificates of the test, written as PEM files into dir.
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{dir: dir, cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+
+	return ca
+}
+
+// issue writes a certificate for localhost and its key, returning their paths.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(ca.dir, name+".crt")
+	keyFile := filepath.Join(ca.dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+}
+
+// handshake runs a TLS handshake between both configurations over a loopback connection.
+func handshake(t *testing.T, serverConfig, clientConfig *tls.Config) (error, error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+
+			return
+		}
+
+		defer conn.Close()
+
+		serverErr <- tls.Server(conn, serverConfig).Handshake()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	clientErr := tls.Client(conn, clientConfig).Handshake()
+
+	return <-serverErr, clientErr
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+
+	serverConfig, err := serverTLSConfig(serverCert, serverKey, "", tls.VersionTLS12)
+	require.NoError(t, err)
+
+	clientConfig, err := clientTLSConfig(ca.file, "", "", tls.VersionTLS12)
+	require.NoError(t, err)
+	clientConfig.ServerName = "localhost"
+
+	serverErr, clientErr := handshake(t, serverConfig, clientConfig)
+	require.NoError(t, serverErr)
+	require.NoError(t, clientErr, "a client pinning the CA should trust the server")
+
+	other := newTestCA(t, dir, "other")
+	otherConfig, err := clientTLSConfig(other.file, "", "", tls.VersionTLS12)
+	require.NoError(t, err)
+	otherConfig.ServerName = "localhost"
+
+	_, clientErr = handshake(t, serverConfig, otherConfig)
+	assert.Error(t, clientErr, "a client pinning another CA should not trust the server")
+}
+
+func TestTLSConfigClientCA(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+
+	serverConfig, err := serverTLSConfig(serverCert, serverKey, ca.file, tls.VersionTLS12)
+	require.NoError(t, err)
+
+	withCert, err := clientTLSConfig(ca.file, clientCert, clientKey, tls.VersionTLS12)
+	require.NoError(t, err)
+	withCert.ServerName = "localhost"
+
+	serverErr, clientErr := handshake(t, serverConfig, withCert)
+	require.NoError(t, clientErr)
+	require.NoError(t, serverErr, "a client with a certificate signed by the client CA should be accepted")
+
+	withoutCert, err := clientTLSConfig(ca.file, "", "", tls.VersionTLS12)
+	require.NoError(t, err)
+	withoutCert.ServerName = "localhost"
+
+	serverErr, _ = handshake(t, serverConfig, withoutCert)
+	assert.Error(t, serverErr, "a client without a certificate should be rejected")
+}
+
+func TestTLSConfigDisabled(t *testing.T) {
+	t.Parallel()
+
+	serverConfig, err := serverTLSConfig("", "", "", tls.VersionTLS12)
+	require.NoError(t, err)
+	assert.Nil(t, serverConfig, "without a certificate the server should run in cleartext")
+
+	clientConfig, err := clientTLSConfig("", "", "", tls.VersionTLS12)
+	require.NoError(t, err)
+	assert.Nil(t, clientConfig, "without a CA the client should speak cleartext")
+
+	_, err = serverTLSConfig("server.crt", "", "", tls.VersionTLS12)
+	assert.ErrorIs(t, err, errTLSKeyPair, "a certificate without its key should be rejected")
+}
+
+func TestTLSMinVersionFromEnv(t *testing.T) {
+	t.Setenv("TLS_MIN_VERSION", "")
+
+	version, err := tlsMinVersionFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version, "TLS 1.2 should be the default")
+
+	t.Setenv("TLS_MIN_VERSION", "1.3")
+
+	version, err = tlsMinVersionFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+
+	t.Setenv("TLS_MIN_VERSION", "1.0")
+
+	_, err = tlsMinVersionFromEnv()
+	assert.ErrorIs(t, err, errInvalidTLSVer, "versions older than 1.2 should be rejected")
+}
@@ -0,0 +1,42 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+const keyUseSignature = "sig"
+
+// JWKS returns the public keys tokens are verified with, including those not signing yet.
+// A shared HMAC secret is never published, the set is empty with it.
+func (k *Keyring) JWKS() models.JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := models.JWKS{Keys: []models.JWK{}}
+
+	for _, key := range k.keys {
+		jwk := models.JWK{Kid: key.ID, Use: keyUseSignature, Alg: key.Method.Alg()}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
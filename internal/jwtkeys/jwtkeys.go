@@ -0,0 +1,453 @@
+// Package jwtkeys holds the keys access tokens are signed and verified with.
+package jwtkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNoKeys         = errors.New("no signing keys")
+	ErrUnknownKey     = errors.New("token signed with an unknown key")
+	ErrKeyAlgorithm   = errors.New("token algorithm does not match its key")
+	ErrUnsupportedKey = errors.New("unsupported key, expected a PEM encoded RSA or Ed25519 private key")
+	ErrWeakKey        = errors.New("RSA keys must have at least 2048 bits")
+)
+
+const (
+	keyFileExt    = ".pem"
+	minRSAKeyBits = 2048
+
+	// rotatedKeyID names the keys generated by a Rotation after when they were made, in UTC.
+	rotatedKeyID = "20060102T150405Z"
+)
+
+// Rotation generates a new signing key every Interval, it takes over once the activation delay has passed.
+// The keys it generated are removed Retention after they stopped signing, when their tokens have expired.
+// A zero Interval leaves rotation to the operator.
+type Rotation struct {
+	Interval  time.Duration
+	Retention time.Duration
+}
+
+// Key is one signing key, the tokens it signs name it by ID in their kid header.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// PublishedAt is when the keyring first saw the key, it only signs once verifiers had time to learn it.
+	PublishedAt time.Time
+
+	private any
+	public  any
+}
+
+// Keyring is the set of keys tokens are verified with, one of them signing new tokens.
+//
+// Asymmetric keys are read from the PEM files of a directory, named after their kid, and reloaded
+// by Run: a key dropped into the directory is published in the JWKS right away and takes over signing
+// once the keyring has seen it for the activation delay, a key removed from it no longer verifies.
+// File times are not trusted, copies often keep old ones. Keys found by the first load are all seen
+// at once, the one whose kid sorts last is taken as the newest. Run also drops new keys in itself
+// when given a Rotation schedule.
+type Keyring struct {
+	dir             string
+	activationDelay time.Duration
+	log             *logrus.Logger
+
+	mu   sync.RWMutex
+	keys []*Key // oldest first
+}
+
+// NewSecret returns a keyring signing with a shared HMAC secret, which is never published.
+func NewSecret(secret []byte) *Keyring {
+	return &Keyring{keys: []*Key{{Method: jwt.SigningMethodHS256, private: secret, public: secret}}}
+}
+
+// Load reads the RSA (RS256) and Ed25519 (EdDSA) private keys of dir, "<kid>.pem" files
+// holding a PKCS#8 or, for RSA, a PKCS#1 key. Unlike Reload, it fails on any unreadable key.
+func Load(dir string, activationDelay time.Duration, log *logrus.Logger) (*Keyring, error) {
+	k := &Keyring{dir: dir, activationDelay: activationDelay, log: log}
+
+	keys, failures, err := readKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(failures) > 0 {
+		names := make([]string, 0, len(failures))
+		for name := range failures {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		return nil, fmt.Errorf("jwtkeys key %s: %w", names[0], failures[names[0]])
+	}
+
+	k.update(keys, time.Now())
+
+	return k, nil
+}
+
+// Reload replaces the keys with those on disk. A key file that cannot be read is skipped and logged,
+// the key loaded from it before, if any, stays. The current keys stay when no key can be read.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+
+	keys, failures, err := readKeys(k.dir)
+	if err != nil {
+		return err
+	}
+
+	if len(failures) > 0 {
+		k.mu.RLock()
+		for name, err := range failures {
+			if k.log != nil {
+				k.log.WithError(err).Errorf("Skipping JWT signing key %s", name)
+			}
+
+			for _, key := range k.keys {
+				if key.ID+keyFileExt == name {
+					keys = append(keys, key)
+				}
+			}
+		}
+		k.mu.RUnlock()
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("jwtkeys Reload %s: %w", k.dir, ErrNoKeys)
+	}
+
+	k.update(keys, time.Now())
+
+	return nil
+}
+
+// update replaces the keys, those already known keep when they were first seen, new ones are seen at now.
+func (k *Keyring) update(keys []*Key, now time.Time) {
+	k.mu.Lock()
+
+	seen := make(map[string]time.Time, len(k.keys))
+	for _, key := range k.keys {
+		seen[key.ID] = key.PublishedAt
+	}
+
+	for _, key := range keys {
+		key.PublishedAt = now
+		if publishedAt, ok := seen[key.ID]; ok {
+			key.PublishedAt = publishedAt
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].PublishedAt.Equal(keys[j].PublishedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+
+		return keys[i].PublishedAt.Before(keys[j].PublishedAt)
+	})
+
+	changed := !slices.Equal(keyIDs(k.keys), keyIDs(keys))
+	k.keys = keys
+
+	k.mu.Unlock()
+
+	if changed && k.log != nil {
+		k.log.Infof("Loaded JWT signing keys: %s", strings.Join(keyIDs(keys), ", "))
+	}
+}
+
+// Run reloads the keys every interval until ctx is done, rotating them when the rotation has an interval.
+// A failed reload or rotation is logged and retried.
+func (k *Keyring) Run(ctx context.Context, interval time.Duration, rotation Rotation) {
+	if k.dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Reload(); err != nil {
+				k.log.WithError(err).Error("Failed to reload JWT signing keys, keeping the current ones")
+
+				continue
+			}
+
+			if rotation.Interval > 0 {
+				if err := k.rotate(time.Now(), rotation); err != nil {
+					k.log.WithError(err).Error("Failed to rotate JWT signing keys")
+				}
+			}
+		}
+	}
+}
+
+// rotate removes the generated keys whose retention has passed and generates a new key once the newest one
+// is Interval old. A generated key is as old as its kid says, so restarts do not put rotation off.
+func (k *Keyring) rotate(now time.Time, rotation Rotation) error {
+	k.mu.RLock()
+	newest := k.keys[len(k.keys)-1]
+	expired := k.expired(now, rotation.Retention)
+	k.mu.RUnlock()
+
+	for _, key := range expired {
+		if err := os.Remove(filepath.Join(k.dir, key.ID+keyFileExt)); err != nil {
+			return fmt.Errorf("jwtkeys rotate os.Remove(...): %w", err)
+		}
+	}
+
+	createdAt, err := time.Parse(rotatedKeyID, newest.ID)
+	if err != nil {
+		createdAt = newest.PublishedAt
+	}
+
+	due := !now.Before(createdAt.Add(rotation.Interval))
+	if due {
+		if err := generateKey(k.dir, now.UTC().Format(rotatedKeyID)); err != nil {
+			return err
+		}
+	}
+
+	if len(expired) == 0 && !due {
+		return nil
+	}
+
+	return k.Reload()
+}
+
+// expired returns the generated keys that stopped signing at least retention before now. A key stops signing
+// when the next one takes over, the current signing key is never returned. The caller holds k.mu.
+func (k *Keyring) expired(now time.Time, retention time.Duration) []*Key {
+	var keys []*Key
+
+	for i, key := range k.keys[:len(k.keys)-1] {
+		if _, err := time.Parse(rotatedKeyID, key.ID); err != nil {
+			continue
+		}
+
+		stoppedAt := k.keys[i+1].PublishedAt.Add(k.activationDelay)
+		if !now.Before(stoppedAt.Add(retention)) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Sign signs the claims with the current signing key, naming it in the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key, err := k.signing(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("jwtkeys Sign token.SignedString(...): %w", err)
+	}
+
+	return signed, nil
+}
+
+// Keyfunc is the jwt.Keyfunc verifying tokens against the key named by their kid header,
+// refusing those whose algorithm is not the one of the key.
+func (k *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("%w: %s token, %s key %q", ErrKeyAlgorithm, token.Method.Alg(), key.Method.Alg(), kid)
+		}
+
+		return key.public, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+// signing picks the newest key published at least the activation delay before now, so services caching
+// the JWKS know a key before it signs, or the oldest key when every key is newer.
+func (k *Keyring) signing(now time.Time) (*Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if !now.Before(k.keys[i].PublishedAt.Add(k.activationDelay)) {
+			return k.keys[i], nil
+		}
+	}
+
+	return k.keys[0], nil
+}
+
+// readKeys reads the key files of dir, returning the keys read and why the others could not be, by file name.
+func readKeys(dir string) ([]*Key, map[string]error, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jwtkeys readKeys os.ReadDir(...): %w", err)
+	}
+
+	var keys []*Key
+
+	failures := make(map[string]error)
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != keyFileExt {
+			continue
+		}
+
+		key, err := readKey(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			failures[entry.Name()] = err
+
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 && len(failures) == 0 {
+		return nil, nil, fmt.Errorf("jwtkeys readKeys %s: %w", dir, ErrNoKeys)
+	}
+
+	return keys, failures, nil
+}
+
+func readKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile(...): %w", err)
+	}
+
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = strings.TrimSuffix(filepath.Base(path), keyFileExt)
+
+	return key, nil
+}
+
+func parsePrivateKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrUnsupportedKey
+	}
+
+	var (
+		private any
+		err     error
+	)
+
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedKey, err)
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return nil, ErrWeakKey
+		}
+
+		return &Key{Method: jwt.SigningMethodRS256, private: private, public: &private.PublicKey}, nil
+	case ed25519.PrivateKey:
+		public, _ := private.Public().(ed25519.PublicKey)
+
+		return &Key{Method: jwt.SigningMethodEdDSA, private: private, public: public}, nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// generateKey writes a new Ed25519 key to dir as "<kid>.pem", through a temporary file so that
+// a reload never reads it half written.
+func generateKey(dir, kid string) error {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("jwtkeys generateKey ed25519.GenerateKey(...): %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return fmt.Errorf("jwtkeys generateKey x509.MarshalPKCS8PrivateKey(...): %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".generate-*")
+	if err != nil {
+		return fmt.Errorf("jwtkeys generateKey os.CreateTemp(...): %w", err)
+	}
+
+	defer os.Remove(tmp.Name()) //nolint:errcheck // Gone already after a successful rename.
+
+	if _, err := tmp.Write(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+		tmp.Close() //nolint:errcheck,gosec
+
+		return fmt.Errorf("jwtkeys generateKey tmp.Write(...): %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("jwtkeys generateKey tmp.Close(): %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, kid+keyFileExt)); err != nil {
+		return fmt.Errorf("jwtkeys generateKey os.Rename(...): %w", err)
+	}
+
+	return nil
+}
+
+func keyIDs(keys []*Key) []string {
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+
+	return ids
+}
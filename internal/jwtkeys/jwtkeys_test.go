@@ -0,0 +1,293 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey writes a PKCS#8 private key to dir as "<kid>.pem".
+func writeKey(t *testing.T, dir string, kid string, key any) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, kid+".pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+}
+
+func newRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return key
+}
+
+// keyError returns the error of Keyfunc behind a jwt.Parse failure, jwt.ValidationError does not unwrap.
+func keyError(t *testing.T, err error) error {
+	t.Helper()
+
+	var validation *jwt.ValidationError
+	require.ErrorAs(t, err, &validation)
+
+	return validation.Inner
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"username": "alice1", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-09", newRSAKey(t, 2048))
+
+	keys, err := Load(dir, 10*time.Minute, nil)
+	require.NoError(t, err)
+
+	signed, err := keys.Sign(claims())
+	require.NoError(t, err)
+
+	// A copy keeping an old file time, like cp -p or rsync make, is still new to the keyring.
+	writeKey(t, dir, "2026-10", newEd25519Key(t))
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "2026-10.pem"), old, old))
+	require.NoError(t, keys.Reload())
+
+	now := time.Now()
+
+	key, err := keys.signing(now)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-09", key.ID, "a key seen within the activation delay should not sign yet")
+	assert.Len(t, keys.JWKS().Keys, 2, "a new key should be published at once")
+
+	require.NoError(t, keys.Reload())
+
+	key, err = keys.signing(now.Add(10 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", key.ID, "the newest key should sign once the activation delay passed since it was first seen")
+	assert.Equal(t, "EdDSA", key.Method.Alg())
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "2026-09.pem")))
+	require.NoError(t, keys.Reload())
+
+	_, err = jwt.Parse(signed, keys.Keyfunc)
+	assert.ErrorIs(t, keyError(t, err), ErrUnknownKey, "tokens of a removed key should be refused")
+
+	signed, err = keys.Sign(claims())
+	require.NoError(t, err)
+
+	token, err := jwt.Parse(signed, keys.Keyfunc)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", token.Header["kid"], "the only key left should sign, however new")
+}
+
+func TestKeyringFirstLoad(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-10", newEd25519Key(t))
+	writeKey(t, dir, "2026-09", newRSAKey(t, 2048))
+
+	keys, err := Load(dir, 10*time.Minute, nil)
+	require.NoError(t, err)
+
+	key, err := keys.signing(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "2026-09", key.ID, "keys found together should sign in kid order, the first one first")
+
+	key, err = keys.signing(time.Now().Add(10 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", key.ID)
+}
+
+func TestKeyringScheduledRotation(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-09", newRSAKey(t, 2048))
+
+	keys, err := Load(dir, 10*time.Minute, nil)
+	require.NoError(t, err)
+
+	rotation := Rotation{Interval: 24 * time.Hour, Retention: 15 * time.Minute}
+	now := time.Now()
+
+	require.NoError(t, keys.rotate(now, rotation))
+	assert.Equal(t, []string{"2026-09"}, keyIDs(keys.keys), "no key should be generated before the interval passed")
+
+	day1 := now.Add(24 * time.Hour)
+	require.NoError(t, keys.rotate(day1, rotation))
+
+	generated := day1.UTC().Format(rotatedKeyID)
+	assert.Equal(t, []string{"2026-09", generated}, keyIDs(keys.keys), "a key should be generated once the interval passed")
+
+	key, err := keys.signing(time.Now().Add(10 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, generated, key.ID, "the generated key should sign once the activation delay passed")
+	assert.Equal(t, "EdDSA", key.Method.Alg())
+
+	require.NoError(t, keys.rotate(day1.Add(time.Hour), rotation))
+	assert.Len(t, keys.keys, 2, "the age of a generated key should come from its kid")
+
+	day2 := day1.Add(24 * time.Hour)
+	require.NoError(t, keys.rotate(day2, rotation))
+	require.NoError(t, keys.rotate(time.Now().Add(25*time.Minute), rotation))
+	assert.Equal(t, []string{"2026-09", day2.UTC().Format(rotatedKeyID)}, keyIDs(keys.keys),
+		"a generated key should be removed once its tokens expired, keys dropped in by hand should stay")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary file should be left behind")
+}
+
+func TestKeyfuncRefusesOtherAlgorithms(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	private := newRSAKey(t, 2048)
+	writeKey(t, dir, "k1", private)
+
+	keys, err := Load(dir, time.Minute, nil)
+	require.NoError(t, err)
+
+	// The public key is no secret: an HMAC token keyed with it must not pass for an RS256 one.
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	require.NoError(t, err)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = "k1"
+	forgedString, err := forged.SignedString(public)
+	require.NoError(t, err)
+
+	_, err = jwt.Parse(forgedString, keys.Keyfunc)
+	assert.ErrorIs(t, keyError(t, err), ErrKeyAlgorithm)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	_, err = jwt.Parse(unsigned, keys.Keyfunc)
+	assert.Error(t, err, "unsigned tokens should be refused")
+}
+
+func TestJWKS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rsaKey := newRSAKey(t, 2048)
+	edKey := newEd25519Key(t)
+
+	writeKey(t, dir, "2026-09", rsaKey)
+	writeKey(t, dir, "2026-10", edKey)
+
+	keys, err := Load(dir, time.Hour, nil)
+	require.NoError(t, err)
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 2, "keys not signing yet should be published too")
+
+	rsaJWK, edJWK := set.Keys[0], set.Keys[1]
+
+	assert.Equal(t, "2026-09", rsaJWK.Kid)
+	assert.Equal(t, "RSA", rsaJWK.Kty)
+	assert.Equal(t, "RS256", rsaJWK.Alg)
+	assert.Equal(t, "sig", rsaJWK.Use)
+
+	n, err := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(rsaJWK.E)
+	require.NoError(t, err)
+
+	// A verifier rebuilding the key from the JWKS accepts our tokens.
+	signed, err := keys.Sign(claims())
+	require.NoError(t, err)
+
+	published := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	_, err = jwt.Parse(signed, func(*jwt.Token) (any, error) { return published, nil })
+	require.NoError(t, err)
+
+	assert.Equal(t, "2026-10", edJWK.Kid)
+	assert.Equal(t, "OKP", edJWK.Kty)
+	assert.Equal(t, "Ed25519", edJWK.Crv)
+	assert.Equal(t, "EdDSA", edJWK.Alg)
+
+	x, err := base64.RawURLEncoding.DecodeString(edJWK.X)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(edKey.Public().(ed25519.PublicKey)), x)
+
+	assert.Empty(t, NewSecret([]byte("secret")).JWKS().Keys, "a shared secret should never be published")
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	empty := t.TempDir()
+	_, err := Load(empty, time.Minute, nil)
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	weak := t.TempDir()
+	writeKey(t, weak, "weak", newRSAKey(t, 1024))
+	_, err = Load(weak, time.Minute, nil)
+	assert.ErrorIs(t, err, ErrWeakKey)
+
+	garbage := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(garbage, "k1.pem"), []byte("not a key"), 0o600))
+	_, err = Load(garbage, time.Minute, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedKey)
+}
+
+func TestReloadSkipsBadKeys(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeKey(t, dir, "k1", newEd25519Key(t))
+
+	keys, err := Load(dir, time.Minute, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k2.pem"), []byte("not a key"), 0o600))
+	writeKey(t, dir, "k3", newEd25519Key(t))
+	require.NoError(t, keys.Reload(), "a bad key file should not stop the rotation")
+	assert.Equal(t, []string{"k1", "k3"}, keyIDs(keys.keys))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.pem"), []byte("half written"), 0o600))
+	require.NoError(t, keys.Reload())
+	assert.Equal(t, []string{"k1", "k3"}, keyIDs(keys.keys), "a key whose file turned bad should be kept")
+
+	signed, err := keys.Sign(claims())
+	require.NoError(t, err)
+
+	token, err := jwt.Parse(signed, keys.Keyfunc)
+	require.NoError(t, err)
+	assert.Equal(t, "k1", token.Header["kid"])
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "k1.pem")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "k3.pem")))
+	require.Error(t, keys.Reload(), "the keys should stay when none can be read")
+	assert.Equal(t, []string{"k1", "k3"}, keyIDs(keys.keys))
+}
@@ -23,7 +23,7 @@ func JWTAuthMiddleware(authService service.AuthServiceInterface) func(http.Handl
 				return
 			}
 
-			token, err := authService.ValidateToken(tokenString)
+			token, err := authService.ValidateToken(r.Context(), tokenString)
 			if err != nil {
 				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 
@@ -39,3 +39,27 @@ func JWTAuthMiddleware(authService service.AuthServiceInterface) func(http.Handl
 		})
 	}
 }
+
+// UsernameFromContext returns the username claim of a request authenticated by JWTAuthMiddleware.
+func UsernameFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ctx.Value(userClaimsKey).(jwt.MapClaims)
+	if !ok {
+		return "", false
+	}
+
+	username, ok := claims["username"].(string)
+
+	return username, ok && username != ""
+}
+
+// TokenIDFromContext returns the jti claim of a request authenticated by JWTAuthMiddleware.
+func TokenIDFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ctx.Value(userClaimsKey).(jwt.MapClaims)
+	if !ok {
+		return "", false
+	}
+
+	jti, ok := claims["jti"].(string)
+
+	return jti, ok && jti != ""
+}
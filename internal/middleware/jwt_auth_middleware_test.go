@@ -16,7 +16,7 @@ type MockAuthService struct {
 	mock.Mock
 }
 
-func (m *MockAuthService) ValidateToken(tokenString string) (*jwt.Token, error) {
+func (m *MockAuthService) ValidateToken(ctx context.Context, tokenString string) (*jwt.Token, error) {
 	args := m.Called(tokenString)
 	var token *jwt.Token
 	if args.Get(0) != nil {
@@ -25,13 +25,27 @@ func (m *MockAuthService) ValidateToken(tokenString string) (*jwt.Token, error)
 	return token, args.Error(1)
 }
 
-func (m *MockAuthService) LoginUser(ctx context.Context, input models.UserLoginInput) (string, error) {
+func (m *MockAuthService) LoginUser(ctx context.Context, input models.UserLoginInput) (*models.TokenPair, error) {
 	args := m.Called(input)
-	return args.String(0), args.Error(1)
+	return args.Get(0).(*models.TokenPair), args.Error(1)
 }
 
-func (m *MockAuthService) GenerateToken(username string) (string, error) {
-	return m.Called(username).Get(0).(string), m.Called(username).Error(1)
+func (m *MockAuthService) GenerateToken(ctx context.Context, username string) (*models.TokenPair, error) {
+	args := m.Called(username)
+	return args.Get(0).(*models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthService) RefreshToken(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	args := m.Called(refreshToken)
+	return args.Get(0).(*models.TokenPair), args.Error(1)
+}
+
+func (m *MockAuthService) Logout(ctx context.Context, username string, jti string, allSessions bool) error {
+	return m.Called(username, jti, allSessions).Error(0)
+}
+
+func (m *MockAuthService) CheckSession(ctx context.Context, username string, jti string) error {
+	return m.Called(username, jti).Error(0)
 }
 
 func (m *MockAuthService) VerifyPassword(storedHash, providedPassword string) bool {
@@ -42,6 +56,10 @@ func (m *MockAuthService) GetUserByUsername(ctx context.Context, username string
 	return m.Called(ctx, username).Get(0).(*models.User), m.Called(ctx, username).Error(1)
 }
 
+func (m *MockAuthService) JWKS() models.JWKS {
+	return m.Called().Get(0).(models.JWKS)
+}
+
 func TestJWTAuthMiddleware(t *testing.T) {
 	authService := new(MockAuthService)
 	middleware := JWTAuthMiddleware(authService)
@@ -69,6 +87,14 @@ func TestJWTAuthMiddleware(t *testing.T) {
 			},
 			expectedStatus: http.StatusUnauthorized,
 		},
+		{
+			name:  "Revoked token",
+			token: "revokedtoken",
+			prepareMock: func() {
+				authService.On("ValidateToken", "revokedtoken").Return(nil, models.ErrTokenRevoked)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name:  "Valid token",
 			token: "validtoken",
@@ -107,3 +133,25 @@ func TestJWTAuthMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenIDFromContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), userClaimsKey, jwt.MapClaims{"username": "user123", "jti": "abc"})
+
+	jti, ok := TokenIDFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "abc", jti)
+
+	_, ok = TokenIDFromContext(context.WithValue(context.Background(), userClaimsKey, jwt.MapClaims{}))
+	assert.False(t, ok)
+}
+
+func TestUsernameFromContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), userClaimsKey, jwt.MapClaims{"username": "user123"})
+
+	username, ok := UsernameFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "user123", username)
+
+	_, ok = UsernameFromContext(context.Background())
+	assert.False(t, ok)
+}
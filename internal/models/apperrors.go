@@ -38,6 +38,49 @@ var (
 
 	ErrUsernameClaimIsNotString = errors.New("username claim is not a string")
 	ErrWrongStatusCode          = errors.New("wrong status code")
+	ErrNotConnected             = errors.New("not connected to the chat server")
 	ErrRoomNotExists            = errors.New("room not exists")
+	ErrRoomExists               = errors.New("room already exists")
+	ErrInvalidRoomName          = errors.New("room name must be 1-32 letters, digits, '-' or '_'")
+	ErrNotRoomMember            = errors.New("not a member of the room")
+	ErrAlreadyRoomMember        = errors.New("already a member of the room")
+	ErrRoomPrivate              = errors.New("room is private, it can only be joined by invitation")
+	ErrNotRoomModerator         = errors.New("only room owners and moderators can do this")
+	ErrNotRoomOwner             = errors.New("only the room owner can do this")
+	ErrInvalidRole              = errors.New("role must be moderator or member")
+	ErrInvitationNotFound       = errors.New("invitation not found")
+	ErrBannedFromRoom           = errors.New("banned from the room")
+	ErrMutedInRoom              = errors.New("muted in the room")
+	ErrCannotModerate           = errors.New("cannot moderate this user")
+	ErrInvalidDuration          = errors.New("duration must be positive, like 30m or 24h")
+	ErrNotBanned                = errors.New("user is not banned from the room")
+	ErrNotMuted                 = errors.New("user is not muted in the room")
+	ErrUnknownCommand           = errors.New("unknown command")
+	ErrEmptyMessage             = errors.New("message content is empty")
+	ErrMessageTooLong           = errors.New("message content is too long")
+	ErrInvalidCursor            = errors.New("invalid history cursor")
+	ErrHistoryLimitTooLarge     = errors.New("history limit exceeded maximum")
+	ErrInvalidHistoryLimit      = errors.New("invalid history limit")
+	ErrSlowClient               = errors.New("client is too slow, outbound queue is full")
+	ErrUnauthorized             = errors.New("unauthorized")
+	ErrInvalidCommandArgs       = errors.New("invalid command arguments")
+	ErrInvalidClientID          = errors.New("client message ID is too long")
+	ErrDuplicateMessage         = errors.New("message with this client ID already exists")
+	ErrInvalidMessageID         = errors.New("invalid message ID")
+	ErrMessageNotFound          = errors.New("message not found")
+	ErrMessageEditForbidden     = errors.New("only the sender can edit the message")
+	ErrMessageDeleteForbidden   = errors.New("only the sender or a room moderator can delete the message")
+	ErrInvalidReaction          = errors.New("reaction must be 1-32 bytes without spaces")
+	ErrInvalidReply             = errors.New("a reply must be sent where the message it answers was")
+	ErrAttachmentNotFound       = errors.New("attachment not found")
+	ErrEmptyAttachment          = errors.New("attachment is empty")
+	ErrAttachmentTooLarge       = errors.New("attachment is too large")
+	ErrAttachmentTypeNotAllowed = errors.New("attachment type is not allowed")
+	ErrTooManyAttachments       = errors.New("too many attachments")
+	ErrUnexpectedFrame          = errors.New("unexpected frame type")
+	ErrServerOwnedField         = errors.New("fields set by the server must not be sent")
 	ErrInvalidURL               = errors.New("invalid url")
+	ErrTokenRevoked             = errors.New("token has been revoked")
+	ErrTokenExpired             = errors.New("token has expired, reconnect with a fresh one")
+	ErrInvalidRefreshToken      = errors.New("refresh token is invalid, expired or already used")
 )
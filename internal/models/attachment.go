@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Attachment is a file uploaded over HTTP. A message references it by ID until it is sent,
+// then it is linked to that message (MessageID) and only its recipients can download it.
+type Attachment struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	MIMEType  string    `json:"mimeType,omitempty"`
+	Uploader  string    `json:"uploader,omitempty"`
+	MessageID int       `json:"messageId,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
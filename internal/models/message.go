@@ -2,11 +2,52 @@ package models
 
 import "time"
 
+// Message is a room or direct message. An edited message carries the time of its last edit,
+// a deleted one stays in the history without its content. A reply points at the message it answers
+// (ReplyTo, previewed in Parent) and at the first message of its thread (ThreadID).
+// A message with Attachments may have no content.
 type Message struct {
-	ID        int       `json:"id,omitempty"`
-	Receiver  string    `json:"receiver,omitempty"`
-	Sender    string    `json:"sender"`
-	Content   string    `json:"content"`
-	Error     string    `json:"error,omitempty"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID          int             `json:"id,omitempty"`
+	ClientID    string          `json:"clientId,omitempty"`
+	Room        string          `json:"room,omitempty"`
+	Receiver    string          `json:"receiver,omitempty"`
+	Sender      string          `json:"sender"`
+	Content     string          `json:"content"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	EditedAt    *time.Time      `json:"editedAt,omitempty"`
+	Deleted     bool            `json:"deleted,omitempty"`
+	ReplyTo     int             `json:"replyTo,omitempty"`
+	ThreadID    int             `json:"threadId,omitempty"`
+	Parent      *MessagePreview `json:"parent,omitempty"`
+	Reactions   []ReactionCount `json:"reactions,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	History     bool            `json:"history,omitempty"`
+}
+
+// MessagePreview is a snippet of the message a reply answers.
+type MessagePreview struct {
+	Sender  string `json:"sender"`
+	Content string `json:"content"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Thread is the first message of a thread with a page of its replies, oldest first.
+type Thread struct {
+	Root       Message   `json:"root"`
+	Replies    []Message `json:"replies"`
+	NextCursor int       `json:"nextCursor,omitempty"`
+}
+
+// HistoryRequest selects a page of messages older than BeforeID (the latest ones when it is 0),
+// optionally matching the full-text Query.
+type HistoryRequest struct {
+	BeforeID int    `json:"before"`
+	Limit    int    `json:"limit"`
+	Query    string `json:"q"`
+}
+
+type HistoryPage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor int       `json:"nextCursor,omitempty"`
 }
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Moderation actions, bans and mutes last until they expire or are lifted.
+const (
+	ActionKick   = "kick"
+	ActionBan    = "ban"
+	ActionUnban  = "unban"
+	ActionMute   = "mute"
+	ActionUnmute = "unmute"
+)
+
+// Kinds of sanctions in force against a user in a room.
+const (
+	SanctionBan  = "ban"
+	SanctionMute = "mute"
+)
+
+// ModerationAction is an entry of the audit trail of a room.
+type ModerationAction struct {
+	ID        int        `json:"id"`
+	Room      string     `json:"room"`
+	Action    string     `json:"action"`
+	Moderator string     `json:"moderator"`
+	Target    string     `json:"target"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Sanction is a ban or a mute in force, without ExpiresAt it lasts until it is lifted.
+type Sanction struct {
+	Room      string     `json:"room"`
+	Username  string     `json:"username"`
+	Kind      string     `json:"kind"`
+	Moderator string     `json:"moderator"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// BanRequest is the body of POST /admin/rooms/{room}/bans, the duration is like "24h", permanent when empty.
+type BanRequest struct {
+	Username string `json:"username"`
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
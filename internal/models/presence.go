@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Presence states.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// Presence tells whether a user is around, LastSeenAt is set for offline users seen before.
+type Presence struct {
+	Username   string     `json:"username"`
+	State      string     `json:"state"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// ReactionCount sums up one emoji reacted to a message, Users in the order they reacted.
+type ReactionCount struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// Reaction adds (Added) or removes an Emoji reaction of the User to a message. As an event it also
+// carries where the message was sent and the updated reaction summary of the message.
+type Reaction struct {
+	MessageID int             `json:"messageId"`
+	Emoji     string          `json:"emoji"`
+	Added     bool            `json:"added"`
+	User      string          `json:"user,omitempty"`
+	Room      string          `json:"room,omitempty"`
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+}
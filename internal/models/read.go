@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// UnreadCount is the number of unread messages in a Room, or from a direct message Peer.
+type UnreadCount struct {
+	Room       string `json:"room,omitempty"`
+	Peer       string `json:"peer,omitempty"`
+	Unread     int    `json:"unread"`
+	LastReadID int    `json:"lastReadId,omitempty"`
+}
+
+// ReadReceipt tells the sender of direct messages that the Reader read them.
+type ReadReceipt struct {
+	Reader     string    `json:"reader"`
+	MessageIDs []int     `json:"messageIds"`
+	ReadAt     time.Time `json:"readAt"`
+}
+
+// ReadRequest marks a room read up to MessageID, the latest message when it is 0.
+type ReadRequest struct {
+	MessageID int `json:"messageId"`
+}
@@ -1,9 +1,42 @@
 package models
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
+// Roles of the room members. The owner created the room, moderators are appointed by the owner.
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Room is a chat room. A private room can only be joined by accepting an invitation.
 type Room struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Members map[*User]net.Conn
+	ID        int                `db:"room_id" json:"id"`
+	Name      string             `db:"name" json:"name"`
+	Private   bool               `db:"private" json:"private,omitempty"`
+	CreatedAt time.Time          `db:"created_at" json:"createdAt,omitempty"`
+	Members   map[*User]net.Conn `json:"-"`
+	Silenced  map[string]bool    `json:"-"` // usernames of the members who silenced the room
+}
+
+// RoomInvitation invites the Invitee to the Room until it is accepted or declined.
+type RoomInvitation struct {
+	Room      string    `json:"room"`
+	Private   bool      `json:"private,omitempty"`
+	Inviter   string    `json:"inviter"`
+	Invitee   string    `json:"invitee"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// InvitationRequest is the body of POST /rooms/{room}/invitations.
+type InvitationRequest struct {
+	Username string `json:"username"`
+}
+
+// RoleRequest is the body of PUT /rooms/{room}/members/{username}/role.
+type RoleRequest struct {
+	Role string `json:"role"`
 }
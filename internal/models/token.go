@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// TokenPair is issued on login, registration and refresh. The access token is a short-lived JWT,
+// the refresh token is an opaque string exchanged once for a new pair.
+type TokenPair struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// RefreshToken is a stored refresh token, only its hash is kept.
+type RefreshToken struct {
+	Hash            string
+	AccessJTI       string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+}
+
+// RefreshRequest is the body of POST /user/refresh.
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// LogoutRequest is the optional body of POST /user/logout, AllSessions logs out every device of the user.
+type LogoutRequest struct {
+	AllSessions bool `json:"allSessions"`
+}
+
+// JWKS is the JSON Web Key Set served at /.well-known/jwks.json, the public keys access tokens are verified with.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is the public part of a signing key (RFC 7517): N and E are set for RSA keys, Crv and X for Ed25519 ones.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
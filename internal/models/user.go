@@ -6,13 +6,14 @@ import (
 )
 
 type User struct {
-	ID           int       `db:"user_id" json:"id"`
-	UserName     string    `db:"username" json:"username"`
-	HashPassword string    `db:"hashed_password" json:"hashPassword,omitempty"`
-	CreatedAt    time.Time `db:"created_at" json:"createdAt,omitempty"`
-	UpdatedAt    time.Time `db:"updated_at" json:"updatedAt,omitempty"`
-	Deleted      bool      `db:"deleted" json:"deleted,omitempty"`
-	Conn         net.Conn  `json:"-"`
+	ID           int        `db:"user_id" json:"id"`
+	UserName     string     `db:"username" json:"username"`
+	HashPassword string     `db:"hashed_password" json:"hashPassword,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"createdAt,omitempty"`
+	UpdatedAt    time.Time  `db:"updated_at" json:"updatedAt,omitempty"`
+	Deleted      bool       `db:"deleted" json:"deleted,omitempty"`
+	LastSeenAt   *time.Time `db:"last_seen_at" json:"lastSeenAt,omitempty"`
+	Conn         net.Conn   `json:"-"`
 }
 
 type UserResponse struct {
@@ -0,0 +1,259 @@
+// Package protocol defines the chat wire protocol spoken over TCP and WebSocket connections.
+//
+// Every frame is one line of JSON holding an Envelope. A session starts with the client's hello
+// frame listing the protocol versions it speaks (and, over TCP, its token); the server answers
+// with welcome carrying the negotiated version, or with an error frame and closes the connection.
+//
+// Events the server pushes (messages, presence) carry a per-user sequence number. A client reconnecting
+// within the resume window presents the epoch and the last sequence it saw in its hello frame
+// and gets the events it missed instead of the history replay.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+// Version is the newest protocol version this build speaks.
+const Version = 1
+
+// SupportedVersions lists every protocol version this build speaks.
+var SupportedVersions = []int{Version}
+
+// Frame types.
+const (
+	// TypeHello opens a session, client to server, HelloPayload.
+	TypeHello = "hello"
+	// TypeWelcome accepts a session, server to client, WelcomePayload.
+	TypeWelcome = "welcome"
+	// TypeMessage carries a chat message both ways, models.Message.
+	TypeMessage = "message"
+	// TypeCommand asks the server to do something, client to server, CommandPayload.
+	TypeCommand = "command"
+	// TypeAck confirms a client frame with the same ID, server to client, AckPayload.
+	// A message is acknowledged once it is stored and handed to the recipients' connections.
+	TypeAck = "ack"
+	// TypeError rejects a client frame with the same ID or the session, server to client, ErrorPayload.
+	TypeError = "error"
+	// TypePresence reports users joining and leaving or changing state, server to client, PresencePayload.
+	TypePresence = "presence"
+	// TypeSystem is a server notice for humans, server to client, SystemPayload.
+	TypeSystem = "system"
+	// TypeTyping tells that a user is typing to a room or to a direct message partner, both ways, TypingPayload.
+	// It is neither stored, acknowledged nor numbered, the server stops it after a few seconds without a refresh.
+	TypeTyping = "typing"
+	// TypeReceipt tells a direct message sender that the receiver read its messages, server to client,
+	// models.ReadReceipt.
+	TypeReceipt = "receipt"
+	// TypeEdit changes the content of a stored message, both ways, models.Message. Clients send the ID
+	// and the new content of their message, the server pushes the edited message to everyone who received it.
+	TypeEdit = "edit"
+	// TypeDelete retracts a stored message, both ways, models.Message. Clients send the ID of the message,
+	// the server pushes the deleted message without its content to everyone who received it.
+	TypeDelete = "delete"
+	// TypeReaction adds or removes an emoji reaction to a stored message, both ways, models.Reaction.
+	// The server pushes every change with the updated reaction summary to everyone who received the message.
+	TypeReaction = "reaction"
+	// TypeMention tells a user that a room message mentions it by name, @here or @room, server to client,
+	// models.Message. It comes on top of the message itself.
+	TypeMention = "mention"
+	// TypeInvitation invites the user to a room, server to client, models.RoomInvitation.
+	// It is answered with the accept or decline commands.
+	TypeInvitation = "invitation"
+	// TypeReauth renews the token of a session, both ways, ReauthPayload. The server asks for a fresh token
+	// with the expiry of the current one shortly before it lapses, the client answers with the token
+	// and gets an ack. Sessions whose token expires or is revoked end with an unauthorized error frame.
+	TypeReauth = "reauth"
+)
+
+// Error codes of ErrorPayload.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownCommand     = "unknown_command"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeInternal           = "internal"
+)
+
+// Presence events of PresencePayload.
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+	PresenceAway    = "away"
+	PresenceJoined  = "joined"
+	PresenceLeft    = "left"
+)
+
+var (
+	ErrMalformedFrame     = errors.New("malformed frame")
+	ErrUnsupportedVersion = errors.New("no supported protocol version")
+)
+
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type HelloPayload struct {
+	Versions []int          `json:"versions"`
+	Token    string         `json:"token,omitempty"`
+	Resume   *ResumePayload `json:"resume,omitempty"`
+}
+
+// ResumePayload identifies the last event a client saw, Epoch comes from the welcome frame.
+type ResumePayload struct {
+	Epoch   string `json:"epoch"`
+	LastSeq uint64 `json:"lastSeq"`
+}
+
+// WelcomePayload accepts a session. Seq is the sequence of the last event recorded for the user so far;
+// when Resumed is set the events after the client's LastSeq follow, otherwise the history replay does.
+// Unread counts the user's unread messages per room and direct message peer,
+// Invitations lists the room invitations waiting for an answer.
+type WelcomePayload struct {
+	Version int                  `json:"version"`
+	User    string               `json:"user"`
+	Rooms   []string             `json:"rooms"`
+	Epoch   string               `json:"epoch"`
+	Seq     uint64               `json:"seq"`
+	Resumed bool                 `json:"resumed,omitempty"`
+	Unread  []models.UnreadCount `json:"unread,omitempty"`
+
+	Invitations []models.RoomInvitation `json:"invitations,omitempty"`
+}
+
+type CommandPayload struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+}
+
+// AckPayload confirms a command with its Result, or a message with the ID and timestamp it was stored with.
+// Duplicate marks a retried message that was stored and delivered before, Queued a direct message
+// kept for a receiver who is offline.
+type AckPayload struct {
+	Result    string     `json:"result,omitempty"`
+	MessageID int        `json:"messageId,omitempty"`
+	ClientID  string     `json:"clientId,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	Duplicate bool       `json:"duplicate,omitempty"`
+	Queued    bool       `json:"queued,omitempty"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PresencePayload reports a user joining or leaving a room, or changing its state (online, away, offline)
+// to the members of its rooms.
+type PresencePayload struct {
+	User       string     `json:"user"`
+	Room       string     `json:"room,omitempty"`
+	Event      string     `json:"event"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+// TypingPayload targets a Room or a Receiver, User is set by the server.
+type TypingPayload struct {
+	User     string `json:"user,omitempty"`
+	Room     string `json:"room,omitempty"`
+	Receiver string `json:"receiver,omitempty"`
+	Active   bool   `json:"active"`
+}
+
+// ReauthPayload carries the ExpiresAt of the session's token when the server asks for a fresh one,
+// and the fresh Token when the client answers.
+type ReauthPayload struct {
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type SystemPayload struct {
+	Room string `json:"room,omitempty"`
+	Text string `json:"text"`
+}
+
+// Encode builds a newline-terminated frame of the current version.
+func Encode(frameType string, id string, payload any) ([]byte, error) {
+	return encode(Envelope{Version: Version, Type: frameType, ID: id}, payload)
+}
+
+// EncodeEvent builds a newline-terminated event frame carrying the recipient's sequence number.
+func EncodeEvent(frameType string, seq uint64, payload any) ([]byte, error) {
+	return encode(Envelope{Version: Version, Type: frameType, Seq: seq}, payload)
+}
+
+func encode(env Envelope, payload any) ([]byte, error) {
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("protocol encode %s payload: %w", env.Type, err)
+		}
+
+		env.Payload = raw
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("protocol encode %s envelope: %w", env.Type, err)
+	}
+
+	return append(data, '\n'), nil
+}
+
+// Decode parses one frame. Frames without a type are malformed, the version is checked by the caller.
+func Decode(line []byte) (Envelope, error) {
+	var env Envelope
+
+	if err := json.Unmarshal(line, &env); err != nil {
+		return env, fmt.Errorf("%w: %w", ErrMalformedFrame, err)
+	}
+
+	if env.Type == "" {
+		return env, fmt.Errorf("%w: missing type", ErrMalformedFrame)
+	}
+
+	return env, nil
+}
+
+// DecodePayload unmarshals the payload of the frame into v.
+func (e Envelope) DecodePayload(v any) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("%w: %s frame without payload", ErrMalformedFrame, e.Type)
+	}
+
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("%w: %s payload: %w", ErrMalformedFrame, e.Type, err)
+	}
+
+	return nil
+}
+
+// Negotiate picks the newest version spoken by both sides.
+func Negotiate(clientVersions []int) (int, error) {
+	best := 0
+
+	for _, v := range clientVersions {
+		if v > best && slices.Contains(SupportedVersions, v) {
+			best = v
+		}
+	}
+
+	if best == 0 {
+		return 0, fmt.Errorf("%w, client speaks %v, server speaks %v",
+			ErrUnsupportedVersion, clientVersions, SupportedVersions)
+	}
+
+	return best, nil
+}
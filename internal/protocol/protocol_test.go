@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data, err := Encode(TypeCommand, "42", CommandPayload{Name: "join", Args: []string{"backend"}})
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(data, []byte("\n")), "frames should be newline-terminated")
+
+	env, err := Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, Version, env.Version)
+	assert.Equal(t, TypeCommand, env.Type)
+	assert.Equal(t, "42", env.ID)
+
+	var cmd CommandPayload
+	require.NoError(t, env.DecodePayload(&cmd))
+	assert.Equal(t, CommandPayload{Name: "join", Args: []string{"backend"}}, cmd)
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, line := range []string{"not json", `{"v":1}`, "Error parsing message"} {
+		_, err := Decode([]byte(line))
+		assert.ErrorIs(t, err, ErrMalformedFrame, "%q should be malformed", line)
+	}
+
+	env, err := Decode([]byte(`{"v":1,"type":"command"}`))
+	require.NoError(t, err)
+	assert.ErrorIs(t, env.DecodePayload(&CommandPayload{}), ErrMalformedFrame, "missing payload should be malformed")
+}
+
+func TestNegotiate(t *testing.T) {
+	version, err := Negotiate([]int{Version + 1, Version})
+	require.NoError(t, err)
+	assert.Equal(t, Version, version, "the newest common version should win")
+
+	_, err = Negotiate([]int{Version + 1})
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, err = Negotiate(nil)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
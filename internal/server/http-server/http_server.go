@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/app/handler"
 	"github.com/stsolovey/kvant_chat/internal/app/service"
 	"github.com/stsolovey/kvant_chat/internal/config"
 )
@@ -34,13 +36,21 @@ func CreateServer(
 	log *logrus.Logger,
 	usersServ service.UsersServiceInterface,
 	authServ service.AuthServiceInterface,
+	messagesServ service.MessagesServiceInterface,
+	attachmentsServ service.AttachmentsServiceInterface,
+	roomsServ service.RoomsServiceInterface,
+	moderationServ service.ModerationServiceInterface,
+	chatSessions handler.ChatSessionServer,
+	presence handler.PresenceProvider,
+	roomEvents handler.RoomEventsNotifier,
 ) *Server {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	configureRoutes(r, log, usersServ, authServ)
+	configureRoutes(r, log, usersServ, authServ, messagesServ, attachmentsServ, roomsServ, moderationServ,
+		chatSessions, presence, roomEvents)
 
 	s := &http.Server{
 		Addr:              ":" + cfg.AppPort,
@@ -49,6 +59,7 @@ func CreateServer(
 		ReadTimeout:       readTimeoutDuration,
 		WriteTimeout:      writeTimeoutDuration,
 		IdleTimeout:       idleTimeoutDuration,
+		TLSConfig:         cfg.TLSConfig,
 	}
 
 	return &Server{
@@ -61,6 +72,9 @@ func CreateServer(
 func (s *Server) Start(ctx context.Context) error {
 	s.logger.Info("Starting HTTP server...")
 
+	// Requests, and the WebSocket sessions outliving Shutdown on hijacked connections, end with ctx.
+	s.server.BaseContext = func(net.Listener) context.Context { return ctx }
+
 	go func() {
 		<-ctx.Done()
 		s.logger.Info("HTTP server shutdown initiated.")
@@ -73,6 +87,17 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}()
 
+	if s.server.TLSConfig != nil {
+		s.logger.Infof("HTTPS server is running on %s", s.server.Addr)
+
+		// The certificate is already loaded into TLSConfig.
+		if err := s.server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("http server listen and serve TLS: %w", err)
+		}
+
+		return nil
+	}
+
 	s.logger.Infof("HTTP server is running on %s", s.server.Addr)
 
 	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -14,24 +14,72 @@ func configureRoutes(
 	log *logrus.Logger,
 	usersServ service.UsersServiceInterface,
 	authServ service.AuthServiceInterface,
+	messagesServ service.MessagesServiceInterface,
+	attachmentsServ service.AttachmentsServiceInterface,
+	roomsServ service.RoomsServiceInterface,
+	moderationServ service.ModerationServiceInterface,
+	chatSessions handler.ChatSessionServer,
+	presence handler.PresenceProvider,
+	roomEvents handler.RoomEventsNotifier,
 ) {
 	authHandler := handler.NewAuthHandler(authServ, log)
 	usersHandler := handler.NewUsersHandler(usersServ, log)
+	messagesHandler := handler.NewMessagesHandler(messagesServ, log)
+	attachmentsHandler := handler.NewAttachmentsHandler(attachmentsServ, log)
+	roomsHandler := handler.NewRoomsHandler(roomsServ, roomEvents, log)
+	moderationHandler := handler.NewModerationHandler(moderationServ, roomEvents, log)
+	wsHandler := handler.NewWSHandler(authServ, chatSessions, log)
+	presenceHandler := handler.NewPresenceHandler(presence, log)
 
 	const (
-		loginRequestsPerSecond = 5
-		loginBurstSize         = 15
-		regisRequestsPerSecond = 5
-		regisBurstSize         = 15
+		loginRequestsPerSecond   = 5
+		loginBurstSize           = 15
+		regisRequestsPerSecond   = 5
+		regisBurstSize           = 15
+		refreshRequestsPerSecond = 5
+		refreshBurstSize         = 15
 	)
 
 	loginLimiter := rate.NewLimiter(loginRequestsPerSecond, loginBurstSize)
 	regisLimiter := rate.NewLimiter(regisRequestsPerSecond, regisBurstSize)
+	refreshLimiter := rate.NewLimiter(refreshRequestsPerSecond, refreshBurstSize)
+
+	r.Get("/.well-known/jwks.json", authHandler.JWKS)
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Route("/user", func(r chi.Router) {
 			r.With(middleware.RateLimiterMiddleware(loginLimiter)).Post("/login", authHandler.Login)
 			r.With(middleware.RateLimiterMiddleware(regisLimiter)).Post("/register", usersHandler.RegisterUser)
+			r.With(middleware.RateLimiterMiddleware(refreshLimiter)).Post("/refresh", authHandler.Refresh)
+			r.With(middleware.JWTAuthMiddleware(authServ)).Post("/logout", authHandler.Logout)
+		})
+
+		r.Get("/ws", wsHandler.Connect)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.JWTAuthMiddleware(authServ))
+
+			r.Get("/rooms/{room}/messages", messagesHandler.RoomMessages)
+			r.Get("/rooms/{room}/presence", presenceHandler.RoomPresence)
+			r.Post("/rooms/{room}/read", messagesHandler.MarkRoomRead)
+			r.Post("/rooms/{room}/invitations", roomsHandler.Invite)
+			r.Put("/rooms/{room}/members/{username}/role", roomsHandler.SetMemberRole)
+			r.Get("/invitations", roomsHandler.Invitations)
+			r.Post("/invitations/{room}/accept", roomsHandler.AcceptInvitation)
+			r.Post("/invitations/{room}/decline", roomsHandler.DeclineInvitation)
+			r.Get("/unread", messagesHandler.UnreadCounts)
+			r.Get("/mentions", messagesHandler.Mentions)
+			r.Get("/dm/{username}/messages", messagesHandler.DirectMessages)
+			r.Get("/messages/{id}/thread", messagesHandler.Thread)
+			r.Post("/attachments", attachmentsHandler.Upload)
+			r.Get("/attachments/{id}", attachmentsHandler.Download)
+
+			r.Route("/admin/rooms/{room}", func(r chi.Router) {
+				r.Get("/bans", moderationHandler.Bans)
+				r.Post("/bans", moderationHandler.Ban)
+				r.Delete("/bans/{username}", moderationHandler.Unban)
+				r.Get("/audit", moderationHandler.AuditLog)
+			})
 		})
 	})
 }
@@ -3,6 +3,7 @@ package tcpserver
 import (
 	"net"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stsolovey/kvant_chat/internal/app/service"
@@ -11,38 +12,49 @@ import (
 )
 
 type Server struct {
-	cfg         *config.Config
-	log         *logrus.Logger
-	rooms       map[string]*models.Room
-	mutex       *sync.Mutex
-	listener    net.Listener
-	connUsers   map[net.Conn]*models.User
-	authService *service.AuthService
+	cfg               *config.Config
+	log               *logrus.Logger
+	rooms             map[string]*models.Room
+	mutex             *sync.Mutex
+	listener          net.Listener
+	connUsers         map[net.Conn]*models.User
+	auths             map[net.Conn]*sessionAuth
+	eventLogs         map[string]*eventLog
+	presence          map[string]*models.Presence
+	typing            map[typingKey]*time.Timer
+	authService       *service.AuthService
+	usersService      service.UsersServiceInterface
+	messagesService   service.MessagesServiceInterface
+	roomsService      service.RoomsServiceInterface
+	moderationService service.ModerationServiceInterface
 }
 
 func CreateServer(
 	config *config.Config,
 	logger *logrus.Logger,
 	authService *service.AuthService,
+	usersService service.UsersServiceInterface,
+	messagesService service.MessagesServiceInterface,
+	roomsService service.RoomsServiceInterface,
+	moderationService service.ModerationServiceInterface,
 ) *Server {
 	rooms := make(map[string]*models.Room)
-	rooms["general"] = &models.Room{Name: "general", Members: make(map[*models.User]net.Conn)}
+	rooms[defaultRoom] = &models.Room{Name: defaultRoom, Members: make(map[*models.User]net.Conn)}
 
 	return &Server{
-		cfg:         config,
-		log:         logger,
-		rooms:       rooms,
-		mutex:       &sync.Mutex{},
-		connUsers:   make(map[net.Conn]*models.User),
-		authService: authService,
-	}
-}
-
-func (s *Server) leaveRoom(roomName string, user *models.User) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	if room, exists := s.rooms[roomName]; exists {
-		delete(room.Members, user)
+		cfg:               config,
+		log:               logger,
+		rooms:             rooms,
+		mutex:             &sync.Mutex{},
+		connUsers:         make(map[net.Conn]*models.User),
+		auths:             make(map[net.Conn]*sessionAuth),
+		eventLogs:         make(map[string]*eventLog),
+		presence:          make(map[string]*models.Presence),
+		typing:            make(map[typingKey]*time.Timer),
+		authService:       authService,
+		usersService:      usersService,
+		messagesService:   messagesService,
+		roomsService:      roomsService,
+		moderationService: moderationService,
 	}
 }
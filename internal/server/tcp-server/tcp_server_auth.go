@@ -0,0 +1,194 @@
+package tcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// sessionAuth is what a session knows of the token it was authenticated with, reauth frames renew it.
+type sessionAuth struct {
+	mu        sync.Mutex
+	jti       string
+	expiresAt time.Time
+	asked     bool // the client was asked for a fresh token since the last renewal
+}
+
+func newSessionAuth(token *jwt.Token) (*sessionAuth, error) {
+	jti, expiresAt, err := tokenSession(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionAuth{jti: jti, expiresAt: expiresAt}, nil
+}
+
+// tokenSession reads the ID and the expiry of a validated access token.
+func tokenSession(token *jwt.Token) (string, time.Time, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("tokenSession: %w", models.ErrParseJWTClaimsAsMapClaims)
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, ok := claims["exp"].(float64)
+
+	if jti == "" || !ok {
+		return "", time.Time{}, fmt.Errorf("tokenSession: token without jti or exp: %w", models.ErrUnauthorized)
+	}
+
+	return jti, time.Unix(int64(exp), 0), nil
+}
+
+func (a *sessionAuth) current() (string, time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.jti, a.expiresAt
+}
+
+// renew switches the session to a fresh token.
+func (a *sessionAuth) renew(jti string, expiresAt time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.jti = jti
+	a.expiresAt = expiresAt
+	a.asked = false
+}
+
+// askFresh reports whether the client is to be asked for a fresh token at now, once per token
+// within window of its expiry.
+func (a *sessionAuth) askFresh(now time.Time, window time.Duration) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.asked || now.Before(a.expiresAt.Add(-window)) {
+		return false
+	}
+
+	a.asked = true
+
+	return true
+}
+
+// due returns when the session is to be looked at next on account of its token: when the client
+// is to be asked for a fresh one, or when it expires.
+func (a *sessionAuth) due(window time.Duration) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.asked {
+		return a.expiresAt
+	}
+
+	return a.expiresAt.Add(-window)
+}
+
+// watchSession enforces the session's token until ctx is done: the client is asked for a fresh token
+// ReauthWindow before it expires, and the session ends once it expires, is revoked or its user is deleted.
+// Revocations and deletions are noticed within SessionCheckInterval.
+func (s *Server) watchSession(ctx context.Context, conn *queuedConn, user *models.User, auth *sessionAuth) {
+	for {
+		timer := time.NewTimer(min(s.cfg.SessionCheckInterval, time.Until(auth.due(s.cfg.ReauthWindow))))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+
+		if err := s.checkSession(ctx, conn, user, auth); err != nil {
+			s.endSession(conn, user, err)
+
+			return
+		}
+	}
+}
+
+// checkSession fails when the session's token no longer holds. Failing to reach the database
+// does not end sessions, it is only logged.
+func (s *Server) checkSession(ctx context.Context, conn net.Conn, user *models.User, auth *sessionAuth) error {
+	jti, expiresAt := auth.current()
+	now := time.Now()
+
+	if !now.Before(expiresAt) {
+		return models.ErrTokenExpired
+	}
+
+	if auth.askFresh(now, s.cfg.ReauthWindow) {
+		if err := s.sendFrame(conn, protocol.TypeReauth, "", protocol.ReauthPayload{ExpiresAt: &expiresAt}); err != nil {
+			s.log.WithError(err).Warnf("Failed to ask %s for a fresh token", user.UserName)
+		}
+	}
+
+	err := s.authService.CheckSession(ctx, user.UserName, jti)
+
+	switch {
+	case err == nil, ctx.Err() != nil:
+		return nil
+	case errors.Is(err, models.ErrTokenRevoked):
+		return err
+	case errors.Is(err, models.ErrUserNotFound):
+		return fmt.Errorf("checkSession: %w: %w", models.ErrUnauthorized, err)
+	default:
+		s.log.WithError(err).Warnf("Failed to check the session of %s", user.UserName)
+
+		return nil
+	}
+}
+
+// endSession closes a session whose token no longer holds, the client gets an error frame telling why.
+func (s *Server) endSession(conn *queuedConn, user *models.User, cause error) {
+	s.log.WithError(cause).Infof("Ending the session of %s", user.UserName)
+
+	data, err := protocol.Encode(protocol.TypeError, "", errorPayload(cause))
+	if err != nil {
+		s.log.WithError(err).Warn("Failed to encode the last error frame")
+	}
+
+	conn.closeWith(data)
+}
+
+// reauthenticate renews the session's token with the one of a reauth frame, issued to the same user.
+func (s *Server) reauthenticate(ctx context.Context, reauth protocol.ReauthPayload, user *models.User) error {
+	s.mutex.Lock()
+	auth := s.auths[user.Conn]
+	s.mutex.Unlock()
+
+	if auth == nil || reauth.Token == "" {
+		return fmt.Errorf("reauthenticate: %w", models.ErrUnauthorized)
+	}
+
+	token, err := s.authService.ValidateToken(ctx, reauth.Token)
+	if err != nil {
+		return fmt.Errorf("reauthenticate: %w: %w", models.ErrUnauthorized, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("reauthenticate: %w", models.ErrParseJWTClaimsAsMapClaims)
+	}
+
+	if username, _ := claims["username"].(string); username != user.UserName {
+		return fmt.Errorf("reauthenticate: token of %q: %w", username, models.ErrUnauthorized)
+	}
+
+	jti, expiresAt, err := tokenSession(token)
+	if err != nil {
+		return err
+	}
+
+	auth.renew(jti, expiresAt)
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package tcpserver
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/config"
+	"github.com/stsolovey/kvant_chat/internal/jwtkeys"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// fakeAuthRepo knows the users not deleted and the revoked tokens.
+type fakeAuthRepo struct {
+	mu      sync.Mutex
+	users   map[string]bool
+	revoked map[string]bool
+}
+
+func (r *fakeAuthRepo) GetUserByUsername(_ context.Context, username string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.users[username] {
+		return nil, models.ErrUserNotFound
+	}
+
+	return &models.User{UserName: username}, nil
+}
+
+func (r *fakeAuthRepo) CreateRefreshToken(context.Context, string, models.RefreshToken) error {
+	return nil
+}
+
+func (r *fakeAuthRepo) RotateRefreshToken(context.Context, string, models.RefreshToken) (string, error) {
+	return "", models.ErrInvalidRefreshToken
+}
+
+func (r *fakeAuthRepo) RevokeSession(_ context.Context, jti string, _ time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoked[jti] = true
+
+	return nil
+}
+
+func (r *fakeAuthRepo) RevokeUserSessions(context.Context, string) error {
+	return nil
+}
+
+func (r *fakeAuthRepo) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.revoked[jti], nil
+}
+
+func (r *fakeAuthRepo) deleteUser(username string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, username)
+}
+
+func newTestAuthServer(cfg *config.Config, usernames ...string) (*Server, *fakeAuthRepo) {
+	repo := &fakeAuthRepo{users: make(map[string]bool), revoked: make(map[string]bool)}
+	for _, username := range usernames {
+		repo.users[username] = true
+	}
+
+	return &Server{
+		cfg:         cfg,
+		log:         logrus.New(),
+		mutex:       &sync.Mutex{},
+		auths:       make(map[net.Conn]*sessionAuth),
+		authService: service.NewAuthService(repo, jwtkeys.NewSecret([]byte("secret")), time.Hour, time.Hour),
+	}, repo
+}
+
+// nextFrame reads the next frame the client gets, failing when none comes.
+func nextFrame(t *testing.T, reader *bufio.Reader) protocol.Envelope {
+	t.Helper()
+
+	lines := make(chan []byte, 1)
+
+	go func() {
+		line, err := reader.ReadBytes('\n')
+		if err == nil {
+			lines <- line
+		}
+		close(lines)
+	}()
+
+	select {
+	case line, ok := <-lines:
+		require.True(t, ok, "the connection should not be closed yet")
+
+		env, err := protocol.Decode(line)
+		require.NoError(t, err)
+
+		return env
+	case <-time.After(time.Second):
+		require.FailNow(t, "no frame received")
+
+		return protocol.Envelope{}
+	}
+}
+
+func requireSessionEnded(t *testing.T, reader *bufio.Reader, expected error) {
+	t.Helper()
+
+	env := nextFrame(t, reader)
+	require.Equal(t, protocol.TypeError, env.Type)
+
+	var payload protocol.ErrorPayload
+	require.NoError(t, env.DecodePayload(&payload))
+	assert.Equal(t, protocol.CodeUnauthorized, payload.Code)
+	assert.Equal(t, expected.Error(), payload.Message)
+
+	_, err := reader.ReadByte()
+	assert.Error(t, err, "the connection should be closed after the error frame")
+}
+
+func TestWatchSessionAsksForFreshToken(t *testing.T) {
+	cfg := &config.Config{SessionCheckInterval: time.Hour, ReauthWindow: 100 * time.Millisecond}
+	s, _ := newTestAuthServer(cfg, "alice1")
+	conn, clientSide := newTestQueuedConn(t, config.SlowClientDropOldest, time.Second)
+	reader := bufio.NewReader(clientSide)
+
+	expiresAt := time.Now().Add(200 * time.Millisecond)
+	auth := &sessionAuth{jti: "j1", expiresAt: expiresAt}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.watchSession(ctx, conn, &models.User{UserName: "alice1"}, auth)
+
+	env := nextFrame(t, reader)
+	require.Equal(t, protocol.TypeReauth, env.Type, "the client should be asked for a fresh token before it expires")
+
+	var reauth protocol.ReauthPayload
+	require.NoError(t, env.DecodePayload(&reauth))
+	require.NotNil(t, reauth.ExpiresAt)
+	assert.WithinDuration(t, expiresAt, *reauth.ExpiresAt, time.Millisecond)
+
+	requireSessionEnded(t, reader, models.ErrTokenExpired)
+}
+
+func TestWatchSessionEndsRevokedSessions(t *testing.T) {
+	cfg := &config.Config{SessionCheckInterval: 10 * time.Millisecond, ReauthWindow: time.Minute}
+	s, repo := newTestAuthServer(cfg, "alice1")
+	conn, clientSide := newTestQueuedConn(t, config.SlowClientDropOldest, time.Second)
+	reader := bufio.NewReader(clientSide)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.watchSession(ctx, conn, &models.User{UserName: "alice1"}, &sessionAuth{jti: "j1", expiresAt: time.Now().Add(time.Hour)})
+
+	require.NoError(t, repo.RevokeSession(ctx, "j1", time.Now().Add(time.Hour)))
+
+	requireSessionEnded(t, reader, models.ErrTokenRevoked)
+}
+
+func TestWatchSessionEndsSessionsOfDeletedUsers(t *testing.T) {
+	cfg := &config.Config{SessionCheckInterval: 10 * time.Millisecond, ReauthWindow: time.Minute}
+	s, repo := newTestAuthServer(cfg, "alice1")
+	conn, clientSide := newTestQueuedConn(t, config.SlowClientDropOldest, time.Second)
+	reader := bufio.NewReader(clientSide)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.watchSession(ctx, conn, &models.User{UserName: "alice1"}, &sessionAuth{jti: "j1", expiresAt: time.Now().Add(time.Hour)})
+
+	repo.deleteUser("alice1")
+
+	requireSessionEnded(t, reader, models.ErrUnauthorized)
+}
+
+func TestReauthenticate(t *testing.T) {
+	cfg := &config.Config{SessionCheckInterval: time.Hour, ReauthWindow: time.Minute}
+	s, _ := newTestAuthServer(cfg, "alice1", "bobbob")
+	ctx := context.Background()
+
+	conn, _ := newTestQueuedConn(t, config.SlowClientDropOldest, time.Second)
+	user := &models.User{UserName: "alice1", Conn: conn}
+	auth := &sessionAuth{jti: "old", expiresAt: time.Now().Add(time.Minute), asked: true}
+	s.auths[conn] = auth
+
+	tokens, err := s.authService.GenerateToken(ctx, "alice1")
+	require.NoError(t, err)
+
+	require.NoError(t, s.reauthenticate(ctx, protocol.ReauthPayload{Token: tokens.Token}, user))
+
+	claims := jwt.MapClaims{}
+	_, _, err = new(jwt.Parser).ParseUnverified(tokens.Token, claims)
+	require.NoError(t, err)
+
+	jti, expiresAt := auth.current()
+	assert.Equal(t, claims["jti"], jti, "the session should switch to the fresh token")
+	assert.WithinDuration(t, tokens.ExpiresAt, expiresAt, time.Second)
+	assert.False(t, auth.asked, "the client should be asked again before the fresh token expires")
+
+	others, err := s.authService.GenerateToken(ctx, "bobbob")
+	require.NoError(t, err)
+
+	err = s.reauthenticate(ctx, protocol.ReauthPayload{Token: others.Token}, user)
+	assert.ErrorIs(t, err, models.ErrUnauthorized, "a token of another user should be refused")
+
+	err = s.reauthenticate(ctx, protocol.ReauthPayload{Token: "garbage"}, user)
+	assert.ErrorIs(t, err, models.ErrUnauthorized, "an invalid token should be refused")
+
+	jti, _ = auth.current()
+	assert.Equal(t, claims["jti"], jti, "refused tokens should not change the session")
+}
+
+func TestSessionAuthAskFresh(t *testing.T) {
+	now := time.Now()
+	auth := &sessionAuth{jti: "j1", expiresAt: now.Add(10 * time.Minute)}
+
+	assert.False(t, auth.askFresh(now, 2*time.Minute), "the client should not be asked long before the expiry")
+	assert.Equal(t, now.Add(8*time.Minute), auth.due(2*time.Minute))
+
+	assert.True(t, auth.askFresh(now.Add(9*time.Minute), 2*time.Minute), "the client should be asked near the expiry")
+	assert.False(t, auth.askFresh(now.Add(9*time.Minute), 2*time.Minute), "the client should be asked once")
+	assert.Equal(t, now.Add(10*time.Minute), auth.due(2*time.Minute), "after asking the expiry is due")
+
+	auth.renew("j2", now.Add(20*time.Minute))
+	assert.True(t, auth.askFresh(now.Add(19*time.Minute), 2*time.Minute), "a renewed token should be asked for again")
+}
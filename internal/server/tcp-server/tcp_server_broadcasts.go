@@ -1,63 +1,234 @@
 package tcpserver
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"slices"
+	"time"
 
 	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
 )
 
-func (s *Server) broadcastToRoom(roomName string, msg models.Message, exceptUser *models.User) error {
+// broadcastToRoom persists the sender's message and delivers the stored copy to the other room members.
+// A retried message already stored is not delivered again.
+// Members who silenced the room only get replies in the threads they follow.
+func (s *Server) broadcastToRoom(
+	ctx context.Context,
+	roomName string,
+	msg models.Message,
+	sender *models.User,
+) (*models.Message, bool, error) {
 	msg.Receiver = ""
+	msg.Room = roomName
+	msg.Sender = sender.UserName
 
+	saved, created, err := s.messagesService.SaveMessage(ctx, msg)
+	if err != nil {
+		return nil, false, fmt.Errorf("broadcastToRoom s.messagesService.SaveMessage(...): %w", err)
+	}
+
+	if !created {
+		return saved, false, nil
+	}
+
+	var followers []string
+
+	if saved.ThreadID != 0 {
+		if followers, err = s.messagesService.ThreadSubscribers(ctx, saved.ThreadID); err != nil {
+			s.log.WithError(err).Warnf("Failed to find the followers of thread %d", saved.ThreadID)
+		}
+	}
+
+	if err := s.deliverToRoom(roomName, *saved, sender, followers); err != nil {
+		return nil, false, fmt.Errorf("broadcastToRoom s.deliverToRoom(...): %w", err)
+	}
+
+	s.notifyMentions(ctx, *saved)
+
+	return saved, true, nil
+}
+
+// notifyMentions records who the room message mentions and sends them a mention event,
+// whether or not they silenced the room.
+func (s *Server) notifyMentions(ctx context.Context, msg models.Message) {
+	mentioned, err := s.messagesService.RecordMentions(ctx, msg, s.onlineUsers())
+	if err != nil {
+		s.log.WithError(err).Warnf("Failed to record mentions of message %d", msg.ID)
+
+		return
+	}
+
+	msg.ClientID = ""
+
+	for _, username := range mentioned {
+		if _, err := s.deliverEventToUser(username, protocol.TypeMention, msg); err != nil {
+			s.log.WithError(err).Warnf("Failed to notify %s of a mention", username)
+		}
+	}
+}
+
+// deliverToRoom delivers a new message to the room members but exceptUser and those who silenced the room,
+// unless they follow the message's thread.
+func (s *Server) deliverToRoom(roomName string, msg models.Message, exceptUser *models.User, followers []string) error {
+	msg.Receiver = ""
+	msg.Room = roomName
+	msg.ClientID = "" // only meaningful to the sender
+
+	silenced := func(room *models.Room, username string) bool {
+		return room.Silenced[username] && !slices.Contains(followers, username)
+	}
+
+	return s.deliverRoomEvent(roomName, protocol.TypeMessage, msg, exceptUser, silenced)
+}
+
+// deliverEventToRoom sends an event to every room member but exceptUser, including members
+// whose session may still be resumed.
+func (s *Server) deliverEventToRoom(roomName string, frameType string, payload any, exceptUser *models.User) error {
+	return s.deliverRoomEvent(roomName, frameType, payload, exceptUser, nil)
+}
+
+// deliverRoomEvent works like deliverEventToRoom but also leaves out the members skip returns true for.
+// skip is called with the mutex held.
+func (s *Server) deliverRoomEvent(
+	roomName string,
+	frameType string,
+	payload any,
+	exceptUser *models.User,
+	skip func(room *models.Room, username string) bool,
+) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	room, exists := s.rooms[roomName]
 	if !exists {
-		return fmt.Errorf("broadcastToRoom, s.rooms[roomName]: %w", models.ErrRoomNotExists)
+		return fmt.Errorf("deliverRoomEvent, s.rooms[roomName]: %w", models.ErrRoomNotExists)
 	}
 
-	jsonData, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("broadcastToRoom(...) failed to marshal message: %w", err)
-	}
+	recipients := make(map[string][]net.Conn)
 
 	for user, conn := range room.Members {
 		if user != exceptUser {
-			_, err := conn.Write(append(jsonData, '\n'))
-			if err != nil {
-				return fmt.Errorf("broadcastToRoom username - %s, room - %s: %w",
-					user.UserName, roomName, err)
+			recipients[user.UserName] = append(recipients[user.UserName], conn)
+		}
+	}
+
+	now := time.Now()
+
+	for username, log := range s.eventLogs {
+		switch {
+		case log.recordsDetached(roomName, now):
+			recipients[username] = nil
+		case !log.alive(now):
+			delete(s.eventLogs, username)
+		}
+	}
+
+	if skip != nil {
+		for username := range recipients {
+			if skip(room, username) {
+				delete(recipients, username)
 			}
 		}
 	}
 
-	return nil
+	return s.deliverEvent(recipients, frameType, payload)
 }
 
-func (s *Server) broadcastToAll(msg models.Message, exceptUser *models.User) error {
-	msg.Receiver = ""
+// deliverToRecipients sends an event about a stored message to everyone who received it: the room members,
+// or both parties of a direct message. Failures are only logged.
+func (s *Server) deliverToRecipients(msg models.Message, frameType string, payload any) {
+	if msg.Room != "" {
+		if err := s.deliverEventToRoom(msg.Room, frameType, payload, nil); err != nil {
+			s.log.WithError(err).Warnf("Failed to push %s of message %d", frameType, msg.ID)
+		}
+
+		return
+	}
 
+	for _, username := range []string{msg.Sender, msg.Receiver} {
+		if _, err := s.deliverEventToUser(username, frameType, payload); err != nil {
+			s.log.WithError(err).Warnf("Failed to push %s of message %d to %s", frameType, msg.ID, username)
+		}
+	}
+}
+
+// deliverEventToUser sends an event to every session of the user, including one that may still be resumed,
+// and reports whether the user has a live session.
+func (s *Server) deliverEventToUser(username string, frameType string, payload any) (bool, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	jsonData, err := json.Marshal(msg)
+	conns := s.userConns(username)
+	if len(conns) == 0 && !s.isReachable(username) {
+		return false, nil
+	}
+
+	return len(conns) > 0, s.deliverEvent(map[string][]net.Conn{username: conns}, frameType, payload)
+}
+
+// userConns returns the live connections of the user. It must be called with the mutex held.
+func (s *Server) userConns(username string) []net.Conn {
+	var conns []net.Conn
+
+	for conn, user := range s.connUsers {
+		if user.UserName == username {
+			conns = append(conns, conn)
+		}
+	}
+
+	return conns
+}
+
+// isReachable reports whether events can be delivered to the user now or on resume.
+// It must be called with the mutex held.
+func (s *Server) isReachable(username string) bool {
+	if log, exists := s.eventLogs[username]; exists && log.alive(time.Now()) {
+		return true
+	}
+
+	return len(s.userConns(username)) > 0
+}
+
+// deliverEvent records the event with the next sequence number of every recipient and enqueues it
+// to their live connections. It must be called with the mutex held.
+func (s *Server) deliverEvent(recipients map[string][]net.Conn, frameType string, payload any) error {
+	raw, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("broadcastToAll(...) failed to marshal message: %w", err)
+		return fmt.Errorf("deliverEvent json.Marshal(...): %w", err)
 	}
 
-	for _, room := range s.rooms {
-		for user, conn := range room.Members {
-			if user != exceptUser {
-				_, err := conn.Write(append(jsonData, '\n'))
-				if err != nil {
-					return fmt.Errorf("broadcastToRoom username - %s, room - %s: %w",
-						user.UserName, room.Name, err)
-				}
+	for username, conns := range recipients {
+		var data []byte
+
+		if log, exists := s.eventLogs[username]; exists {
+			data, err = log.record(frameType, json.RawMessage(raw))
+		} else {
+			data, err = protocol.EncodeEvent(frameType, 0, json.RawMessage(raw))
+		}
+
+		if err != nil {
+			return fmt.Errorf("deliverEvent: %w", err)
+		}
+
+		// Writes only enqueue, a failing member is already disconnecting and must not stop delivery to the rest.
+		for _, conn := range conns {
+			if _, err := conn.Write(data); err != nil {
+				s.log.WithError(err).Warnf("deliverEvent %s to %s", frameType, username)
 			}
 		}
 	}
 
 	return nil
 }
+
+// announcePresence tells the room members but the user itself about its presence change in the room.
+func (s *Server) announcePresence(roomName string, presence protocol.PresencePayload, exceptUser *models.User) {
+	presence.Room = roomName
+
+	if err := s.deliverEventToRoom(roomName, protocol.TypePresence, presence, exceptUser); err != nil {
+		s.log.WithError(err).Warnf("Failed to announce presence to room %s", roomName)
+	}
+}
@@ -0,0 +1,48 @@
+package tcpserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/config"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+func nextMessage(t *testing.T, frames chan protocol.Envelope) models.Message {
+	t.Helper()
+
+	select {
+	case env := <-frames:
+		require.Equal(t, protocol.TypeMessage, env.Type)
+
+		var msg models.Message
+		require.NoError(t, env.DecodePayload(&msg))
+
+		return msg
+	case <-time.After(time.Second):
+		require.FailNow(t, "no message frame received")
+
+		return models.Message{}
+	}
+}
+
+func TestDeliverToSilencedRoom(t *testing.T) {
+	s, users, frames := newTestRoomServer(t, &config.Config{}, "alice1", "bobbob", "carol1")
+	s.markSilenced([]string{defaultRoom}, "bobbob", true)
+	s.markSilenced([]string{defaultRoom}, "carol1", true)
+
+	require.NoError(t, s.deliverToRoom(defaultRoom, models.Message{ID: 1, Content: "hi"}, users["alice1"], nil))
+	require.NoError(t, s.deliverToRoom(defaultRoom, models.Message{ID: 2, ThreadID: 1, ReplyTo: 1, Content: "re"},
+		users["alice1"], []string{"carol1"}))
+
+	assert.Equal(t, 2, nextMessage(t, frames["carol1"]).ID, "followers should get replies from a silenced room")
+
+	s.markSilenced([]string{defaultRoom}, "bobbob", false)
+	require.NoError(t, s.deliverToRoom(defaultRoom, models.Message{ID: 3, Content: "back"}, users["alice1"], nil))
+
+	assert.Equal(t, 3, nextMessage(t, frames["bobbob"]).ID,
+		"a silenced room should push nothing until it is unsilenced")
+}
@@ -0,0 +1,313 @@
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// handleCommand executes a command frame and returns the text of its acknowledgement.
+func (s *Server) handleCommand(ctx context.Context, cmd protocol.CommandPayload, user *models.User) (string, error) {
+	switch cmd.Name {
+	case "create":
+		return s.commandCreate(ctx, cmd.Args, user)
+	case "join":
+		return s.commandJoin(ctx, cmd.Args, user)
+	case "leave":
+		return s.commandLeave(ctx, cmd.Args, user)
+	case "rooms":
+		return "Rooms: " + strings.Join(s.listRooms(user), ", "), nil
+	case "invite":
+		return s.commandInvite(ctx, cmd.Args, user)
+	case "accept":
+		return s.commandAccept(ctx, cmd.Args, user)
+	case "decline":
+		return s.commandDecline(ctx, cmd.Args, user)
+	case "invitations":
+		return s.commandInvitations(ctx, user)
+	case "role":
+		return s.commandRole(ctx, cmd.Args, user)
+	case models.ActionKick:
+		return s.commandKick(ctx, cmd.Args, user)
+	case models.ActionBan:
+		return s.commandBan(ctx, cmd.Args, user)
+	case models.ActionMute:
+		return s.commandMute(ctx, cmd.Args, user)
+	case models.ActionUnban, models.ActionUnmute:
+		return s.commandLift(ctx, cmd.Args, user, cmd.Name)
+	case "history":
+		return s.commandHistory(ctx, cmd.Args, user)
+	case "read":
+		return s.commandRead(ctx, cmd.Args, user)
+	case "thread":
+		return s.commandThread(ctx, cmd.Args, user)
+	case "follow":
+		return s.commandFollow(ctx, cmd.Args, user, true)
+	case "unfollow":
+		return s.commandFollow(ctx, cmd.Args, user, false)
+	case "silence":
+		return s.commandSilence(ctx, cmd.Args, user, true)
+	case "unsilence":
+		return s.commandSilence(ctx, cmd.Args, user, false)
+	case "who":
+		return s.commandWho(ctx, cmd.Args, user)
+	case "away":
+		return s.commandPresence(cmd.Args, user, models.PresenceAway)
+	case "back":
+		return s.commandPresence(cmd.Args, user, models.PresenceOnline)
+	default:
+		return "", fmt.Errorf("handleCommand %q: %w", cmd.Name, models.ErrUnknownCommand)
+	}
+}
+
+// commandCreate creates a room owned by the user: "create <room> [private]".
+func (s *Server) commandCreate(ctx context.Context, args []string, user *models.User) (string, error) {
+	const usage = "create <room> [private]"
+
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[1] != "private") {
+		return "", usageError(usage)
+	}
+
+	private := len(args) == 2
+
+	if err := s.createRoom(ctx, args[0], private, user); err != nil {
+		return "", err
+	}
+
+	s.log.Infof("User %s created room %s", user.UserName, args[0])
+
+	if private {
+		return "You created and joined the private room #" + args[0] + ", invite others with invite", nil
+	}
+
+	return "You created and joined #" + args[0], nil
+}
+
+func (s *Server) commandJoin(ctx context.Context, args []string, user *models.User) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("join <room>")
+	}
+
+	roomName := args[0]
+
+	if err := s.joinRoom(ctx, roomName, user); err != nil {
+		return "", err
+	}
+
+	s.announcePresence(roomName, protocol.PresencePayload{User: user.UserName, Event: protocol.PresenceJoined}, user)
+
+	return "You joined #" + roomName, nil
+}
+
+func (s *Server) commandLeave(ctx context.Context, args []string, user *models.User) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("leave <room>")
+	}
+
+	roomName := args[0]
+
+	if err := s.leaveRoom(ctx, roomName, user); err != nil {
+		return "", err
+	}
+
+	s.announcePresence(roomName, protocol.PresencePayload{User: user.UserName, Event: protocol.PresenceLeft}, user)
+
+	return "You left #" + roomName, nil
+}
+
+// commandHistory sends a page of room history: "history <room> [beforeID] [limit]".
+func (s *Server) commandHistory(ctx context.Context, args []string, user *models.User) (string, error) {
+	const (
+		usage       = "history <room> [beforeID] [limit]"
+		maxArgCount = 3
+	)
+
+	if len(args) == 0 || len(args) > maxArgCount {
+		return "", usageError(usage)
+	}
+
+	roomName := args[0]
+
+	// numbers holds beforeID and limit, missing ones stay 0 and mean "latest" and "default".
+	var numbers [maxArgCount - 1]int
+
+	for i, arg := range args[1:] {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", usageError(usage)
+		}
+
+		numbers[i] = n
+	}
+
+	if !s.isRoomMember(roomName, user) {
+		return "", fmt.Errorf("commandHistory #%s: %w", roomName, models.ErrNotRoomMember)
+	}
+
+	page, err := s.messagesService.RoomHistory(ctx, user.UserName, roomName, models.HistoryRequest{
+		BeforeID: numbers[0],
+		Limit:    numbers[1],
+	})
+	if err != nil {
+		return "", fmt.Errorf("commandHistory s.messagesService.RoomHistory(...): %w", err)
+	}
+
+	if err := s.writeHistory(page.Messages, user.Conn); err != nil {
+		return "", fmt.Errorf("commandHistory s.writeHistory(...): %w", err)
+	}
+
+	if page.NextCursor == 0 {
+		return "No more messages in #" + roomName, nil
+	}
+
+	return fmt.Sprintf("End of page, use history %s %d for older messages", roomName, page.NextCursor), nil
+}
+
+// commandRead marks a room or the direct messages from a user read: "read <room>|@<user> [messageID]".
+// The sender of direct messages gets a receipt.
+func (s *Server) commandRead(ctx context.Context, args []string, user *models.User) (string, error) {
+	const usage = "read <room>|@<user> [messageID]"
+
+	if len(args) == 0 || len(args) > 2 {
+		return "", usageError(usage)
+	}
+
+	var messageID int
+
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", usageError(usage)
+		}
+
+		messageID = n
+	}
+
+	peer, direct := strings.CutPrefix(args[0], "@")
+	if !direct {
+		lastReadID, err := s.messagesService.MarkRoomRead(ctx, args[0], user.UserName, messageID)
+		if err != nil {
+			return "", fmt.Errorf("commandRead s.messagesService.MarkRoomRead(...): %w", err)
+		}
+
+		return fmt.Sprintf("#%s read up to #%d", args[0], lastReadID), nil
+	}
+
+	receipt, err := s.messagesService.MarkDirectRead(ctx, user.UserName, peer, messageID)
+	if err != nil {
+		return "", fmt.Errorf("commandRead s.messagesService.MarkDirectRead(...): %w", err)
+	}
+
+	if receipt == nil {
+		return "No unread messages from " + peer, nil
+	}
+
+	if _, err := s.deliverEventToUser(peer, protocol.TypeReceipt, receipt); err != nil {
+		s.log.WithError(err).Warnf("Failed to send read receipt to %s", peer)
+	}
+
+	return fmt.Sprintf("%d messages from %s marked read", len(receipt.MessageIDs), peer), nil
+}
+
+// commandThread sends the first message of a thread and a page of its replies:
+// "thread <messageID> [beforeID] [limit]".
+func (s *Server) commandThread(ctx context.Context, args []string, user *models.User) (string, error) {
+	const (
+		usage       = "thread <messageID> [beforeID] [limit]"
+		maxArgCount = 3
+	)
+
+	if len(args) == 0 || len(args) > maxArgCount {
+		return "", usageError(usage)
+	}
+
+	// numbers holds messageID, beforeID and limit, missing ones stay 0.
+	var numbers [maxArgCount]int
+
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return "", usageError(usage)
+		}
+
+		numbers[i] = n
+	}
+
+	thread, err := s.messagesService.Thread(ctx, user.UserName, numbers[0], models.HistoryRequest{
+		BeforeID: numbers[1],
+		Limit:    numbers[2],
+	})
+	if err != nil {
+		return "", fmt.Errorf("commandThread s.messagesService.Thread(...): %w", err)
+	}
+
+	messages := thread.Replies
+	if numbers[1] == 0 {
+		messages = append([]models.Message{thread.Root}, messages...)
+	}
+
+	if err := s.writeHistory(messages, user.Conn); err != nil {
+		return "", fmt.Errorf("commandThread s.writeHistory(...): %w", err)
+	}
+
+	if thread.NextCursor == 0 {
+		return fmt.Sprintf("End of thread #%d", thread.Root.ID), nil
+	}
+
+	return fmt.Sprintf("End of page, use thread %d %d for older replies", thread.Root.ID, thread.NextCursor), nil
+}
+
+// commandFollow subscribes to the thread of a message or unsubscribes: "follow|unfollow <messageID>".
+// Followers get replies even from rooms they silenced.
+func (s *Server) commandFollow(ctx context.Context, args []string, user *models.User, follow bool) (string, error) {
+	usage := "follow <messageID>"
+	if !follow {
+		usage = "unfollow <messageID>"
+	}
+
+	if len(args) != 1 {
+		return "", usageError(usage)
+	}
+
+	messageID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return "", usageError(usage)
+	}
+
+	threadID, err := s.messagesService.FollowThread(ctx, user.UserName, messageID, follow)
+	if err != nil {
+		return "", fmt.Errorf("commandFollow s.messagesService.FollowThread(...): %w", err)
+	}
+
+	if !follow {
+		return fmt.Sprintf("You no longer follow thread #%d", threadID), nil
+	}
+
+	return fmt.Sprintf("You follow thread #%d", threadID), nil
+}
+
+// commandSilence stops pushing new messages of a room or starts again: "silence|unsilence <room>".
+func (s *Server) commandSilence(ctx context.Context, args []string, user *models.User, silenced bool) (string, error) {
+	usage := "silence <room>"
+	if !silenced {
+		usage = "unsilence <room>"
+	}
+
+	if len(args) != 1 {
+		return "", usageError(usage)
+	}
+
+	if err := s.setRoomSilenced(ctx, args[0], user, silenced); err != nil {
+		return "", err
+	}
+
+	if !silenced {
+		return "You get messages of #" + args[0] + " again", nil
+	}
+
+	return "You silenced #" + args[0] + ", replies in threads you follow still come through", nil
+}
@@ -0,0 +1,169 @@
+package tcpserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// clientErrors maps failures caused by the client to protocol error codes, their text is safe to show.
+var clientErrors = []struct {
+	err  error
+	code string
+}{
+	{protocol.ErrMalformedFrame, protocol.CodeBadRequest},
+	{protocol.ErrUnsupportedVersion, protocol.CodeUnsupportedVersion},
+	{models.ErrTokenExpired, protocol.CodeUnauthorized},
+	{models.ErrTokenRevoked, protocol.CodeUnauthorized},
+	{models.ErrUnauthorized, protocol.CodeUnauthorized},
+	{models.ErrUnknownCommand, protocol.CodeUnknownCommand},
+	{models.ErrEmptyMessage, protocol.CodeBadRequest},
+	{models.ErrMessageTooLong, protocol.CodeBadRequest},
+	{models.ErrInvalidClientID, protocol.CodeBadRequest},
+	{models.ErrInvalidMessageID, protocol.CodeBadRequest},
+	{models.ErrInvalidReaction, protocol.CodeBadRequest},
+	{models.ErrInvalidReply, protocol.CodeBadRequest},
+	{models.ErrTooManyAttachments, protocol.CodeBadRequest},
+	{models.ErrInvalidRoomName, protocol.CodeBadRequest},
+	{models.ErrInvalidCursor, protocol.CodeBadRequest},
+	{models.ErrHistoryLimitTooLarge, protocol.CodeBadRequest},
+	{models.ErrUnexpectedFrame, protocol.CodeBadRequest},
+	{models.ErrInvalidRole, protocol.CodeBadRequest},
+	{models.ErrInvalidDuration, protocol.CodeBadRequest},
+	{models.ErrRoomExists, protocol.CodeConflict},
+	{models.ErrAlreadyRoomMember, protocol.CodeConflict},
+	{models.ErrRoomNotExists, protocol.CodeNotFound},
+	{models.ErrUserNotFound, protocol.CodeNotFound},
+	{models.ErrNotRoomMember, protocol.CodeForbidden},
+	{models.ErrRoomPrivate, protocol.CodeForbidden},
+	{models.ErrNotRoomModerator, protocol.CodeForbidden},
+	{models.ErrNotRoomOwner, protocol.CodeForbidden},
+	{models.ErrInvitationNotFound, protocol.CodeNotFound},
+	{models.ErrBannedFromRoom, protocol.CodeForbidden},
+	{models.ErrMutedInRoom, protocol.CodeForbidden},
+	{models.ErrCannotModerate, protocol.CodeForbidden},
+	{models.ErrNotBanned, protocol.CodeNotFound},
+	{models.ErrNotMuted, protocol.CodeNotFound},
+	{models.ErrMessageNotFound, protocol.CodeNotFound},
+	{models.ErrMessageEditForbidden, protocol.CodeForbidden},
+	{models.ErrMessageDeleteForbidden, protocol.CodeForbidden},
+	{models.ErrAttachmentNotFound, protocol.CodeNotFound},
+}
+
+// errorPayload turns a failure into an error frame payload, internal failures are hidden behind a generic text.
+func errorPayload(err error) protocol.ErrorPayload {
+	switch {
+	case errors.Is(err, models.ErrInvalidCommandArgs):
+		// Built by the command handlers with the usage text and never wrapped.
+		return protocol.ErrorPayload{Code: protocol.CodeBadRequest, Message: err.Error()}
+	case errors.Is(err, models.ErrServerOwnedField):
+		// Built by ownedFieldsError with the names of the fields and never wrapped.
+		return protocol.ErrorPayload{Code: protocol.CodeForbidden, Message: err.Error()}
+	}
+
+	for _, clientErr := range clientErrors {
+		if errors.Is(err, clientErr.err) {
+			return protocol.ErrorPayload{Code: clientErr.code, Message: clientErr.err.Error()}
+		}
+	}
+
+	return protocol.ErrorPayload{Code: protocol.CodeInternal, Message: "internal server error"}
+}
+
+func usageError(usage string) error {
+	return fmt.Errorf("%w, usage: %s", models.ErrInvalidCommandArgs, usage)
+}
+
+func ownedFieldsError(fields []string) error {
+	return fmt.Errorf("%w: %s", models.ErrServerOwnedField, strings.Join(fields, ", "))
+}
+
+// messageOwnedFields lists the fields of a client's message that only the server sets: the sender
+// comes from the session, the rest from the stored message. Edits and deletions name their message by ID.
+// Attachments are referenced by ID and may carry the file name.
+func messageOwnedFields(msg models.Message, withID bool) []string {
+	var fields []string
+
+	for _, owned := range []struct {
+		name string
+		set  bool
+	}{
+		{"id", msg.ID != 0 && !withID},
+		{"sender", msg.Sender != ""},
+		{"createdAt", !msg.CreatedAt.IsZero()},
+		{"editedAt", msg.EditedAt != nil},
+		{"deleted", msg.Deleted},
+		{"threadId", msg.ThreadID != 0},
+		{"parent", msg.Parent != nil},
+		{"reactions", len(msg.Reactions) > 0},
+		{"error", msg.Error != ""},
+		{"history", msg.History},
+		{"attachments", slices.ContainsFunc(msg.Attachments, func(attachment models.Attachment) bool {
+			return attachment != models.Attachment{ID: attachment.ID, Name: attachment.Name}
+		})},
+	} {
+		if owned.set {
+			fields = append(fields, owned.name)
+		}
+	}
+
+	return fields
+}
+
+// reactionOwnedFields lists the fields of a client's reaction that only the server sets.
+func reactionOwnedFields(reaction models.Reaction) []string {
+	var fields []string
+
+	if reaction.User != "" {
+		fields = append(fields, "user")
+	}
+
+	if reaction.Room != "" {
+		fields = append(fields, "room")
+	}
+
+	if len(reaction.Reactions) > 0 {
+		fields = append(fields, "reactions")
+	}
+
+	return fields
+}
+
+// sendFrame writes one protocol frame to the connection.
+func (s *Server) sendFrame(conn net.Conn, frameType string, id string, payload any) error {
+	data, err := protocol.Encode(frameType, id, payload)
+	if err != nil {
+		return fmt.Errorf("sendFrame protocol.Encode(...): %w", err)
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("sendFrame %s conn.Write(...): %w", frameType, err)
+	}
+
+	return nil
+}
+
+func (s *Server) sendAck(conn net.Conn, id string, payload protocol.AckPayload) error {
+	return s.sendFrame(conn, protocol.TypeAck, id, payload)
+}
+
+// sendError reports a failure of the client frame with the given ID, it is only logged when sending fails.
+func (s *Server) sendError(conn net.Conn, id string, cause error) {
+	payload := errorPayload(cause)
+	if payload.Code == protocol.CodeInternal {
+		s.log.WithError(cause).Warnf("Failed to handle frame %q from %s", id, conn.RemoteAddr())
+	}
+
+	if err := s.sendFrame(conn, protocol.TypeError, id, payload); err != nil {
+		s.log.WithError(err).Warnf("Failed to send error frame to %s", conn.RemoteAddr())
+	}
+}
+
+func (s *Server) writeMessage(msg models.Message, conn net.Conn) error {
+	return s.sendFrame(conn, protocol.TypeMessage, "", msg)
+}
@@ -0,0 +1,63 @@
+package tcpserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+func TestHandleFrameRejectsOwnedFields(t *testing.T) {
+	s := &Server{log: logrus.New()}
+	user := &models.User{UserName: "alice1"}
+
+	frames := []struct {
+		frameType string
+		payload   any
+		fields    string
+	}{
+		{protocol.TypeMessage, models.Message{Room: "general", Content: "hi", Sender: "Server"}, "sender"},
+		{protocol.TypeMessage, models.Message{Receiver: "bobbob", Content: "hi", Sender: "alice1"}, "sender"},
+		{protocol.TypeMessage, models.Message{Content: "hi", ID: 7, CreatedAt: time.Now()}, "id, createdAt"},
+		{protocol.TypeMessage, models.Message{Attachments: []models.Attachment{{ID: "f1", Size: 1}}}, "attachments"},
+		{protocol.TypeEdit, models.Message{ID: 7, Content: "hi", Sender: "bobbob"}, "sender"},
+		{protocol.TypeReaction, models.Reaction{MessageID: 7, Emoji: "+1", Added: true, User: "bobbob"}, "user"},
+		{protocol.TypeTyping, protocol.TypingPayload{Receiver: "bobbob", Active: true, User: "carol1"}, "user"},
+	}
+
+	for _, frame := range frames {
+		data, err := protocol.Encode(frame.frameType, "1", frame.payload)
+		require.NoError(t, err)
+
+		env, err := protocol.Decode(data)
+		require.NoError(t, err)
+
+		err = s.handleFrame(context.Background(), env, user)
+		require.ErrorIs(t, err, models.ErrServerOwnedField, "%s frame %+v should be rejected", frame.frameType, frame.payload)
+
+		payload := errorPayload(err)
+		assert.Equal(t, protocol.CodeForbidden, payload.Code)
+		assert.Contains(t, payload.Message, frame.fields, "the error should name the fields")
+	}
+}
+
+func TestMessageOwnedFields(t *testing.T) {
+	msg := models.Message{
+		ClientID:    "c1",
+		Room:        "general",
+		Content:     "hi",
+		ReplyTo:     3,
+		Attachments: []models.Attachment{{ID: "f1", Name: "plan.pdf"}},
+	}
+
+	assert.Empty(t, messageOwnedFields(msg, false), "fields a client sends should be accepted")
+
+	msg.ID = 7
+	assert.Equal(t, []string{"id"}, messageOwnedFields(msg, false), "new messages should not carry an ID")
+	assert.Empty(t, messageOwnedFields(msg, true), "edits name their message by ID")
+}
@@ -2,182 +2,560 @@ package tcpserver
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
-	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
 	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
 )
 
-func (s *Server) handleConnection(ctx context.Context, conn net.Conn) error {
+// ServeConn runs a chat session on a connection, accepted by the TCP listener or outside of it, e.g. a WebSocket,
+// so every client shares the same rooms. The client starts with a hello frame. A nil user authenticates with
+// the token of that frame, otherwise the user was authenticated before and the session is bound to the
+// validated token it was authenticated with, the token of the frame is ignored.
+// The connection is closed when the session ends.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn, user *models.User, token *jwt.Token) error {
 	defer func() {
-		if err := conn.Close(); err != nil {
-			s.log.WithError(err).Panic("Error closing connecting")
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.log.WithError(err).Warn("Error closing connection")
 		}
 	}()
 
-	user, err := s.getUserFromConn(ctx, conn)
+	reader := bufio.NewReader(conn)
+
+	hello, version, err := s.handshake(reader)
 	if err != nil {
-		if _, err = conn.Write([]byte("Failed to retrieve user data\n")); err != nil {
-			return fmt.Errorf("handleConnection(...) getUserFromConn(...) conn.Write: %w", err)
-		}
+		s.sendError(conn, "", err)
+
+		return fmt.Errorf("ServeConn(...) s.handshake(...): %w", err)
+	}
+
+	var auth *sessionAuth
+
+	if user == nil {
+		user, auth, err = s.getUserFromToken(ctx, hello.Token)
+	} else {
+		auth, err = newSessionAuth(token)
+	}
+
+	if err != nil {
+		s.sendError(conn, "", err)
+
+		return fmt.Errorf("ServeConn(...) authentication: %w", err)
+	}
+
+	return s.serveUser(ctx, conn, reader, user, auth, hello, version)
+}
+
+// handshake reads the client's hello frame and negotiates the protocol version.
+func (s *Server) handshake(reader *bufio.Reader) (*protocol.HelloPayload, int, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, 0, fmt.Errorf("handshake reader.ReadBytes(...): %w", err)
+	}
+
+	env, err := protocol.Decode(line)
+	if err != nil {
+		return nil, 0, fmt.Errorf("handshake protocol.Decode(...): %w", err)
+	}
+
+	if env.Type != protocol.TypeHello {
+		return nil, 0, fmt.Errorf("handshake got %q frame, expected hello: %w", env.Type, models.ErrUnexpectedFrame)
+	}
+
+	var hello protocol.HelloPayload
+	if err := env.DecodePayload(&hello); err != nil {
+		return nil, 0, fmt.Errorf("handshake env.DecodePayload(...): %w", err)
+	}
 
-		return fmt.Errorf("handleConnection(...) getUserFromConn(...): %w", err)
+	version, err := protocol.Negotiate(hello.Versions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("handshake protocol.Negotiate(...): %w", err)
 	}
 
+	return &hello, version, nil
+}
+
+func (s *Server) serveUser(
+	ctx context.Context,
+	rawConn net.Conn,
+	reader *bufio.Reader,
+	user *models.User,
+	auth *sessionAuth,
+	hello *protocol.HelloPayload,
+	version int,
+) error {
+	// Everything written to the session goes through its outbound queue, flushed when the session ends.
+	conn := newQueuedConn(rawConn, s.cfg, s.log)
+	defer conn.flush()
+
 	user.Conn = conn
 
 	s.log.Infof("User %s authenticated successfully", user.UserName)
 
 	s.mutex.Lock()
 	s.connUsers[conn] = user
-	s.rooms["general"].Members[user] = conn
+	s.auths[conn] = auth
 	s.mutex.Unlock()
 
-	content := "Welcome to the chat, " + user.UserName + "!"
+	defer func() {
+		s.mutex.Lock()
+		delete(s.connUsers, conn)
+		delete(s.auths, conn)
+		s.mutex.Unlock()
+	}()
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+
+	go s.watchSession(watchCtx, conn, user, auth)
+
+	roomNames, err := s.userRooms(ctx, user)
+	if err != nil {
+		s.sendError(conn, "", err)
 
-	if err := s.sendMessage("Server", user.UserName, content, conn); err != nil {
-		return fmt.Errorf("handleConnection(...) s.sendMessage(...): %w", err)
+		return fmt.Errorf("serveUser(...) s.userRooms(...): %w", err)
 	}
 
-	joinMsg := models.Message{
-		// Receiver:  "everyone",.
-		Content:   user.UserName + " has joined the chat!",
-		Sender:    "Server",
-		CreatedAt: time.Now(),
+	if err := s.loadSilencedRooms(ctx, user); err != nil {
+		s.log.WithError(err).Warnf("Failed to load the rooms %s silenced", user.UserName)
 	}
 
-	if err := s.broadcastToAll(joinMsg, nil); err != nil {
-		return fmt.Errorf("handleConnection(...) s.broadcastToAll(...): %w", err)
+	log, resumed, err := s.openEventLog(user.UserName, hello.Resume)
+	if err != nil {
+		s.sendError(conn, "", err)
+
+		return fmt.Errorf("serveUser(...) s.openEventLog(...): %w", err)
+	}
+
+	unread, err := s.messagesService.UnreadCounts(ctx, user.UserName)
+	if err != nil {
+		s.log.WithError(err).Warnf("Failed to count unread messages of %s", user.UserName)
 	}
 
-	if err := s.handleMessages(conn, user); err != nil {
-		return fmt.Errorf("handleConnection(...) s.handleMessages(...): %w", err)
+	invitations, err := s.roomsService.ListInvitations(ctx, user.UserName)
+	if err != nil {
+		s.log.WithError(err).Warnf("Failed to list the invitations of %s", user.UserName)
+	}
+
+	welcome := protocol.WelcomePayload{
+		Version:     version,
+		User:        user.UserName,
+		Rooms:       roomNames,
+		Epoch:       log.epoch,
+		Seq:         log.seq,
+		Resumed:     resumed,
+		Unread:      unread,
+		Invitations: invitations,
+	}
+	if err := s.sendFrame(conn, protocol.TypeWelcome, "", welcome); err != nil {
+		return fmt.Errorf("serveUser(...) s.sendFrame(...): %w", err)
+	}
+
+	var resumeFrom *uint64
+
+	if resumed {
+		resumeFrom = &hello.Resume.LastSeq
+	} else {
+		// The backlog goes out before the connection is attached to its rooms,
+		// so clients never see it interleaved with live messages.
+		for _, roomName := range roomNames {
+			if err := s.replayHistory(ctx, user.UserName, roomName, s.cfg.HistoryReplayLimit, conn); err != nil {
+				return fmt.Errorf("serveUser(...) s.replayHistory(...): %w", err)
+			}
+		}
+	}
+
+	if first := s.attachSession(log, roomNames, user, conn, resumeFrom); first {
+		s.goOnline(user.UserName, roomNames)
+	}
+
+	defer s.disconnect(ctx, user)
+
+	if err := s.deliverQueued(ctx, user.UserName, resumed); err != nil {
+		s.log.WithError(err).Warnf("Failed to deliver queued direct messages to %s", user.UserName)
+	}
+
+	if err := s.handleFrames(ctx, reader, user, version); err != nil {
+		return fmt.Errorf("serveUser(...) s.handleFrames(...): %w", err)
 	}
 
 	return nil
 }
 
-func (s *Server) handleMessages(conn net.Conn, user *models.User) error {
-	reader := bufio.NewReader(conn)
-
+// handleFrames serves client frames until the connection fails. Failures of single frames
+// are reported to the client with error frames and do not end the session.
+func (s *Server) handleFrames(ctx context.Context, reader *bufio.Reader, user *models.User, version int) error {
 	for {
-		msgText, err := reader.ReadString('\n')
+		line, err := reader.ReadBytes('\n')
 		if err != nil {
-			s.leaveRoom("general", user)
+			return fmt.Errorf("handleFrames reader.ReadBytes(...): %w", err)
+		}
 
-			return fmt.Errorf("handleMessages reader.ReadString(...): %w", err)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
 		}
 
-		var msg models.Message
-		if err := json.Unmarshal([]byte(msgText), &msg); err != nil {
-			fmt.Fprintf(conn, "Error parsing message: %v\n", err)
+		env, err := protocol.Decode(line)
+		if err != nil {
+			s.sendError(user.Conn, "", err)
 
 			continue
 		}
 
-		if msg.Receiver != "" && msg.Receiver != "everyone" {
-			if err := s.handleDirectMessage(msg, user); err != nil {
-				return fmt.Errorf("handleMessages s.handleDirectMessage(...): %w", err)
-			}
-		} else {
-			if err := s.broadcastToRoom("general", msg, user); err != nil {
-				return fmt.Errorf("handleMessages s.broadcastToRoom(...): %w", err)
-			}
+		if env.Version != version {
+			s.sendError(user.Conn, env.ID, fmt.Errorf("frame version %d, session version %d: %w",
+				env.Version, version, protocol.ErrUnsupportedVersion))
+
+			continue
+		}
+
+		if err := s.handleFrame(ctx, env, user); err != nil {
+			s.sendError(user.Conn, env.ID, err)
 		}
 	}
 }
 
-func (s *Server) handleDirectMessage(msg models.Message, sender *models.User) error {
-	recipientName := msg.Receiver
-	found := false
+func (s *Server) handleFrame(ctx context.Context, env protocol.Envelope, user *models.User) error {
+	switch env.Type {
+	case protocol.TypeMessage:
+		var msg models.Message
+		if err := env.DecodePayload(&msg); err != nil {
+			return fmt.Errorf("handleFrame: %w", err)
+		}
 
-	for _, room := range s.rooms {
-		for user, conn := range room.Members {
-			if user.UserName == recipientName {
-				if err := s.sendMessage(sender.UserName, recipientName, msg.Content, conn); err != nil {
-					return fmt.Errorf("handleDirectMessage failed to send message when found user: %w", err)
-				}
+		if fields := messageOwnedFields(msg, false); len(fields) > 0 {
+			return s.rejectOwnedFields(env.Type, fields, user)
+		}
 
-				found = true
+		handle := s.handleRoomMessage
+		typing := typingKey{user: user.UserName, room: msg.Room, receiver: msg.Receiver}
 
-				break
-			}
+		if msg.Receiver != "" {
+			handle = s.handleDirectMessage
+		} else if typing.room == "" {
+			typing.room = defaultRoom
 		}
 
-		if found {
-			break
+		s.stopTyping(typing)
+
+		ack, err := handle(ctx, msg, user)
+		if err != nil {
+			return err
+		}
+
+		return s.sendAck(user.Conn, env.ID, ack)
+	case protocol.TypeTyping:
+		var typing protocol.TypingPayload
+		if err := env.DecodePayload(&typing); err != nil {
+			return fmt.Errorf("handleFrame: %w", err)
+		}
+
+		if typing.User != "" {
+			return s.rejectOwnedFields(env.Type, []string{"user"}, user)
 		}
-	}
 
-	if !found {
-		errorMsg := fmt.Sprintf("User %s not found.", recipientName)
-		if err := s.sendMessage("Server", sender.UserName, errorMsg, sender.Conn); err != nil {
-			return fmt.Errorf("handleDirectMessage if !found s.sendMessage(...): %w", err)
+		return s.handleTyping(ctx, typing, user)
+	case protocol.TypeEdit, protocol.TypeDelete:
+		var msg models.Message
+		if err := env.DecodePayload(&msg); err != nil {
+			return fmt.Errorf("handleFrame: %w", err)
+		}
+
+		if fields := messageOwnedFields(msg, true); len(fields) > 0 {
+			return s.rejectOwnedFields(env.Type, fields, user)
+		}
+
+		changed, err := s.changeMessage(ctx, env.Type, msg, user)
+		if err != nil {
+			return err
 		}
+
+		return s.sendAck(user.Conn, env.ID, protocol.AckPayload{MessageID: changed.ID})
+	case protocol.TypeReaction:
+		var reaction models.Reaction
+		if err := env.DecodePayload(&reaction); err != nil {
+			return fmt.Errorf("handleFrame: %w", err)
+		}
+
+		if fields := reactionOwnedFields(reaction); len(fields) > 0 {
+			return s.rejectOwnedFields(env.Type, fields, user)
+		}
+
+		if err := s.handleReaction(ctx, reaction, user); err != nil {
+			return err
+		}
+
+		return s.sendAck(user.Conn, env.ID, protocol.AckPayload{MessageID: reaction.MessageID})
+	case protocol.TypeCommand:
+		var cmd protocol.CommandPayload
+		if err := env.DecodePayload(&cmd); err != nil {
+			return fmt.Errorf("handleFrame: %w", err)
+		}
+
+		result, err := s.handleCommand(ctx, cmd, user)
+		if err != nil {
+			return fmt.Errorf("handleFrame command %s: %w", cmd.Name, err)
+		}
+
+		return s.sendAck(user.Conn, env.ID, protocol.AckPayload{Result: result})
+	case protocol.TypeReauth:
+		var reauth protocol.ReauthPayload
+		if err := env.DecodePayload(&reauth); err != nil {
+			return fmt.Errorf("handleFrame: %w", err)
+		}
+
+		if reauth.ExpiresAt != nil {
+			return s.rejectOwnedFields(env.Type, []string{"expiresAt"}, user)
+		}
+
+		if err := s.reauthenticate(ctx, reauth, user); err != nil {
+			return err
+		}
+
+		return s.sendAck(user.Conn, env.ID, protocol.AckPayload{})
+	default:
+		return fmt.Errorf("handleFrame %q: %w", env.Type, models.ErrUnexpectedFrame)
+	}
+}
+
+// rejectOwnedFields refuses a frame setting fields only the server sets, e.g. a sender other than
+// the authenticated user. Such frames are logged as they are spoofing attempts or broken clients.
+func (s *Server) rejectOwnedFields(frameType string, fields []string, user *models.User) error {
+	s.log.Warnf("Rejected %s frame from %s setting %s", frameType, user.UserName, strings.Join(fields, ", "))
+
+	return ownedFieldsError(fields)
+}
+
+// messageAck confirms a stored message to its sender.
+func messageAck(saved *models.Message, created bool) protocol.AckPayload {
+	return protocol.AckPayload{
+		MessageID: saved.ID,
+		ClientID:  saved.ClientID,
+		CreatedAt: &saved.CreatedAt,
+		Duplicate: !created,
+	}
+}
+
+func (s *Server) handleRoomMessage(
+	ctx context.Context,
+	msg models.Message,
+	sender *models.User,
+) (protocol.AckPayload, error) {
+	roomName := msg.Room
+	if roomName == "" {
+		roomName = defaultRoom
+	}
+
+	if !s.isRoomMember(roomName, sender) {
+		return protocol.AckPayload{}, fmt.Errorf("handleRoomMessage #%s: %w", roomName, models.ErrNotRoomMember)
+	}
+
+	if err := s.moderationService.CheckCanPost(ctx, roomName, sender.UserName); err != nil {
+		return protocol.AckPayload{}, fmt.Errorf("handleRoomMessage #%s: %w", roomName, err)
+	}
+
+	saved, created, err := s.broadcastToRoom(ctx, roomName, msg, sender)
+	if err != nil {
+		return protocol.AckPayload{}, fmt.Errorf("handleRoomMessage s.broadcastToRoom(...): %w", err)
+	}
+
+	return messageAck(saved, created), nil
+}
+
+// changeMessage edits or deletes a stored message on behalf of the user and pushes the change
+// to everyone who received the message, the user's sessions included.
+func (s *Server) changeMessage(
+	ctx context.Context,
+	frameType string,
+	msg models.Message,
+	user *models.User,
+) (*models.Message, error) {
+	var (
+		changed *models.Message
+		err     error
+	)
+
+	if frameType == protocol.TypeEdit {
+		changed, err = s.messagesService.EditMessage(ctx, user.UserName, msg.ID, msg.Content)
+	} else {
+		changed, err = s.messagesService.DeleteMessage(ctx, user.UserName, msg.ID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("changeMessage %s #%d: %w", frameType, msg.ID, err)
+	}
+
+	s.deliverToRecipients(*changed, frameType, changed)
+
+	return changed, nil
+}
+
+// handleReaction adds or removes the user's reaction and pushes the updated summary when it changed.
+func (s *Server) handleReaction(ctx context.Context, reaction models.Reaction, user *models.User) error {
+	reaction.User = user.UserName
+
+	msg, changed, err := s.messagesService.React(ctx, reaction)
+	if err != nil {
+		return fmt.Errorf("handleReaction #%d: %w", reaction.MessageID, err)
+	}
+
+	if !changed {
+		return nil
 	}
 
+	reaction.Room = msg.Room
+	reaction.Reactions = msg.Reactions
+
+	s.deliverToRecipients(*msg, protocol.TypeReaction, reaction)
+
 	return nil
 }
 
-func (s *Server) sendMessage(
-	sender string,
-	receiver string,
-	content string,
-	conn net.Conn,
-) error {
-	message := models.Message{
-		Sender:    sender,
-		Receiver:  receiver,
-		Content:   content,
-		CreatedAt: time.Now(),
+// disconnect detaches the session from its rooms, the user goes offline with its last session.
+func (s *Server) disconnect(ctx context.Context, user *models.User) {
+	if roomNames, last := s.detachSession(user); last {
+		s.goOffline(context.WithoutCancel(ctx), user.UserName, roomNames)
+	}
+}
+
+// handleDirectMessage stores a direct message to a registered user and delivers it when the user
+// is connected. Otherwise the ack tells the sender it is queued until the user's next session.
+func (s *Server) handleDirectMessage(
+	ctx context.Context,
+	msg models.Message,
+	sender *models.User,
+) (protocol.AckPayload, error) {
+	recipientName := msg.Receiver
+
+	if _, err := s.authService.GetUserByUsername(ctx, recipientName); err != nil {
+		return protocol.AckPayload{}, fmt.Errorf("handleDirectMessage %s: %w", recipientName, err)
 	}
 
-	jsonMsg, err := json.Marshal(message)
+	msg.Room = ""
+	msg.Sender = sender.UserName
+
+	saved, created, err := s.messagesService.SaveMessage(ctx, msg)
 	if err != nil {
-		return fmt.Errorf("sendMessage(...) failed to marshal message: %w", err)
+		return protocol.AckPayload{}, fmt.Errorf("handleDirectMessage s.messagesService.SaveMessage(...): %w", err)
 	}
 
-	if _, err = conn.Write(append(jsonMsg, '\n')); err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+	ack := messageAck(saved, created)
+	if !created {
+		return ack, nil
+	}
+
+	delivered := *saved
+	delivered.ClientID = ""
+
+	live, err := s.deliverEventToUser(recipientName, protocol.TypeMessage, delivered)
+	if err != nil {
+		s.log.WithError(err).Warnf("Failed to deliver direct message to %s", recipientName)
+	}
+
+	if !live {
+		ack.Queued = true
+
+		return ack, nil
+	}
+
+	if err := s.messagesService.MarkDelivered(ctx, saved.ID); err != nil {
+		s.log.WithError(err).Warnf("Failed to mark direct message %d delivered", saved.ID)
+	}
+
+	return ack, nil
+}
+
+// deliverQueued sends the direct messages queued while the user was offline. A resumed session
+// already got the ones sent within the resume window among the missed events.
+func (s *Server) deliverQueued(ctx context.Context, username string, resumed bool) error {
+	queued, err := s.messagesService.UndeliveredDirectMessages(ctx, username)
+	if err != nil {
+		return fmt.Errorf("deliverQueued s.messagesService.UndeliveredDirectMessages(...): %w", err)
+	}
+
+	ids := make([]int, 0, len(queued))
+
+	for _, msg := range queued {
+		if !resumed {
+			if _, err := s.deliverEventToUser(username, protocol.TypeMessage, msg); err != nil {
+				return fmt.Errorf("deliverQueued s.deliverEventToUser(...): %w", err)
+			}
+		}
+
+		ids = append(ids, msg.ID)
+	}
+
+	if err := s.messagesService.MarkDelivered(ctx, ids...); err != nil {
+		return fmt.Errorf("deliverQueued s.messagesService.MarkDelivered(...): %w", err)
 	}
 
 	return nil
 }
 
-func (s *Server) getUserFromConn(ctx context.Context, conn net.Conn) (*models.User, error) {
-	clientReader := bufio.NewReader(conn)
+// replayHistory writes the latest messages of the room to the connection.
+func (s *Server) replayHistory(ctx context.Context, username string, roomName string, limit int, conn net.Conn) error {
+	if limit == 0 {
+		return nil
+	}
 
-	token, err := clientReader.ReadString('\n')
+	page, err := s.messagesService.RoomHistory(ctx, username, roomName, models.HistoryRequest{
+		Limit: min(limit, service.MaxHistoryLimit),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("getUserFromToken clientReader.ReadString: %w", err)
+		return fmt.Errorf("replayHistory s.messagesService.RoomHistory(...): %w", err)
+	}
+
+	return s.writeHistory(page.Messages, conn)
+}
+
+func (s *Server) writeHistory(messages []models.Message, conn net.Conn) error {
+	for _, msg := range messages {
+		msg.History = true
+
+		if err := s.writeMessage(msg, conn); err != nil {
+			return fmt.Errorf("writeHistory s.writeMessage(...): %w", err)
+		}
 	}
 
-	token = strings.TrimSpace(token)
+	return nil
+}
 
-	jwtToken, err := s.authService.ValidateToken(token)
+func (s *Server) getUserFromToken(ctx context.Context, token string) (*models.User, *sessionAuth, error) {
+	if token == "" {
+		return nil, nil, fmt.Errorf("getUserFromToken: no token: %w", models.ErrUnauthorized)
+	}
+
+	jwtToken, err := s.authService.ValidateToken(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("authentication failed: %w", err)
+		return nil, nil, fmt.Errorf("authentication failed: %w: %w", models.ErrUnauthorized, err)
 	}
 
 	claims, ok := jwtToken.Claims.(jwt.MapClaims)
 	if !ok {
-		return nil, fmt.Errorf("getUserFromToken: %w", models.ErrParseJWTClaimsAsMapClaims)
+		return nil, nil, fmt.Errorf("getUserFromToken: %w", models.ErrParseJWTClaimsAsMapClaims)
 	}
 
 	username, ok := claims["username"].(string)
 	if !ok {
-		return nil, fmt.Errorf("username claim is not a string: %w", models.ErrUsernameClaimIsNotString)
+		return nil, nil, fmt.Errorf("username claim is not a string: %w", models.ErrUsernameClaimIsNotString)
+	}
+
+	auth, err := newSessionAuth(jwtToken)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	user, err := s.authService.GetUserByUsername(ctx, username)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve user data: %w", err)
+		return nil, nil, fmt.Errorf("failed to retrieve user data: %w: %w", models.ErrUnauthorized, err)
 	}
 
-	return user, nil
+	return user, auth, nil
 }
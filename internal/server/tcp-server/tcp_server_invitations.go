@@ -0,0 +1,119 @@
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// commandInvite invites a user to a room: "invite <room> <user>".
+func (s *Server) commandInvite(ctx context.Context, args []string, user *models.User) (string, error) {
+	const inviteArgs = 2
+
+	if len(args) != inviteArgs {
+		return "", usageError("invite <room> <user>")
+	}
+
+	invitee := strings.TrimPrefix(args[1], "@")
+
+	invitation, err := s.roomsService.Invite(ctx, args[0], user.UserName, invitee)
+	if err != nil {
+		return "", fmt.Errorf("commandInvite s.roomsService.Invite(...): %w", err)
+	}
+
+	s.InvitationCreated(*invitation)
+
+	return fmt.Sprintf("You invited %s to #%s", invitee, args[0]), nil
+}
+
+// commandAccept joins a room the user was invited to: "accept <room>".
+func (s *Server) commandAccept(ctx context.Context, args []string, user *models.User) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("accept <room>")
+	}
+
+	roomName := args[0]
+
+	if err := s.roomsService.AcceptInvitation(ctx, roomName, user.UserName); err != nil {
+		return "", fmt.Errorf("commandAccept s.roomsService.AcceptInvitation(...): %w", err)
+	}
+
+	s.InvitationAccepted(roomName, user.UserName)
+
+	return "You joined #" + roomName, nil
+}
+
+// commandDecline turns an invitation down: "decline <room>".
+func (s *Server) commandDecline(ctx context.Context, args []string, user *models.User) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("decline <room>")
+	}
+
+	if err := s.roomsService.DeclineInvitation(ctx, args[0], user.UserName); err != nil {
+		return "", fmt.Errorf("commandDecline s.roomsService.DeclineInvitation(...): %w", err)
+	}
+
+	return "You declined the invitation to #" + args[0], nil
+}
+
+// commandInvitations lists the invitations waiting for the user's answer.
+func (s *Server) commandInvitations(ctx context.Context, user *models.User) (string, error) {
+	invitations, err := s.roomsService.ListInvitations(ctx, user.UserName)
+	if err != nil {
+		return "", fmt.Errorf("commandInvitations s.roomsService.ListInvitations(...): %w", err)
+	}
+
+	if len(invitations) == 0 {
+		return "No invitations", nil
+	}
+
+	rooms := make([]string, 0, len(invitations))
+	for _, invitation := range invitations {
+		rooms = append(rooms, fmt.Sprintf("#%s from %s", invitation.Room, invitation.Inviter))
+	}
+
+	return "Invitations: " + strings.Join(rooms, ", "), nil
+}
+
+// commandRole appoints a moderator or makes one a plain member again: "role <room> <user> moderator|member".
+func (s *Server) commandRole(ctx context.Context, args []string, user *models.User) (string, error) {
+	const roleArgs = 3
+
+	if len(args) != roleArgs {
+		return "", usageError("role <room> <user> moderator|member")
+	}
+
+	roomName, member, role := args[0], strings.TrimPrefix(args[1], "@"), args[2]
+
+	if err := s.roomsService.SetMemberRole(ctx, roomName, user.UserName, member, role); err != nil {
+		return "", fmt.Errorf("commandRole s.roomsService.SetMemberRole(...): %w", err)
+	}
+
+	return fmt.Sprintf("%s is now a %s of #%s", member, role, roomName), nil
+}
+
+// InvitationCreated tells the invitee about a new invitation, now or when its session resumes.
+func (s *Server) InvitationCreated(invitation models.RoomInvitation) {
+	if _, err := s.deliverEventToUser(invitation.Invitee, protocol.TypeInvitation, invitation); err != nil {
+		s.log.WithError(err).Warnf("Failed to send the invitation to #%s to %s", invitation.Room, invitation.Invitee)
+	}
+}
+
+// InvitationAccepted attaches the live sessions of the new member to the room and announces it to the members.
+func (s *Server) InvitationAccepted(roomName string, username string) {
+	attached, err := s.attachUserSessions(roomName, username)
+	if err != nil {
+		s.log.WithError(err).Warnf("Failed to attach %s to #%s", username, roomName)
+
+		return
+	}
+
+	if !attached {
+		return
+	}
+
+	s.announcePresence(roomName, protocol.PresencePayload{User: username, Event: protocol.PresenceJoined}, nil)
+}
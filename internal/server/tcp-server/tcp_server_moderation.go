@@ -0,0 +1,203 @@
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// moderationTarget is what a moderation command is aimed at.
+type moderationTarget struct {
+	room     string
+	user     string
+	duration time.Duration
+	reason   string
+}
+
+// parseModerationArgs parses "<room> <user> [duration] [reason]", the duration only when withDuration is set.
+// A third argument that is not a duration starts the reason.
+func parseModerationArgs(args []string, usage string, withDuration bool) (moderationTarget, error) {
+	const targetArgs = 2
+
+	if len(args) < targetArgs {
+		return moderationTarget{}, usageError(usage)
+	}
+
+	target := moderationTarget{room: args[0], user: strings.TrimPrefix(args[1], "@")}
+	rest := args[targetArgs:]
+
+	if withDuration && len(rest) > 0 {
+		if duration, err := time.ParseDuration(rest[0]); err == nil {
+			target.duration = duration
+			rest = rest[1:]
+		}
+	}
+
+	target.reason = strings.Join(rest, " ")
+
+	return target, nil
+}
+
+// commandKick removes a member from a room: "kick <room> <user> [reason]".
+func (s *Server) commandKick(ctx context.Context, args []string, user *models.User) (string, error) {
+	target, err := parseModerationArgs(args, "kick <room> <user> [reason]", false)
+	if err != nil {
+		return "", err
+	}
+
+	action, err := s.moderationService.Kick(ctx, target.room, user.UserName, target.user, target.reason)
+	if err != nil {
+		return "", fmt.Errorf("commandKick s.moderationService.Kick(...): %w", err)
+	}
+
+	s.ModerationApplied(*action)
+
+	return fmt.Sprintf("You kicked %s from #%s", target.user, target.room), nil
+}
+
+// commandBan removes a user from a room and keeps it out: "ban <room> <user> [duration] [reason]".
+func (s *Server) commandBan(ctx context.Context, args []string, user *models.User) (string, error) {
+	target, err := parseModerationArgs(args, "ban <room> <user> [duration] [reason]", true)
+	if err != nil {
+		return "", err
+	}
+
+	action, err := s.moderationService.Ban(ctx, target.room, user.UserName, target.user, target.duration, target.reason)
+	if err != nil {
+		return "", fmt.Errorf("commandBan s.moderationService.Ban(...): %w", err)
+	}
+
+	s.ModerationApplied(*action)
+
+	return fmt.Sprintf("You banned %s from #%s%s", target.user, target.room, formatExpiry(action.ExpiresAt)), nil
+}
+
+// commandMute keeps a member from posting to a room: "mute <room> <user> [duration] [reason]".
+func (s *Server) commandMute(ctx context.Context, args []string, user *models.User) (string, error) {
+	target, err := parseModerationArgs(args, "mute <room> <user> [duration] [reason]", true)
+	if err != nil {
+		return "", err
+	}
+
+	action, err := s.moderationService.Mute(ctx, target.room, user.UserName, target.user, target.duration, target.reason)
+	if err != nil {
+		return "", fmt.Errorf("commandMute s.moderationService.Mute(...): %w", err)
+	}
+
+	s.ModerationApplied(*action)
+
+	return fmt.Sprintf("You muted %s in #%s%s", target.user, target.room, formatExpiry(action.ExpiresAt)), nil
+}
+
+// commandLift lifts a ban or a mute: "unban|unmute <room> <user>".
+func (s *Server) commandLift(ctx context.Context, args []string, user *models.User, actionName string) (string, error) {
+	const liftArgs = 2
+
+	if len(args) != liftArgs {
+		return "", usageError(actionName + " <room> <user>")
+	}
+
+	roomName, target := args[0], strings.TrimPrefix(args[1], "@")
+
+	lift := s.moderationService.Unban
+	if actionName == models.ActionUnmute {
+		lift = s.moderationService.Unmute
+	}
+
+	action, err := lift(ctx, roomName, user.UserName, target)
+	if err != nil {
+		return "", fmt.Errorf("commandLift %s: %w", actionName, err)
+	}
+
+	s.ModerationApplied(*action)
+
+	if actionName == models.ActionUnmute {
+		return fmt.Sprintf("%s can post to #%s again", target, roomName), nil
+	}
+
+	return fmt.Sprintf("%s can join #%s again", target, roomName), nil
+}
+
+// ModerationApplied tells the target about the moderation action, a kicked or banned user's sessions
+// leave the room and its members see it go.
+func (s *Server) ModerationApplied(action models.ModerationAction) {
+	if action.Action == models.ActionKick || action.Action == models.ActionBan {
+		if s.detachUserSessions(action.Room, action.Target) {
+			s.announcePresence(action.Room, protocol.PresencePayload{User: action.Target, Event: protocol.PresenceLeft}, nil)
+		}
+	}
+
+	if _, err := s.deliverEventToUser(action.Target, protocol.TypeSystem, protocol.SystemPayload{
+		Text: moderationNotice(action),
+	}); err != nil {
+		s.log.WithError(err).Warnf("Failed to tell %s about the %s in #%s", action.Target, action.Action, action.Room)
+	}
+
+	s.log.Infof("%s: %s %s in #%s", action.Moderator, action.Action, action.Target, action.Room)
+}
+
+// detachUserSessions removes every session of the user from the room and reports whether there was one.
+// A session that may still be resumed stops getting the room's events too.
+func (s *Server) detachUserSessions(roomName string, username string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	room, exists := s.rooms[roomName]
+	if !exists {
+		return false
+	}
+
+	detached := false
+
+	for user := range room.Members {
+		if user.UserName == username {
+			delete(room.Members, user)
+			detached = true
+		}
+	}
+
+	delete(room.Silenced, username)
+
+	if log, exists := s.eventLogs[username]; exists {
+		log.forgetRoom(roomName)
+	}
+
+	return detached
+}
+
+// moderationNotice describes the action to its target.
+func moderationNotice(action models.ModerationAction) string {
+	var notice string
+
+	switch action.Action {
+	case models.ActionKick:
+		notice = fmt.Sprintf("You were kicked from #%s by %s", action.Room, action.Moderator)
+	case models.ActionBan:
+		notice = fmt.Sprintf("You were banned from #%s by %s%s",
+			action.Room, action.Moderator, formatExpiry(action.ExpiresAt))
+	case models.ActionMute:
+		notice = fmt.Sprintf("You were muted in #%s by %s%s", action.Room, action.Moderator, formatExpiry(action.ExpiresAt))
+	case models.ActionUnban:
+		return fmt.Sprintf("Your ban from #%s was lifted by %s", action.Room, action.Moderator)
+	default:
+		return fmt.Sprintf("You can post to #%s again, %s unmuted you", action.Room, action.Moderator)
+	}
+
+	if action.Reason != "" {
+		notice += ": " + action.Reason
+	}
+
+	return notice
+}
+
+func formatExpiry(expiresAt *time.Time) string {
+	if expiresAt == nil {
+		return ""
+	}
+
+	return " until " + expiresAt.Format(time.DateTime)
+}
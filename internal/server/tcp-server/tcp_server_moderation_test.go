@@ -0,0 +1,29 @@
+package tcpserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+func TestParseModerationArgs(t *testing.T) {
+	target, err := parseModerationArgs([]string{"dev", "@bob", "2h", "too", "loud"}, "ban", true)
+	require.NoError(t, err)
+	assert.Equal(t, moderationTarget{room: "dev", user: "bob", duration: 2 * time.Hour, reason: "too loud"}, target,
+		"the duration and the reason should follow the user")
+
+	target, err = parseModerationArgs([]string{"dev", "bob", "spam"}, "ban", true)
+	require.NoError(t, err)
+	assert.Equal(t, moderationTarget{room: "dev", user: "bob", reason: "spam"}, target,
+		"a reason without a duration should make a permanent ban")
+
+	target, err = parseModerationArgs([]string{"dev", "bob", "1h"}, "kick", false)
+	require.NoError(t, err)
+	assert.Equal(t, "1h", target.reason, "commands without a duration should take everything as the reason")
+
+	_, err = parseModerationArgs([]string{"dev"}, "kick", false)
+	assert.ErrorIs(t, err, models.ErrInvalidCommandArgs, "the user should be required")
+}
@@ -0,0 +1,210 @@
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+const lastSeenFormat = "2006-01-02 15:04"
+
+// setPresence records the user's presence state and reports whether it changed.
+func (s *Server) setPresence(username string, state string) (*models.Presence, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	presence, exists := s.presence[username]
+	if !exists {
+		presence = &models.Presence{Username: username, State: models.PresenceOffline}
+		s.presence[username] = presence
+	}
+
+	if presence.State == state {
+		return presence, false
+	}
+
+	presence.State = state
+
+	if state == models.PresenceOffline {
+		now := time.Now()
+		presence.LastSeenAt = &now
+	}
+
+	return presence, true
+}
+
+// goOnline marks the user online when its first session starts and tells the members of its rooms.
+func (s *Server) goOnline(username string, roomNames []string) {
+	if _, changed := s.setPresence(username, models.PresenceOnline); changed {
+		s.announcePresenceChange(roomNames, protocol.PresencePayload{User: username, Event: protocol.PresenceOnline})
+	}
+}
+
+// goOffline marks the user offline when its last session ends, stores when it was last seen
+// and tells the members of the rooms it was in.
+func (s *Server) goOffline(ctx context.Context, username string, roomNames []string) {
+	presence, changed := s.setPresence(username, models.PresenceOffline)
+	if !changed {
+		return
+	}
+
+	if err := s.usersService.TouchLastSeen(ctx, username); err != nil {
+		s.log.WithError(err).Warnf("Failed to store last seen time of %s", username)
+	}
+
+	s.announcePresenceChange(roomNames, protocol.PresencePayload{
+		User:       username,
+		Event:      protocol.PresenceOffline,
+		LastSeenAt: presence.LastSeenAt,
+	})
+}
+
+// announcePresenceChange tells the connected members of the rooms but the user itself about its presence change.
+func (s *Server) announcePresenceChange(roomNames []string, presence protocol.PresencePayload) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	recipients := make(map[string][]net.Conn)
+
+	for _, roomName := range roomNames {
+		room, exists := s.rooms[roomName]
+		if !exists {
+			continue
+		}
+
+		for user, conn := range room.Members {
+			if user.UserName != presence.User {
+				recipients[user.UserName] = append(recipients[user.UserName], conn)
+			}
+		}
+	}
+
+	if err := s.deliverEvent(recipients, protocol.TypePresence, presence); err != nil {
+		s.log.WithError(err).Warnf("Failed to announce presence of %s", presence.User)
+	}
+}
+
+// roomsOfUser returns the rooms any session of the user is in.
+func (s *Server) roomsOfUser(username string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var roomNames []string
+
+	for name, room := range s.rooms {
+		for user := range room.Members {
+			if user.UserName == username {
+				roomNames = append(roomNames, name)
+
+				break
+			}
+		}
+	}
+
+	return roomNames
+}
+
+// onlineUsers returns the users who are online and not away.
+func (s *Server) onlineUsers() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var usernames []string
+
+	for username, presence := range s.presence {
+		if presence.State == models.PresenceOnline {
+			usernames = append(usernames, username)
+		}
+	}
+
+	return usernames
+}
+
+// RoomPresence returns the presence of every member of the room, the requester has to be a member.
+func (s *Server) RoomPresence(ctx context.Context, roomName string, requester string) ([]models.Presence, error) {
+	members, err := s.roomsService.ListRoomMembers(ctx, roomName)
+	if err != nil {
+		return nil, fmt.Errorf("RoomPresence s.roomsService.ListRoomMembers(...): %w", err)
+	}
+
+	isMember := false
+	presences := make([]models.Presence, 0, len(members))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, member := range members {
+		isMember = isMember || member.UserName == requester
+
+		presence := models.Presence{Username: member.UserName, State: models.PresenceOffline, LastSeenAt: member.LastSeenAt}
+		if known, exists := s.presence[member.UserName]; exists {
+			presence.State = known.State
+			if known.LastSeenAt != nil {
+				presence.LastSeenAt = known.LastSeenAt
+			}
+		}
+
+		if presence.State != models.PresenceOffline {
+			presence.LastSeenAt = nil
+		}
+
+		presences = append(presences, presence)
+	}
+
+	if !isMember {
+		return nil, fmt.Errorf("RoomPresence #%s: %w", roomName, models.ErrNotRoomMember)
+	}
+
+	return presences, nil
+}
+
+func (s *Server) commandWho(ctx context.Context, args []string, user *models.User) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("who <room>")
+	}
+
+	presences, err := s.RoomPresence(ctx, args[0], user.UserName)
+	if err != nil {
+		return "", err
+	}
+
+	members := make([]string, 0, len(presences))
+
+	for _, presence := range presences {
+		state := presence.State
+		if presence.LastSeenAt != nil {
+			state += ", last seen " + presence.LastSeenAt.Format(lastSeenFormat)
+		}
+
+		members = append(members, fmt.Sprintf("%s (%s)", presence.Username, state))
+	}
+
+	return "Members of #" + args[0] + ": " + strings.Join(members, ", "), nil
+}
+
+// commandPresence switches the user between away and online, "away" and "back".
+func (s *Server) commandPresence(args []string, user *models.User, state string) (string, error) {
+	if len(args) != 0 {
+		return "", usageError("away | back")
+	}
+
+	if _, changed := s.setPresence(user.UserName, state); changed {
+		event := protocol.PresenceOnline
+		if state == models.PresenceAway {
+			event = protocol.PresenceAway
+		}
+
+		s.announcePresenceChange(s.roomsOfUser(user.UserName), protocol.PresencePayload{User: user.UserName, Event: event})
+	}
+
+	if state == models.PresenceAway {
+		return "You are away", nil
+	}
+
+	return "You are back online", nil
+}
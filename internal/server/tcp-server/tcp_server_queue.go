@@ -0,0 +1,200 @@
+package tcpserver
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stsolovey/kvant_chat/internal/config"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+// queuedConn decouples writers from a client's socket: Write only puts data into a bounded queue
+// drained by a dedicated goroutine, so a stalled client cannot block broadcasts to everyone else.
+// Reads go straight to the underlying connection.
+type queuedConn struct {
+	net.Conn
+
+	queue        chan []byte
+	policy       string
+	writeTimeout time.Duration
+	log          *logrus.Logger
+
+	writeMu   sync.Mutex // keeps the writer and the last frame of closeWith from interleaving on the socket
+	done      chan struct{}
+	stopped   chan struct{} // closed when the writer returned
+	broken    atomic.Bool   // the writer failed and closed the socket
+	closeOnce sync.Once
+	dropped   atomic.Int64
+}
+
+func newQueuedConn(conn net.Conn, cfg *config.Config, log *logrus.Logger) *queuedConn {
+	c := &queuedConn{
+		Conn:         conn,
+		queue:        make(chan []byte, cfg.OutboundQueueSize),
+		policy:       cfg.SlowClientPolicy,
+		writeTimeout: cfg.WriteTimeout,
+		log:          log,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+
+	go c.writeLoop()
+
+	return c
+}
+
+// Write enqueues a copy of p without blocking. When the queue is full the oldest queued data is dropped
+// or, with the disconnect policy, the connection is closed.
+func (c *queuedConn) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	for {
+		select {
+		case <-c.done:
+			return 0, net.ErrClosed
+		default:
+		}
+
+		if c.broken.Load() {
+			return 0, net.ErrClosed
+		}
+
+		select {
+		case c.queue <- data:
+			return len(p), nil
+		default:
+		}
+
+		if c.policy == config.SlowClientDisconnect {
+			c.log.Warnf("Disconnecting slow client %s: outbound queue is full", c.RemoteAddr())
+			c.shutdown()
+
+			return 0, models.ErrSlowClient
+		}
+
+		select {
+		case <-c.queue:
+			if c.dropped.Add(1) == 1 {
+				c.log.Warnf("Client %s falls behind, dropping oldest outbound messages", c.RemoteAddr())
+			}
+		default:
+		}
+	}
+}
+
+func (c *queuedConn) Close() error {
+	c.shutdown()
+
+	return nil
+}
+
+// shutdown stops the writer and closes the socket, which also ends the session blocked on reading it.
+func (c *queuedConn) shutdown() {
+	c.closeWith(nil)
+}
+
+// closeWith shuts the connection down like shutdown, writing a last frame straight to the socket first
+// so the client learns why its session ends even when its queue is backed up.
+func (c *queuedConn) closeWith(last []byte) {
+	c.close(func() {
+		if last == nil {
+			return
+		}
+
+		if err := c.writeSocket(last); err != nil {
+			c.log.WithError(err).Warnf("Failed to write the last frame to client %s", c.RemoteAddr())
+		}
+	})
+}
+
+// flush shuts the connection down like shutdown once the queued frames are written, giving up after
+// the write timeout, so the frames sent right before a session ends, like its error frame, are not lost.
+func (c *queuedConn) flush() {
+	c.close(func() {
+		<-c.stopped
+
+		if c.broken.Load() {
+			return
+		}
+
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			c.log.WithError(err).Warnf("Failed to set write deadline for %s", c.RemoteAddr())
+		}
+
+		for {
+			select {
+			case data := <-c.queue:
+				if _, err := c.Conn.Write(data); err != nil {
+					c.log.WithError(err).Warnf("Failed to flush the queue of client %s", c.RemoteAddr())
+
+					return
+				}
+			default:
+				return
+			}
+		}
+	})
+}
+
+// close stops the writer, runs last and closes the socket, once.
+func (c *queuedConn) close(last func()) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		last()
+
+		if err := c.Conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			c.log.WithError(err).Warnf("Error closing connection %s", c.RemoteAddr())
+		}
+
+		if dropped := c.dropped.Load(); dropped > 0 {
+			c.log.Warnf("Dropped %d outbound messages for client %s", dropped, c.RemoteAddr())
+		}
+	})
+}
+
+// writeLoop writes the queued data to the socket. On a failed write it closes the socket, ending the session
+// reading it, but leaves the shutdown to the session: flush waits for the writer to return from within it.
+func (c *queuedConn) writeLoop() {
+	defer close(c.stopped)
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.queue:
+			if err := c.writeSocket(data); err != nil {
+				c.log.WithError(err).Warnf("Failed to write to client %s, disconnecting", c.RemoteAddr())
+				c.broken.Store(true)
+
+				if err := c.Conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+					c.log.WithError(err).Warnf("Error closing connection %s", c.RemoteAddr())
+				}
+
+				return
+			}
+		}
+	}
+}
+
+// writeSocket writes data to the socket within the write timeout.
+func (c *queuedConn) writeSocket(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+		c.log.WithError(err).Warnf("Failed to set write deadline for %s", c.RemoteAddr())
+	}
+
+	_, err := c.Conn.Write(data)
+
+	return err //nolint:wrapcheck // logged by the callers with the client address.
+}
@@ -0,0 +1,143 @@
+package tcpserver
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/config"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+func newTestQueuedConn(t *testing.T, policy string, writeTimeout time.Duration) (*queuedConn, net.Conn) {
+	t.Helper()
+
+	serverSide, clientSide := net.Pipe()
+	cfg := &config.Config{OutboundQueueSize: 2, SlowClientPolicy: policy, WriteTimeout: writeTimeout}
+
+	conn := newQueuedConn(serverSide, cfg, logrus.New())
+	t.Cleanup(func() {
+		conn.shutdown()
+		clientSide.Close()
+	})
+
+	return conn, clientSide
+}
+
+// waitQueueDrained waits until the writer has taken everything queued, it then blocks on the unread pipe.
+func waitQueueDrained(t *testing.T, conn *queuedConn) {
+	t.Helper()
+
+	require.Eventually(t, func() bool { return len(conn.queue) == 0 }, time.Second, time.Millisecond)
+}
+
+func TestQueuedConnDropOldest(t *testing.T) {
+	conn, clientSide := newTestQueuedConn(t, config.SlowClientDropOldest, time.Second)
+
+	_, err := conn.Write([]byte("m1\n"))
+	require.NoError(t, err)
+	waitQueueDrained(t, conn)
+
+	for _, line := range []string{"m2\n", "m3\n", "m4\n", "m5\n"} {
+		_, err := conn.Write([]byte(line))
+		assert.NoError(t, err, "a full queue should not fail writes with drop_oldest")
+	}
+
+	reader := bufio.NewReader(clientSide)
+	for _, expected := range []string{"m1\n", "m4\n", "m5\n"} {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, expected, line, "the oldest queued messages should be dropped")
+	}
+}
+
+func TestQueuedConnDisconnect(t *testing.T) {
+	conn, _ := newTestQueuedConn(t, config.SlowClientDisconnect, time.Second)
+
+	_, err := conn.Write([]byte("m1\n"))
+	require.NoError(t, err)
+	waitQueueDrained(t, conn)
+
+	for _, line := range []string{"m2\n", "m3\n"} {
+		_, err := conn.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	_, err = conn.Write([]byte("m4\n"))
+	assert.ErrorIs(t, err, models.ErrSlowClient, "a full queue should disconnect the client")
+
+	_, err = conn.Write([]byte("m5\n"))
+	assert.ErrorIs(t, err, net.ErrClosed, "a disconnected client should not accept writes")
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err, "the session reading a disconnected client should end")
+}
+
+func TestQueuedConnStalledWriterTimesOut(t *testing.T) {
+	conn, _ := newTestQueuedConn(t, config.SlowClientDropOldest, 10*time.Millisecond)
+
+	_, err := conn.Write([]byte("m1\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, err := conn.Write([]byte("m2\n"))
+		return err != nil
+	}, time.Second, 5*time.Millisecond, "a client not reading past the write timeout should be disconnected")
+}
+
+func TestQueuedConnFlush(t *testing.T) {
+	conn, clientSide := newTestQueuedConn(t, config.SlowClientDropOldest, time.Second)
+
+	_, err := conn.Write([]byte("m1\n"))
+	require.NoError(t, err)
+	waitQueueDrained(t, conn)
+
+	for _, line := range []string{"m2\n", "m3\n"} {
+		_, err := conn.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	go conn.flush()
+
+	reader := bufio.NewReader(clientSide)
+	for _, expected := range []string{"m1\n", "m2\n", "m3\n"} {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, expected, line, "the queued frames should be written before the connection closes")
+	}
+
+	_, err = reader.ReadByte()
+	assert.Error(t, err, "the connection should be closed after the queued frames")
+}
+
+func TestQueuedConnFlushStalledClient(t *testing.T) {
+	conn, _ := newTestQueuedConn(t, config.SlowClientDropOldest, 10*time.Millisecond)
+
+	// m1 blocks the writer on the unread pipe until the write timeout, m2 waits in the queue.
+	_, err := conn.Write([]byte("m1\n"))
+	require.NoError(t, err)
+	waitQueueDrained(t, conn)
+
+	_, err = conn.Write([]byte("m2\n"))
+	require.NoError(t, err)
+
+	flushed := make(chan struct{})
+
+	go func() {
+		conn.flush()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		require.FailNow(t, "flush should give up on a stalled client")
+	}
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err, "the connection should be closed")
+}
@@ -0,0 +1,195 @@
+package tcpserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+const epochByteCount = 8
+
+// eventLog numbers the events delivered to one user and keeps the latest of them, so a session
+// of the user reconnecting within the resume window can catch up on what it missed.
+// It is guarded by the server mutex.
+type eventLog struct {
+	epoch  string
+	seq    uint64
+	frames []loggedFrame // oldest first
+	limit  int
+
+	sessions int // live sessions of the user
+	// Rooms of the user's last session, their events are still recorded until detachedUntil.
+	detachedRooms []string
+	detachedUntil time.Time
+}
+
+type loggedFrame struct {
+	seq  uint64
+	data []byte
+}
+
+func newEventLog(limit int) (*eventLog, error) {
+	buf := make([]byte, epochByteCount)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("newEventLog rand.Read(...): %w", err)
+	}
+
+	return &eventLog{epoch: hex.EncodeToString(buf), limit: limit}, nil
+}
+
+// record assigns the next sequence number to the event and keeps its frame.
+func (l *eventLog) record(frameType string, payload any) ([]byte, error) {
+	data, err := protocol.EncodeEvent(frameType, l.seq+1, payload)
+	if err != nil {
+		return nil, fmt.Errorf("eventLog record: %w", err)
+	}
+
+	l.seq++
+
+	if l.limit > 0 {
+		l.frames = append(l.frames, loggedFrame{seq: l.seq, data: data})
+		if len(l.frames) > l.limit {
+			l.frames = slices.Delete(l.frames, 0, len(l.frames)-l.limit)
+		}
+	}
+
+	return data, nil
+}
+
+// since returns the frames of the events after lastSeq, false when some of them are no longer kept.
+func (l *eventLog) since(lastSeq uint64) ([][]byte, bool) {
+	switch {
+	case lastSeq > l.seq:
+		return nil, false
+	case lastSeq == l.seq:
+		return nil, true
+	case len(l.frames) == 0 || l.frames[0].seq > lastSeq+1:
+		return nil, false
+	}
+
+	missed := make([][]byte, 0, l.seq-lastSeq)
+	for _, frame := range l.frames[lastSeq+1-l.frames[0].seq:] {
+		missed = append(missed, frame.data)
+	}
+
+	return missed, true
+}
+
+// alive reports whether the user has a live session or may still resume its last one.
+func (l *eventLog) alive(now time.Time) bool {
+	return l.sessions > 0 || now.Before(l.detachedUntil)
+}
+
+// recordsDetached reports whether events of the room are recorded for a user without live sessions.
+func (l *eventLog) recordsDetached(roomName string, now time.Time) bool {
+	return l.sessions == 0 && now.Before(l.detachedUntil) && slices.Contains(l.detachedRooms, roomName)
+}
+
+// forgetRoom stops recording events of the room for the user, who is no longer a member of it.
+func (l *eventLog) forgetRoom(roomName string) {
+	// detachedRooms is shared with the caller of detachSession, it is not changed in place.
+	l.detachedRooms = slices.DeleteFunc(slices.Clone(l.detachedRooms), func(name string) bool { return name == roomName })
+}
+
+// openEventLog prepares the user's event log for a new session and reports whether the session
+// resumes from the event the client saw last. A log outliving the resume window starts a new epoch.
+func (s *Server) openEventLog(username string, resume *protocol.ResumePayload) (*eventLog, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log, exists := s.eventLogs[username]
+	if !exists || !log.alive(time.Now()) {
+		var err error
+
+		log, err = newEventLog(s.cfg.ResumeBufferSize)
+		if err != nil {
+			return nil, false, fmt.Errorf("openEventLog: %w", err)
+		}
+
+		s.eventLogs[username] = log
+	}
+
+	if resume == nil || resume.Epoch != log.epoch {
+		return log, false, nil
+	}
+
+	_, complete := log.since(resume.LastSeq)
+
+	return log, complete, nil
+}
+
+// attachSession starts delivering live events of the given rooms to the connection. A resumed session
+// first gets the events it missed, both happen under the mutex so nothing falls in between.
+// It reports whether this is the only live session of the user.
+func (s *Server) attachSession(
+	log *eventLog,
+	roomNames []string,
+	user *models.User,
+	conn net.Conn,
+	resumeFrom *uint64,
+) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if resumeFrom != nil {
+		missed, complete := log.since(*resumeFrom)
+		if !complete {
+			s.log.Warnf("Events missed by %s are no longer kept, resuming with a gap", user.UserName)
+		}
+
+		for _, data := range missed {
+			if _, err := conn.Write(data); err != nil {
+				s.log.WithError(err).Warnf("Failed to resume session of %s", user.UserName)
+
+				break
+			}
+		}
+	}
+
+	for _, roomName := range roomNames {
+		if room, exists := s.rooms[roomName]; exists {
+			room.Members[user] = conn
+		}
+	}
+
+	log.sessions++
+	log.detachedRooms = nil
+
+	return log.sessions == 1
+}
+
+// detachSession drops the connected user from every room without touching stored memberships
+// and returns those rooms, reporting whether it was the last live session of the user.
+// Events of the rooms keep being recorded for the resume window after the user's last session ends.
+func (s *Server) detachSession(user *models.User) ([]string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var roomNames []string
+
+	for name, room := range s.rooms {
+		if _, member := room.Members[user]; member {
+			roomNames = append(roomNames, name)
+			delete(room.Members, user)
+		}
+	}
+
+	log, exists := s.eventLogs[user.UserName]
+	if !exists {
+		return roomNames, true
+	}
+
+	log.sessions--
+	if log.sessions == 0 {
+		log.detachedRooms = roomNames
+		log.detachedUntil = time.Now().Add(s.cfg.ResumeWindow)
+	}
+
+	return roomNames, log.sessions == 0
+}
@@ -0,0 +1,98 @@
+package tcpserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/config"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+func recordEvents(t *testing.T, log *eventLog, count int) {
+	t.Helper()
+
+	for range count {
+		_, err := log.record(protocol.TypeSystem, protocol.SystemPayload{Text: "event"})
+		require.NoError(t, err)
+	}
+}
+
+func seqsOf(t *testing.T, frames [][]byte) []uint64 {
+	t.Helper()
+
+	seqs := make([]uint64, 0, len(frames))
+
+	for _, frame := range frames {
+		env, err := protocol.Decode(frame)
+		require.NoError(t, err)
+
+		seqs = append(seqs, env.Seq)
+	}
+
+	return seqs
+}
+
+func TestEventLogSince(t *testing.T) {
+	log, err := newEventLog(3)
+	require.NoError(t, err)
+
+	recordEvents(t, log, 5)
+	assert.Equal(t, uint64(5), log.seq, "every event should get the next sequence number")
+
+	missed, complete := log.since(3)
+	assert.True(t, complete, "events still kept should be resumable")
+	assert.Equal(t, []uint64{4, 5}, seqsOf(t, missed), "only events after the last seen one should be replayed")
+
+	missed, complete = log.since(5)
+	assert.True(t, complete, "a client that saw everything should resume without events")
+	assert.Empty(t, missed)
+
+	_, complete = log.since(1)
+	assert.False(t, complete, "a gap longer than the buffer should not be resumable")
+
+	_, complete = log.since(6)
+	assert.False(t, complete, "a sequence from the future should not be resumable")
+}
+
+func TestEventLogDetached(t *testing.T) {
+	log, err := newEventLog(10)
+	require.NoError(t, err)
+
+	now := time.Now()
+	log.detachedRooms = []string{"general"}
+	log.detachedUntil = now.Add(time.Minute)
+
+	assert.True(t, log.alive(now), "a detached session should be resumable within the window")
+	assert.True(t, log.recordsDetached("general", now), "events of the detached rooms should be recorded")
+	assert.False(t, log.recordsDetached("random", now), "events of other rooms should not be recorded")
+	assert.False(t, log.alive(now.Add(2*time.Minute)), "a detached session should expire after the window")
+
+	log.sessions = 1
+	assert.False(t, log.recordsDetached("general", now), "live sessions get events through their rooms")
+}
+
+func TestBannedWhileDetached(t *testing.T) {
+	cfg := &config.Config{ResumeWindow: time.Minute, ResumeBufferSize: 10}
+	s, users, _ := newTestRoomServer(t, cfg, "alice1", "bobbob")
+
+	log, _, err := s.openEventLog("bobbob", nil)
+	require.NoError(t, err)
+
+	log.sessions = 1
+	delete(s.connUsers, users["bobbob"].Conn)
+	s.detachSession(users["bobbob"])
+
+	require.NoError(t, s.deliverToRoom(defaultRoom, models.Message{ID: 1, Content: "hi"}, users["alice1"], nil))
+	assert.Equal(t, uint64(1), log.seq, "a detached member should get the room's events on resume")
+
+	s.ModerationApplied(models.ModerationAction{
+		Action: models.ActionBan, Room: defaultRoom, Target: "bobbob", Moderator: "alice1",
+	})
+	assert.Equal(t, uint64(2), log.seq, "the banned user should learn about the ban on resume")
+
+	require.NoError(t, s.deliverToRoom(defaultRoom, models.Message{ID: 2, Content: "secret"}, users["alice1"], nil))
+	assert.Equal(t, uint64(2), log.seq, "a banned user should not get the room's events on resume")
+}
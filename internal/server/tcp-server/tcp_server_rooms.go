@@ -0,0 +1,217 @@
+package tcpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+const defaultRoom = "general"
+
+// loadRooms makes every room stored in the database available for joining.
+func (s *Server) loadRooms(ctx context.Context) error {
+	if err := s.roomsService.EnsureRoom(ctx, defaultRoom); err != nil {
+		return fmt.Errorf("loadRooms s.roomsService.EnsureRoom(...): %w", err)
+	}
+
+	rooms, err := s.roomsService.ListRooms(ctx)
+	if err != nil {
+		return fmt.Errorf("loadRooms s.roomsService.ListRooms(...): %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, room := range rooms {
+		if _, exists := s.rooms[room.Name]; !exists {
+			s.rooms[room.Name] = &models.Room{
+				ID:        room.ID,
+				Name:      room.Name,
+				Private:   room.Private,
+				CreatedAt: room.CreatedAt,
+				Members:   make(map[*models.User]net.Conn),
+			}
+		}
+	}
+
+	return nil
+}
+
+// userRooms returns the rooms the user has joined before, a user without memberships
+// is joined to the default room.
+func (s *Server) userRooms(ctx context.Context, user *models.User) ([]string, error) {
+	roomNames, err := s.roomsService.ListUserRooms(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("userRooms s.roomsService.ListUserRooms(...): %w", err)
+	}
+
+	if len(roomNames) == 0 {
+		err := s.roomsService.JoinRoom(ctx, defaultRoom, user)
+		if errors.Is(err, models.ErrBannedFromRoom) {
+			return roomNames, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("userRooms s.roomsService.JoinRoom(...): %w", err)
+		}
+
+		return []string{defaultRoom}, nil
+	}
+
+	return roomNames, nil
+}
+
+// createRoom creates the room and attaches every live session of its creator to it.
+func (s *Server) createRoom(ctx context.Context, roomName string, private bool, user *models.User) error {
+	room, err := s.roomsService.CreateRoom(ctx, roomName, user, private)
+	if err != nil {
+		return fmt.Errorf("createRoom %q: %w", roomName, err)
+	}
+
+	s.mutex.Lock()
+	if _, exists := s.rooms[roomName]; !exists {
+		s.rooms[roomName] = &models.Room{
+			ID:        room.ID,
+			Name:      room.Name,
+			Private:   room.Private,
+			CreatedAt: room.CreatedAt,
+			Members:   make(map[*models.User]net.Conn),
+		}
+	}
+	s.mutex.Unlock()
+
+	if _, err := s.attachUserSessions(roomName, user.UserName); err != nil {
+		return fmt.Errorf("createRoom: %w", err)
+	}
+
+	return nil
+}
+
+// joinRoom joins the user to the room and attaches every live session of the user to it.
+func (s *Server) joinRoom(ctx context.Context, roomName string, user *models.User) error {
+	if err := s.roomsService.JoinRoom(ctx, roomName, user); err != nil {
+		return fmt.Errorf("joinRoom %q: %w", roomName, err)
+	}
+
+	if _, err := s.attachUserSessions(roomName, user.UserName); err != nil {
+		return fmt.Errorf("joinRoom: %w", err)
+	}
+
+	return nil
+}
+
+// attachUserSessions adds every live session of the user to the room and reports whether there was one.
+func (s *Server) attachUserSessions(roomName string, username string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	room, exists := s.rooms[roomName]
+	if !exists {
+		return false, fmt.Errorf("attachUserSessions %q: %w", roomName, models.ErrRoomNotExists)
+	}
+
+	attached := false
+
+	for conn, user := range s.connUsers {
+		if user.UserName == username {
+			room.Members[user] = conn
+			attached = true
+		}
+	}
+
+	return attached, nil
+}
+
+// leaveRoom removes the user from the room and detaches every live session of the user from it.
+func (s *Server) leaveRoom(ctx context.Context, roomName string, user *models.User) error {
+	if err := s.roomsService.LeaveRoom(ctx, roomName, user); err != nil {
+		return fmt.Errorf("leaveRoom %q: %w", roomName, err)
+	}
+
+	s.detachUserSessions(roomName, user.UserName)
+
+	return nil
+}
+
+// setRoomSilenced silences the room for the user or lets its messages through again.
+func (s *Server) setRoomSilenced(ctx context.Context, roomName string, user *models.User, silenced bool) error {
+	if err := s.roomsService.SetRoomSilenced(ctx, roomName, user, silenced); err != nil {
+		return fmt.Errorf("setRoomSilenced %q: %w", roomName, err)
+	}
+
+	s.markSilenced([]string{roomName}, user.UserName, silenced)
+
+	return nil
+}
+
+// loadSilencedRooms remembers the rooms the user silenced in an earlier session.
+func (s *Server) loadSilencedRooms(ctx context.Context, user *models.User) error {
+	roomNames, err := s.roomsService.ListSilencedRooms(ctx, user)
+	if err != nil {
+		return fmt.Errorf("loadSilencedRooms s.roomsService.ListSilencedRooms(...): %w", err)
+	}
+
+	s.markSilenced(roomNames, user.UserName, true)
+
+	return nil
+}
+
+func (s *Server) markSilenced(roomNames []string, username string, silenced bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, roomName := range roomNames {
+		room, exists := s.rooms[roomName]
+		if !exists {
+			continue
+		}
+
+		if !silenced {
+			delete(room.Silenced, username)
+
+			continue
+		}
+
+		if room.Silenced == nil {
+			room.Silenced = make(map[string]bool)
+		}
+
+		room.Silenced[username] = true
+	}
+}
+
+func (s *Server) isRoomMember(roomName string, user *models.User) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	room, exists := s.rooms[roomName]
+	if !exists {
+		return false
+	}
+
+	_, member := room.Members[user]
+
+	return member
+}
+
+// listRooms returns the public rooms and the private ones the user is in.
+func (s *Server) listRooms(user *models.User) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	names := make([]string, 0, len(s.rooms))
+
+	for name, room := range s.rooms {
+		if _, member := room.Members[user]; !room.Private || member {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,71 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/config"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+// fakeRooms accepts every room change, its other methods are not to be called.
+type fakeRooms struct {
+	service.RoomsServiceInterface
+}
+
+func (fakeRooms) CreateRoom(_ context.Context, name string, _ *models.User, private bool) (*models.Room, error) {
+	return &models.Room{Name: name, Private: private}, nil
+}
+
+func (fakeRooms) JoinRoom(context.Context, string, *models.User) error {
+	return nil
+}
+
+func (fakeRooms) LeaveRoom(context.Context, string, *models.User) error {
+	return nil
+}
+
+func roomMembers(s *Server, roomName string) []*models.User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	members := make([]*models.User, 0, len(s.rooms[roomName].Members))
+	for user := range s.rooms[roomName].Members {
+		members = append(members, user)
+	}
+
+	return members
+}
+
+func TestRoomChangesApplyToEverySession(t *testing.T) {
+	s, users, _ := newTestRoomServer(t, &config.Config{}, "alice1", "bobbob")
+	s.roomsService = fakeRooms{}
+	ctx := context.Background()
+
+	// A second session of alice1, on another device.
+	serverSide, clientSide := net.Pipe()
+	t.Cleanup(func() {
+		serverSide.Close()
+		clientSide.Close()
+	})
+
+	other := &models.User{UserName: "alice1", Conn: serverSide}
+	s.connUsers[serverSide] = other
+
+	require.NoError(t, s.createRoom(ctx, "dev", false, users["alice1"]))
+	assert.ElementsMatch(t, []*models.User{users["alice1"], other}, roomMembers(s, "dev"),
+		"every session of the creator should be in the room")
+
+	require.NoError(t, s.joinRoom(ctx, "dev", users["bobbob"]))
+
+	require.NoError(t, s.createRoom(ctx, "dev", false, users["alice1"]))
+	assert.ElementsMatch(t, []*models.User{users["alice1"], other, users["bobbob"]}, roomMembers(s, "dev"),
+		"an existing room should keep its members")
+
+	require.NoError(t, s.leaveRoom(ctx, "dev", other))
+	assert.Equal(t, []*models.User{users["bobbob"]}, roomMembers(s, "dev"), "every session of the user should leave")
+}
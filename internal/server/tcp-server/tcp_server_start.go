@@ -2,12 +2,17 @@ package tcpserver
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 )
 
 func (s *Server) Start(ctx context.Context) error {
+	if err := s.loadRooms(ctx); err != nil {
+		return fmt.Errorf("TCP Server Start s.loadRooms(...): %w", err)
+	}
+
 	var err error
 
 	s.listener, err = net.Listen("tcp", ":"+s.cfg.TCPPort)
@@ -15,13 +20,21 @@ func (s *Server) Start(ctx context.Context) error {
 		return fmt.Errorf("TCP Server Start net.Listen(...): %w", err)
 	}
 
+	if s.cfg.TLSConfig != nil {
+		s.listener = tls.NewListener(s.listener, s.cfg.TLSConfig)
+	}
+
 	defer func() {
 		if err = s.listener.Close(); err != nil {
 			s.log.Errorf("TCP Server Start s.listener.Close(): %v", err)
 		}
 	}()
 
-	s.log.Info("TCP Server listening on port ", s.cfg.TCPPort)
+	if s.cfg.TLSConfig != nil {
+		s.log.Info("TCP Server listening with TLS on port ", s.cfg.TCPPort)
+	} else {
+		s.log.Info("TCP Server listening on port ", s.cfg.TCPPort)
+	}
 
 	connChan := make(chan net.Conn)
 	errChan := make(chan error)
@@ -30,11 +43,23 @@ func (s *Server) Start(ctx context.Context) error {
 		for {
 			conn, err := s.listener.Accept()
 			if err != nil {
-				errChan <- err
+				select {
+				case errChan <- err:
+				case <-ctx.Done():
+				}
+
+				return
+			}
+
+			select {
+			case connChan <- conn:
+			case <-ctx.Done():
+				if err := conn.Close(); err != nil {
+					s.log.WithError(err).Warn("TCP Server Start conn.Close()")
+				}
 
 				return
 			}
-			connChan <- conn
 		}
 	}()
 
@@ -53,8 +78,9 @@ func (s *Server) Start(ctx context.Context) error {
 				continue
 			case conn := <-connChan:
 				go func() {
-					if err = s.handleConnection(ctx, conn); err != nil {
-						errChan <- fmt.Errorf("TCP Server Start s.handleConnection(...): %w", err)
+					err := s.ServeConn(ctx, conn, nil, nil)
+					if err != nil && !errors.Is(err, net.ErrClosed) {
+						s.log.WithError(err).Error("TCP Server Start s.ServeConn(...)")
 					}
 				}()
 			}
@@ -0,0 +1,117 @@
+package tcpserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// typingKey identifies who is typing where, one of room and receiver is set.
+type typingKey struct {
+	user     string
+	room     string
+	receiver string
+}
+
+// handleTyping starts, refreshes or stops the sender's typing indicator. Only starts and stops are
+// fanned out, an indicator not refreshed within the typing timeout stops by itself.
+// Users muted in a room cannot start typing there, like they cannot post, and direct message
+// indicators only start for registered users.
+func (s *Server) handleTyping(ctx context.Context, typing protocol.TypingPayload, sender *models.User) error {
+	key := typingKey{user: sender.UserName, receiver: typing.Receiver}
+
+	if typing.Receiver != "" && typing.Active {
+		if _, err := s.authService.GetUserByUsername(ctx, typing.Receiver); err != nil {
+			return fmt.Errorf("handleTyping %s: %w", typing.Receiver, err)
+		}
+	}
+
+	if typing.Receiver == "" {
+		key.room = typing.Room
+		if key.room == "" {
+			key.room = defaultRoom
+		}
+
+		if !s.isRoomMember(key.room, sender) {
+			return fmt.Errorf("handleTyping #%s: %w", key.room, models.ErrNotRoomMember)
+		}
+
+		if typing.Active {
+			if err := s.moderationService.CheckCanPost(ctx, key.room, sender.UserName); err != nil {
+				return fmt.Errorf("handleTyping #%s: %w", key.room, err)
+			}
+		}
+	}
+
+	if typing.Active {
+		s.startTyping(key)
+	} else {
+		s.stopTyping(key)
+	}
+
+	return nil
+}
+
+func (s *Server) startTyping(key typingKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if timer, typing := s.typing[key]; typing {
+		timer.Reset(s.cfg.TypingTimeout)
+
+		return
+	}
+
+	s.typing[key] = time.AfterFunc(s.cfg.TypingTimeout, func() { s.stopTyping(key) })
+	s.fanOutTyping(key, true)
+}
+
+// stopTyping ends the typing indicator, e.g. when it expires or the message is sent.
+func (s *Server) stopTyping(key typingKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	timer, typing := s.typing[key]
+	if !typing {
+		return
+	}
+
+	timer.Stop()
+	delete(s.typing, key)
+	s.fanOutTyping(key, false)
+}
+
+// fanOutTyping enqueues the typing event to the connected room members or the direct message partner.
+// It must be called with the mutex held.
+func (s *Server) fanOutTyping(key typingKey, active bool) {
+	event := protocol.TypingPayload{User: key.user, Room: key.room, Receiver: key.receiver, Active: active}
+
+	var conns []net.Conn
+
+	if key.receiver != "" {
+		conns = s.userConns(key.receiver)
+	} else if room, exists := s.rooms[key.room]; exists {
+		for user, conn := range room.Members {
+			if user.UserName != key.user {
+				conns = append(conns, conn)
+			}
+		}
+	}
+
+	data, err := protocol.Encode(protocol.TypeTyping, "", event)
+	if err != nil {
+		s.log.WithError(err).Warn("fanOutTyping protocol.Encode(...)")
+
+		return
+	}
+
+	for _, conn := range conns {
+		if _, err := conn.Write(data); err != nil {
+			s.log.WithError(err).Warnf("fanOutTyping from %s", key.user)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package tcpserver
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stsolovey/kvant_chat/internal/app/service"
+	"github.com/stsolovey/kvant_chat/internal/config"
+	"github.com/stsolovey/kvant_chat/internal/models"
+	"github.com/stsolovey/kvant_chat/internal/protocol"
+)
+
+// fakeModeration mutes the users of muted in every room, its other methods are not to be called.
+type fakeModeration struct {
+	service.ModerationServiceInterface
+	muted map[string]bool
+}
+
+func (m fakeModeration) CheckCanPost(_ context.Context, _ string, username string) error {
+	if m.muted[username] {
+		return models.ErrMutedInRoom
+	}
+
+	return nil
+}
+
+// newTestRoomServer returns a server with one room holding the given users, frames sent to a user
+// are decoded into its channel.
+func newTestRoomServer(t *testing.T, cfg *config.Config, usernames ...string) (*Server, map[string]*models.User, map[string]chan protocol.Envelope) {
+	t.Helper()
+
+	auths, _ := newTestAuthServer(cfg, usernames...)
+
+	s := &Server{
+		cfg:       cfg,
+		log:       logrus.New(),
+		rooms:     map[string]*models.Room{defaultRoom: {Name: defaultRoom, Members: make(map[*models.User]net.Conn)}},
+		mutex:     &sync.Mutex{},
+		connUsers: make(map[net.Conn]*models.User),
+		eventLogs: make(map[string]*eventLog),
+		typing:    make(map[typingKey]*time.Timer),
+
+		authService:       auths.authService,
+		moderationService: fakeModeration{},
+	}
+
+	users := make(map[string]*models.User)
+	frames := make(map[string]chan protocol.Envelope)
+
+	for _, username := range usernames {
+		serverSide, clientSide := net.Pipe()
+		t.Cleanup(func() {
+			serverSide.Close()
+			clientSide.Close()
+		})
+
+		user := &models.User{UserName: username, Conn: serverSide}
+		received := make(chan protocol.Envelope, 16)
+
+		go func() {
+			scanner := bufio.NewScanner(clientSide)
+			for scanner.Scan() {
+				env, err := protocol.Decode(scanner.Bytes())
+				if err == nil {
+					received <- env
+				}
+			}
+		}()
+
+		s.rooms[defaultRoom].Members[user] = serverSide
+		s.connUsers[serverSide] = user
+		users[username] = user
+		frames[username] = received
+	}
+
+	return s, users, frames
+}
+
+func nextTyping(t *testing.T, frames chan protocol.Envelope) protocol.TypingPayload {
+	t.Helper()
+
+	select {
+	case env := <-frames:
+		require.Equal(t, protocol.TypeTyping, env.Type)
+
+		var typing protocol.TypingPayload
+		require.NoError(t, env.DecodePayload(&typing))
+
+		return typing
+	case <-time.After(time.Second):
+		require.FailNow(t, "no typing frame received")
+
+		return protocol.TypingPayload{}
+	}
+}
+
+func TestTypingIndicatorExpires(t *testing.T) {
+	s, users, frames := newTestRoomServer(t, &config.Config{TypingTimeout: 50 * time.Millisecond}, "alice1", "bobbob")
+
+	require.NoError(t, s.handleTyping(context.Background(), protocol.TypingPayload{Active: true}, users["alice1"]))
+	require.NoError(t, s.handleTyping(context.Background(), protocol.TypingPayload{Active: true}, users["alice1"]))
+
+	typing := nextTyping(t, frames["bobbob"])
+	assert.Equal(t, protocol.TypingPayload{User: "alice1", Room: defaultRoom, Active: true}, typing,
+		"room members should see the typing user")
+
+	typing = nextTyping(t, frames["bobbob"])
+	assert.False(t, typing.Active, "a refresh should not be fanned out, the indicator should expire by itself")
+
+	assert.Empty(t, frames["alice1"], "the typing user should not get its own indicator")
+}
+
+func TestTypingIndicatorStops(t *testing.T) {
+	s, users, frames := newTestRoomServer(t, &config.Config{TypingTimeout: time.Minute}, "alice1", "bobbob")
+
+	require.NoError(t, s.handleTyping(context.Background(), protocol.TypingPayload{Receiver: "bobbob", Active: true}, users["alice1"]))
+	assert.True(t, nextTyping(t, frames["bobbob"]).Active, "the direct message partner should see the typing user")
+
+	require.NoError(t, s.handleTyping(context.Background(), protocol.TypingPayload{Receiver: "bobbob"}, users["alice1"]))
+	assert.False(t, nextTyping(t, frames["bobbob"]).Active, "a stopped indicator should be fanned out at once")
+
+	err := s.handleTyping(context.Background(), protocol.TypingPayload{Receiver: "nobody", Active: true}, users["alice1"])
+	assert.ErrorIs(t, err, models.ErrUserNotFound, "typing to an unknown user should fail")
+
+	err = s.handleTyping(context.Background(), protocol.TypingPayload{Room: "random", Active: true}, users["alice1"])
+	assert.ErrorIs(t, err, models.ErrNotRoomMember, "typing to a room the user is not in should fail")
+}
+
+func TestTypingWhileMuted(t *testing.T) {
+	s, users, frames := newTestRoomServer(t, &config.Config{TypingTimeout: time.Minute}, "alice1", "bobbob")
+	ctx := context.Background()
+
+	require.NoError(t, s.handleTyping(ctx, protocol.TypingPayload{Active: true}, users["alice1"]))
+	assert.True(t, nextTyping(t, frames["bobbob"]).Active)
+
+	s.moderationService = fakeModeration{muted: map[string]bool{"alice1": true}}
+
+	require.NoError(t, s.handleTyping(ctx, protocol.TypingPayload{}, users["alice1"]),
+		"an indicator started before the mute should still stop")
+	assert.False(t, nextTyping(t, frames["bobbob"]).Active)
+
+	err := s.handleTyping(ctx, protocol.TypingPayload{Active: true}, users["alice1"])
+	assert.ErrorIs(t, err, models.ErrMutedInRoom, "muted users should not show as typing")
+	assert.Empty(t, frames["bobbob"])
+}
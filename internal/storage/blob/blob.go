@@ -0,0 +1,115 @@
+// Package blob stores the content of uploaded files apart from the database.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store keeps blobs under keys chosen by the caller.
+type Store interface {
+	// Put stores the content read from r under the key and returns its size, replacing any blob stored before.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open reads the blob, it fails with ErrNotFound when there is none under the key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob, deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalStore keeps every blob in a file named after its key in one directory.
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore creates the directory when it does not exist yet.
+func NewLocalStore(dir string) (Store, error) {
+	const dirPerm = 0o750
+
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("blob NewLocalStore os.MkdirAll(...): %w", err)
+	}
+
+	return &LocalStore{dir: dir}, nil
+}
+
+// Put writes to a temporary file first so a failed upload never leaves a partial blob behind.
+func (s *LocalStore) Put(_ context.Context, key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return 0, fmt.Errorf("blob LocalStore.Put os.CreateTemp(...): %w", err)
+	}
+
+	defer os.Remove(tmp.Name()) //nolint:errcheck // Gone already after a successful rename.
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close() //nolint:errcheck,gosec
+
+		return 0, fmt.Errorf("blob LocalStore.Put io.Copy(...): %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("blob LocalStore.Put tmp.Close(): %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("blob LocalStore.Put os.Rename(...): %w", err)
+	}
+
+	return size, nil
+}
+
+func (s *LocalStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("blob LocalStore.Open %q: %w", key, ErrNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("blob LocalStore.Open os.Open(...): %w", err)
+	}
+
+	return file, nil
+}
+
+func (s *LocalStore) Delete(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("blob LocalStore.Delete os.Remove(...): %w", err)
+	}
+
+	return nil
+}
+
+// path maps the key to its file, keys must not reach outside the directory or collide with temporary files.
+func (s *LocalStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, ".") || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("blob key %q: %w", key, ErrInvalidKey)
+	}
+
+	return filepath.Join(s.dir, key), nil
+}
@@ -0,0 +1,56 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewLocalStore(dir)
+	require.NoError(t, err)
+
+	size, err := store.Put(ctx, "abc123", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	r, err := store.Open(ctx, "abc123")
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "hello", string(content))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+
+	require.NoError(t, store.Delete(ctx, "abc123"))
+	require.NoError(t, store.Delete(ctx, "abc123"))
+
+	_, err = store.Open(ctx, "abc123")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStoreInvalidKey(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../secret", "a/b", ".upload-1"} {
+		_, err := store.Put(context.Background(), key, strings.NewReader("x"))
+		require.ErrorIs(t, err, ErrInvalidKey, key)
+	}
+}
@@ -0,0 +1,24 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE messages ADD COLUMN reply_to INTEGER REFERENCES messages (message_id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN thread_id INTEGER REFERENCES messages (message_id) ON DELETE CASCADE;
+
+CREATE INDEX messages_thread_idx ON messages (thread_id, message_id) WHERE thread_id IS NOT NULL;
+
+CREATE TABLE thread_subscriptions (
+    thread_id INTEGER NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (thread_id, user_id)
+);
+
+ALTER TABLE room_members ADD COLUMN silenced BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +migrate Down
+
+ALTER TABLE room_members DROP COLUMN IF EXISTS silenced;
+DROP TABLE IF EXISTS thread_subscriptions;
+DROP INDEX IF EXISTS messages_thread_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS thread_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to;
@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+CREATE TABLE message_mentions (
+    message_id INTEGER NOT NULL REFERENCES messages (message_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, message_id)
+);
+
+-- +migrate Down
+
+DROP TABLE IF EXISTS message_mentions;
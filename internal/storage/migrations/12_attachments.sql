@@ -0,0 +1,18 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+CREATE TABLE attachments (
+    attachment_id TEXT PRIMARY KEY,
+    uploader_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    message_id INTEGER REFERENCES messages (message_id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    mime_type TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX attachments_message_idx ON attachments (message_id);
+
+-- +migrate Down
+
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,27 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE rooms ADD COLUMN private BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE room_members ADD COLUMN role TEXT NOT NULL DEFAULT 'member'
+    CHECK (role IN ('owner', 'moderator', 'member'));
+
+UPDATE room_members rm SET role = 'owner'
+FROM rooms r
+WHERE r.room_id = rm.room_id AND r.created_by = rm.user_id;
+
+CREATE TABLE room_invitations (
+    room_id INTEGER NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+    invitee_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    inviter_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (room_id, invitee_id)
+);
+
+CREATE INDEX room_invitations_invitee_idx ON room_invitations (invitee_id);
+
+-- +migrate Down
+
+DROP TABLE IF EXISTS room_invitations;
+ALTER TABLE room_members DROP COLUMN IF EXISTS role;
+ALTER TABLE rooms DROP COLUMN IF EXISTS private;
@@ -0,0 +1,31 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+CREATE TABLE room_sanctions (
+    room_id INTEGER NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('ban', 'mute')),
+    moderator_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (room_id, user_id, kind)
+);
+
+CREATE TABLE moderation_actions (
+    action_id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+    moderator_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    target_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    action TEXT NOT NULL CHECK (action IN ('kick', 'ban', 'unban', 'mute', 'unmute')),
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX moderation_actions_room_idx ON moderation_actions (room_id, action_id);
+
+-- +migrate Down
+
+DROP TABLE IF EXISTS moderation_actions;
+DROP TABLE IF EXISTS room_sanctions;
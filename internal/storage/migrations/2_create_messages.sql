@@ -0,0 +1,37 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+CREATE TABLE rooms (
+    room_id SERIAL PRIMARY KEY,
+    name VARCHAR UNIQUE NOT NULL,
+    created_by INTEGER REFERENCES users (user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO rooms (name) VALUES ('general');
+
+CREATE TABLE room_members (
+    room_id INTEGER NOT NULL REFERENCES rooms (room_id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE TABLE messages (
+    message_id SERIAL PRIMARY KEY,
+    room_id INTEGER REFERENCES rooms (room_id) ON DELETE CASCADE,
+    sender_id INTEGER NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    receiver_id INTEGER REFERENCES users (user_id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((room_id IS NULL) <> (receiver_id IS NULL))
+);
+
+CREATE INDEX messages_room_idx ON messages (room_id, message_id);
+CREATE INDEX messages_direct_idx ON messages (sender_id, receiver_id, message_id);
+
+-- +migrate Down
+
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS room_members;
+DROP TABLE IF EXISTS rooms;
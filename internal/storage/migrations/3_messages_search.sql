@@ -0,0 +1,12 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE messages
+    ADD COLUMN content_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', content)) STORED;
+
+CREATE INDEX messages_content_tsv_idx ON messages USING GIN (content_tsv);
+
+-- +migrate Down
+
+DROP INDEX IF EXISTS messages_content_tsv_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS content_tsv;
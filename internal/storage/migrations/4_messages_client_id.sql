@@ -0,0 +1,11 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE messages ADD COLUMN client_id VARCHAR(64);
+
+CREATE UNIQUE INDEX messages_sender_client_id_idx ON messages (sender_id, client_id) WHERE client_id IS NOT NULL;
+
+-- +migrate Down
+
+DROP INDEX IF EXISTS messages_sender_client_id_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_id;
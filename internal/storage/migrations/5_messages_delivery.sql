@@ -0,0 +1,13 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMPTZ;
+
+UPDATE messages SET delivered_at = created_at WHERE receiver_id IS NOT NULL;
+
+CREATE INDEX messages_undelivered_idx ON messages (receiver_id) WHERE receiver_id IS NOT NULL AND delivered_at IS NULL;
+
+-- +migrate Down
+
+DROP INDEX IF EXISTS messages_undelivered_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS delivered_at;
@@ -0,0 +1,8 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE users ADD COLUMN last_seen_at TIMESTAMPTZ;
+
+-- +migrate Down
+
+ALTER TABLE users DROP COLUMN IF EXISTS last_seen_at;
@@ -0,0 +1,19 @@
+-- noinspection SqlNoDataSourceInspectionForFiles
+-- +migrate Up
+
+ALTER TABLE room_members ADD COLUMN last_read_message_id INTEGER NOT NULL DEFAULT 0;
+
+UPDATE room_members rm
+SET last_read_message_id = COALESCE((SELECT MAX(m.message_id) FROM messages m WHERE m.room_id = rm.room_id), 0);
+
+ALTER TABLE messages ADD COLUMN read_at TIMESTAMPTZ;
+
+UPDATE messages SET read_at = created_at WHERE receiver_id IS NOT NULL;
+
+CREATE INDEX messages_unread_idx ON messages (receiver_id) WHERE receiver_id IS NOT NULL AND read_at IS NULL;
+
+-- +migrate Down
+
+DROP INDEX IF EXISTS messages_unread_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS read_at;
+ALTER TABLE room_members DROP COLUMN IF EXISTS last_read_message_id;
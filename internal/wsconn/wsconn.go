@@ -0,0 +1,108 @@
+// Package wsconn adapts a WebSocket connection to net.Conn carrying newline-delimited frames,
+// so the chat server can serve WebSocket clients with the same code as TCP clients.
+package wsconn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn turns every incoming text message into one line and every written line into one text message.
+type Conn struct {
+	ws      *websocket.Conn
+	reader  io.Reader
+	writeMu sync.Mutex
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+func New(ws *websocket.Conn) *Conn {
+	return &Conn{ws: ws}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, r, err := c.ws.NextReader()
+			if err != nil {
+				return 0, fmt.Errorf("wsconn Read c.ws.NextReader(): %w", err)
+			}
+
+			c.reader = io.MultiReader(r, strings.NewReader("\n"))
+		}
+
+		n, err := c.reader.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+
+			if n == 0 {
+				continue
+			}
+
+			err = nil
+		}
+
+		return n, err //nolint:wrapcheck // reading a frame, not an error site of its own.
+	}
+}
+
+// Write sends every complete line of p as a separate text message.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if err := c.ws.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+			return 0, fmt.Errorf("wsconn Write c.ws.WriteMessage(...): %w", err)
+		}
+	}
+
+	return len(p), nil
+}
+
+func (c *Conn) Close() error {
+	if err := c.ws.Close(); err != nil {
+		return fmt.Errorf("wsconn Close: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.ws.NetConn().SetDeadline(t); err != nil {
+		return fmt.Errorf("wsconn SetDeadline: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return fmt.Errorf("wsconn SetReadDeadline: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if err := c.ws.SetWriteDeadline(t); err != nil {
+		return fmt.Errorf("wsconn SetWriteDeadline: %w", err)
+	}
+
+	return nil
+}
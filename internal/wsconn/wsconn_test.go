@@ -0,0 +1,54 @@
+package wsconn
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnFramesLines(t *testing.T) {
+	received := make(chan string, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+
+		conn := New(ws)
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for range 2 {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received <- line
+		}
+
+		_, err = conn.Write([]byte("{\"content\":\"one\"}\n{\"content\":\"two\"}\n"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"content":"hello"}`)))
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"content":"world"}`)))
+
+	assert.Equal(t, "{\"content\":\"hello\"}\n", <-received, "every frame should become one line")
+	assert.Equal(t, "{\"content\":\"world\"}\n", <-received, "every frame should become one line")
+
+	for _, expected := range []string{`{"content":"one"}`, `{"content":"two"}`} {
+		_, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data), "every written line should become one frame")
+	}
+}
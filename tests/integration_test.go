@@ -22,6 +22,8 @@ import (
 const (
 	pathLogin    = "/api/v1/user/login"
 	pathRegister = "/api/v1/user/register"
+	pathRooms    = "/api/v1/rooms"
+	pathDM       = "/api/v1/dm"
 )
 
 type IntegrationTestSuite struct {
@@ -32,6 +34,8 @@ type IntegrationTestSuite struct {
 	ctx        context.Context
 	cfg        *config.Config
 	cancel     context.CancelFunc
+
+	messagesRepo repository.MessagesRepositoryInterface
 }
 
 func (s *IntegrationTestSuite) SetupSuite() {
@@ -59,14 +63,21 @@ func (s *IntegrationTestSuite) SetupSuite() {
 
 	authRepo := repository.NewAuthRepository(s.storage.DB())
 	usersRepo := repository.NewUsersRepository(s.storage.DB())
+	s.messagesRepo = repository.NewMessagesRepository(s.storage.DB())
+	roomsRepo := repository.NewRoomsRepository(s.storage.DB())
+	moderationRepo := repository.NewModerationRepository(s.storage.DB())
 
-	authService := service.NewAuthService(authRepo, s.cfg.SigningKey)
+	authService := service.NewAuthService(authRepo, s.cfg.SigningKeys, s.cfg.AccessTokenTTL, s.cfg.RefreshTokenTTL)
 	usersService := service.NewUsersService(usersRepo, authService)
+	moderationService := service.NewModerationService(moderationRepo, roomsRepo, s.cfg.AdminUsers)
+	messagesService := service.NewMessagesService(s.messagesRepo, roomsRepo, moderationService)
+	roomsService := service.NewRoomsService(roomsRepo, moderationRepo)
 
 	// Use a buffered channel to avoid blocking the goroutine
 	errChan := make(chan error, 1)
 	go func() {
-		s.httpServer = httpserver.CreateServer(s.cfg, s.log, usersService, authService)
+		s.httpServer = httpserver.CreateServer(s.cfg, s.log, usersService, authService, messagesService, nil, roomsService,
+			moderationService, nil, nil, nil)
 		errChan <- s.httpServer.Start(s.ctx)
 	}()
 
@@ -102,6 +113,18 @@ func (s *IntegrationTestSuite) sendRequest(
 ) *http.Response {
 	s.T().Helper()
 
+	return s.sendAuthorizedRequest(ctx, method, endpoint, "", body)
+}
+
+func (s *IntegrationTestSuite) sendAuthorizedRequest(
+	ctx context.Context,
+	method string,
+	endpoint string,
+	token string,
+	body any,
+) *http.Response {
+	s.T().Helper()
+
 	reqBody, err := json.Marshal(body)
 	s.Require().NoError(err)
 	// fmt.Printf("Host: %s, Port: %s\n", s.cfg.AppHost, s.cfg.AppPort)
@@ -114,6 +137,9 @@ func (s *IntegrationTestSuite) sendRequest(
 	s.Require().NoError(err)
 
 	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	s.Require().NoError(err)
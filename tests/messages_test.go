@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/stsolovey/kvant_chat/internal/app/repository"
+	"github.com/stsolovey/kvant_chat/internal/models"
+)
+
+func (s *IntegrationTestSuite) registerUser(username string, password string) string {
+	s.T().Helper()
+
+	resp := s.sendRequest(s.ctx, http.MethodPost, pathRegister, models.UserRegisterInput{
+		UserName:     username,
+		HashPassword: password,
+	})
+	defer resp.Body.Close()
+
+	s.Require().Equal(http.StatusCreated, resp.StatusCode)
+
+	var response struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&response))
+
+	return response.Data.Token
+}
+
+func (s *IntegrationTestSuite) decodeHistoryPage(resp *http.Response) models.HistoryPage {
+	s.T().Helper()
+
+	var response struct {
+		Data models.HistoryPage `json:"data"`
+	}
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&response))
+
+	return response.Data
+}
+
+func (s *IntegrationTestSuite) TestMessagesHistory() {
+	s.Require().NoError(s.truncateTables())
+	s.Require().NoError(repository.NewRoomsRepository(s.storage.DB()).Ensure(s.ctx, "general"))
+
+	aliceToken := s.registerUser("AliceUser", "AlicePassword1.")
+	s.registerUser("BobbyUser", "BobbyPassword1.")
+
+	for i := range 5 {
+		_, err := s.messagesRepo.Create(s.ctx, models.Message{
+			Room: "general", Sender: "AliceUser", Content: fmt.Sprintf("release note %d", i+1),
+		})
+		s.Require().NoError(err)
+	}
+
+	_, err := s.messagesRepo.Create(s.ctx, models.Message{
+		Room: "general", Sender: "BobbyUser", Content: "deploy is done",
+	})
+	s.Require().NoError(err)
+
+	_, err = s.messagesRepo.Create(s.ctx, models.Message{
+		Receiver: "AliceUser", Sender: "BobbyUser", Content: "on-call handoff",
+	})
+	s.Require().NoError(err)
+
+	s.Run("unauthorized without token", func() {
+		resp := s.sendRequest(s.ctx, http.MethodGet, pathRooms+"/general/messages", nil)
+		defer resp.Body.Close()
+
+		s.Assert().Equal(http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	s.Run("room history pages", func() {
+		resp := s.sendAuthorizedRequest(s.ctx, http.MethodGet, pathRooms+"/general/messages?limit=4", aliceToken, nil)
+		defer resp.Body.Close()
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		page := s.decodeHistoryPage(resp)
+		s.Require().Len(page.Messages, 4)
+		s.Assert().Equal("deploy is done", page.Messages[3].Content)
+		s.Require().NotZero(page.NextCursor)
+
+		endpoint := fmt.Sprintf("%s/general/messages?limit=4&before=%d", pathRooms, page.NextCursor)
+		resp = s.sendAuthorizedRequest(s.ctx, http.MethodGet, endpoint, aliceToken, nil)
+		defer resp.Body.Close()
+
+		page = s.decodeHistoryPage(resp)
+		s.Assert().Len(page.Messages, 2)
+		s.Assert().Zero(page.NextCursor)
+	})
+
+	s.Run("room history search", func() {
+		resp := s.sendAuthorizedRequest(s.ctx, http.MethodGet, pathRooms+"/general/messages?q=deploy", aliceToken, nil)
+		defer resp.Body.Close()
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		page := s.decodeHistoryPage(resp)
+		s.Require().Len(page.Messages, 1)
+		s.Assert().Equal("BobbyUser", page.Messages[0].Sender)
+	})
+
+	s.Run("direct messages", func() {
+		resp := s.sendAuthorizedRequest(s.ctx, http.MethodGet, pathDM+"/BobbyUser/messages", aliceToken, nil)
+		defer resp.Body.Close()
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+
+		page := s.decodeHistoryPage(resp)
+		s.Require().Len(page.Messages, 1)
+		s.Assert().Equal("on-call handoff", page.Messages[0].Content)
+	})
+
+	s.Run("invalid limit", func() {
+		resp := s.sendAuthorizedRequest(s.ctx, http.MethodGet, pathRooms+"/general/messages?limit=1000", aliceToken, nil)
+		defer resp.Body.Close()
+
+		s.Assert().Equal(http.StatusBadRequest, resp.StatusCode)
+	})
+}